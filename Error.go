@@ -1,6 +1,9 @@
 package imagecapture
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 /*
 * @Author: zouyx
@@ -36,4 +39,40 @@ var (
 	ErrUnsupportedFileType     = errors.New("unsupported file type")      // 文件类型不支持
 	ErrContentTooLarge         = errors.New("content size exceeds limit") // 内容大小超过限制
 	ErrContentChecksumMismatch = errors.New("content checksum mismatch")  // 校验和不匹配，可能数据损坏
+
+	// 搜索参数相关错误
+	ErrUnsupportedEngine = errors.New("unsupported search engine") // 不支持的搜索引擎
+	ErrUnsupportedOption = errors.New("unsupported search option") // 搜索引擎不支持该筛选条件
+	ErrCaptureClosed     = errors.New("capture closed")            // 采集器已关闭
+
+	// 下载队列相关错误
+	ErrQueueClosed = errors.New("queue closed") // 队列已关闭
 )
+
+// StatusError 下载时服务端返回了非 200 状态码
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("download [%s] failed: %s", e.URL, e.Status)
+}
+
+// 重试次数用尽，保留最后一次请求的错误
+type retryError struct {
+	err error
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrMaxRetryExceeded, e.err)
+}
+
+func (e *retryError) Is(target error) bool {
+	return target == ErrMaxRetryExceeded
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
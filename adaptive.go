@@ -0,0 +1,208 @@
+package imagecapture
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/8 下午3:00
+* @Package: 自适应并发
+ */
+
+const (
+	defaultMaxErrorRate = 0.1
+	defaultBackoff      = 0.5
+)
+
+// AdaptiveConfig AIMD 自适应并发配置：每完成一轮请求（数量等于当前并发数），延迟和错误率正常时并发数加 1；
+// 遇到超时、429/503 或连接被重置时立即乘以 Backoff 缩减，并发数始终在 [Min, Max] 之间
+type AdaptiveConfig struct {
+	Min int // 最小并发数，默认 1
+	Max int // 最大并发数，默认为初始并发数的 4 倍
+	// 一轮请求的平均延迟超过该值时不再增加并发，为 0 时以观测到的最低平均延迟的 2 倍为准
+	LatencyTarget time.Duration
+	MaxErrorRate  float64 // 一轮请求的错误率超过该值时不再增加并发，默认 0.1
+	Backoff       float64 // 过载时并发数的缩减比例，取值 (0, 1)，默认 0.5
+}
+
+// PoolStats 协程池的并发数
+type PoolStats struct {
+	Concurrency int  `json:"concurrency"` // 当前并发数
+	Min         int  `json:"min"`
+	Max         int  `json:"max"`
+	Adaptive    bool `json:"adaptive"` // 是否开启了自适应并发
+}
+
+// CaptureStats 采集器搜索和批量下载的并发数
+type CaptureStats struct {
+	Search   PoolStats `json:"search"`
+	Download PoolStats `json:"download"`
+}
+
+// WithAdaptiveSearch 搜索分页的并发数按 AIMD 自适应调整，从 NewBaiduCapture、NewBingCapture 传入的并发数开始
+func WithAdaptiveSearch(config AdaptiveConfig) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.adaptiveSearch = &config
+	}
+}
+
+// WithAdaptiveDownload 批量下载的并发数按 AIMD 自适应调整，从 WithDownloadRoutines 设置的并发数开始
+func WithAdaptiveDownload(config AdaptiveConfig) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.adaptive = &config
+		})
+	}
+}
+
+// concurrencyLimiter 并发数控制，未开启自适应时固定为初始值。并发安全
+type concurrencyLimiter struct {
+	mu       sync.Mutex
+	config   AdaptiveConfig
+	adaptive bool
+	current  int
+	// 当前一轮的样本
+	samples  int
+	failures int
+	latency  time.Duration
+	baseline time.Duration // 观测到的最低一轮平均延迟
+	cooldown int           // 缩减后需要忽略的样本数，这些请求在缩减前就已经发出
+}
+
+// config 为 nil 时并发数固定为 initial
+func newConcurrencyLimiter(initial int, config *AdaptiveConfig) *concurrencyLimiter {
+	if initial <= 0 {
+		initial = 1
+	}
+	if config == nil {
+		return &concurrencyLimiter{config: AdaptiveConfig{Min: initial, Max: initial}, current: initial}
+	}
+	l := &concurrencyLimiter{config: *config, adaptive: true}
+	if l.config.Min <= 0 {
+		l.config.Min = 1
+	}
+	if l.config.Max <= 0 {
+		l.config.Max = initial * 4
+	}
+	if l.config.Max < l.config.Min {
+		l.config.Max = l.config.Min
+	}
+	if l.config.MaxErrorRate <= 0 {
+		l.config.MaxErrorRate = defaultMaxErrorRate
+	}
+	if l.config.Backoff <= 0 || l.config.Backoff >= 1 {
+		l.config.Backoff = defaultBackoff
+	}
+	l.current = l.clamp(initial)
+	return l
+}
+
+func (l *concurrencyLimiter) clamp(n int) int {
+	if n < l.config.Min {
+		return l.config.Min
+	}
+	if n > l.config.Max {
+		return l.config.Max
+	}
+	return n
+}
+
+// limit 当前允许的并发数
+func (l *concurrencyLimiter) limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current
+}
+
+func (l *concurrencyLimiter) stats() PoolStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return PoolStats{Concurrency: l.current, Min: l.config.Min, Max: l.config.Max, Adaptive: l.adaptive}
+}
+
+// observe 记录一次请求的耗时和结果。调用方取消的请求不计入
+func (l *concurrencyLimiter) observe(elapsed time.Duration, err error) {
+	if !l.adaptive || errors.Is(err, context.Canceled) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cooldown > 0 {
+		l.cooldown--
+		return
+	}
+	if isOverload(err) {
+		l.cooldown = l.current
+		l.current = l.clamp(int(float64(l.current) * l.config.Backoff))
+		l.reset()
+		return
+	}
+	l.samples++
+	l.latency += elapsed
+	if isFailure(err) {
+		l.failures++
+	}
+	if l.samples < l.current {
+		return
+	}
+	average := l.latency / time.Duration(l.samples)
+	healthy := float64(l.failures)/float64(l.samples) <= l.config.MaxErrorRate
+	if l.config.LatencyTarget > 0 {
+		healthy = healthy && average <= l.config.LatencyTarget
+	} else {
+		if l.baseline == 0 || average < l.baseline {
+			l.baseline = average
+		}
+		healthy = healthy && average <= 2*l.baseline
+	}
+	if healthy {
+		l.current = l.clamp(l.current + 1)
+	}
+	l.reset()
+}
+
+func (l *concurrencyLimiter) reset() {
+	l.samples, l.failures, l.latency = 0, 0, 0
+}
+
+// observePage 包装搜索分页的请求，记录耗时和结果，非 2xx 的状态码按 StatusError 处理
+func (l *concurrencyLimiter) observePage(fetch func(ctx context.Context) (int, error)) func(ctx context.Context) (int, error) {
+	return func(ctx context.Context) (int, error) {
+		start := time.Now()
+		status, err := fetch(ctx)
+		observed := err
+		if observed == nil && status >= 300 {
+			observed = &StatusError{StatusCode: status, Status: http.StatusText(status)}
+		}
+		l.observe(time.Since(start), observed)
+		return status, err
+	}
+}
+
+// 服务端过载的信号：超时、429、503、连接被重置
+func isOverload(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusServiceUnavailable
+	}
+	return ErrorClass(err) == ErrorClassTimeout || errors.Is(err, syscall.ECONNRESET)
+}
+
+// 计入错误率的失败：网络错误和 5xx，4xx、文件已存在等与并发数无关的错误不计入
+func isFailure(err error) bool {
+	switch ErrorClass(err) {
+	case ErrorClassNetwork, ErrorClassHTTP5xx:
+		return true
+	}
+	return false
+}
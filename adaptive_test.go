@@ -0,0 +1,125 @@
+package imagecapture
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	tooMany := &StatusError{StatusCode: http.StatusTooManyRequests}
+	serverError := &StatusError{StatusCode: http.StatusInternalServerError}
+	reset := fmt.Errorf("read: %w", syscall.ECONNRESET)
+	type sample struct {
+		elapsed time.Duration
+		err     error
+	}
+	repeat := func(n int, s sample) []sample {
+		samples := make([]sample, n)
+		for i := range samples {
+			samples[i] = s
+		}
+		return samples
+	}
+	ok := sample{elapsed: 10 * time.Millisecond}
+	tests := []struct {
+		name    string
+		initial int
+		config  *AdaptiveConfig
+		samples []sample
+		want    int
+	}{
+		{"fixed", 4, nil, repeat(20, ok), 4},
+		{"fixed ignores overload", 4, nil, []sample{{err: tooMany}}, 4},
+		{"additive increase per round", 2, &AdaptiveConfig{Max: 10}, repeat(2+3+4, ok), 5},
+		{"incomplete round", 2, &AdaptiveConfig{Max: 10}, repeat(1, ok), 2},
+		{"max bound", 2, &AdaptiveConfig{Max: 3}, repeat(20, ok), 3},
+		{"initial clamped", 20, &AdaptiveConfig{Min: 2, Max: 8}, nil, 8},
+		{"backoff on 429", 8, &AdaptiveConfig{}, []sample{{err: tooMany}}, 4},
+		{"backoff on timeout", 8, &AdaptiveConfig{}, []sample{{err: context.DeadlineExceeded}}, 4},
+		{"backoff on connection reset", 8, &AdaptiveConfig{}, []sample{{err: &retryError{err: reset}}}, 4},
+		{"custom backoff", 8, &AdaptiveConfig{Backoff: 0.75}, []sample{{err: tooMany}}, 6},
+		{"min bound", 2, &AdaptiveConfig{Min: 2}, []sample{{err: tooMany}}, 2},
+		// 缩减后忽略之前已经发出的 8 个请求，之后的 429 再次缩减
+		{"cooldown", 8, &AdaptiveConfig{}, append(repeat(9, sample{err: tooMany}), sample{err: tooMany}), 2},
+		{"canceled ignored", 2, &AdaptiveConfig{}, append(repeat(10, sample{err: context.Canceled}), ok), 2},
+		{"errors above rate", 2, &AdaptiveConfig{}, repeat(4, sample{elapsed: time.Millisecond, err: serverError}), 2},
+		{"client errors not counted", 2, &AdaptiveConfig{}, repeat(2, sample{elapsed: time.Millisecond, err: &StatusError{StatusCode: 404}}), 3},
+		{"latency above target", 2, &AdaptiveConfig{LatencyTarget: 5 * time.Millisecond}, repeat(4, ok), 2},
+		{"latency above baseline", 2, &AdaptiveConfig{}, append(repeat(2, ok), repeat(3, sample{elapsed: time.Second})...), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newConcurrencyLimiter(tt.initial, tt.config)
+			for _, s := range tt.samples {
+				l.observe(s.elapsed, s.err)
+			}
+			if got := l.limit(); got != tt.want {
+				t.Errorf("limit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiter_ObservePage(t *testing.T) {
+	l := newConcurrencyLimiter(4, &AdaptiveConfig{})
+	fetch := l.observePage(func(ctx context.Context) (int, error) {
+		return http.StatusTooManyRequests, nil
+	})
+	if status, err := fetch(context.Background()); status != http.StatusTooManyRequests || err != nil {
+		t.Errorf("fetch() = %d, %v", status, err)
+	}
+	if got := l.limit(); got != 2 {
+		t.Errorf("limit() after 429 page = %d, want 2", got)
+	}
+}
+
+func TestAdaptiveDownload_Stats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/limited.png" {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.Write(testPNG)
+	}))
+	defer server.Close()
+
+	bc := NewBaiduCapture(2, WithDownloadRoutines(4), WithHostConcurrency(0),
+		WithAdaptiveSearch(AdaptiveConfig{Max: 6}),
+		WithAdaptiveDownload(AdaptiveConfig{Min: 2, Max: 16, LatencyTarget: time.Second}))
+	stats := bc.Stats()
+	want := CaptureStats{
+		Search:   PoolStats{Concurrency: 2, Min: 1, Max: 6, Adaptive: true},
+		Download: PoolStats{Concurrency: 4, Min: 2, Max: 16, Adaptive: true},
+	}
+	if stats != want {
+		t.Fatalf("Stats() = %+v, want %+v", stats, want)
+	}
+
+	var urls []string
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("%s/%d.png", server.URL, i))
+	}
+	if _, err := bc.BatchDownload(urls, t.TempDir(), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.Stats().Download.Concurrency; got <= 4 {
+		t.Errorf("concurrency after healthy downloads = %d, want > 4", got)
+	}
+	before := bc.Stats().Download.Concurrency
+	if _, err := bc.BatchDownload([]string{server.URL + "/limited.png"}, t.TempDir(), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.Stats().Download.Concurrency; got != before/2 && got != 2 {
+		t.Errorf("concurrency after 429 = %d, was %d", got, before)
+	}
+
+	fixed := NewBingCapture(3).Stats()
+	if fixed.Search != (PoolStats{Concurrency: 3, Min: 3, Max: 3}) || fixed.Download.Concurrency != maxDownloadRoutines {
+		t.Errorf("fixed Stats() = %+v", fixed)
+	}
+}
@@ -0,0 +1,393 @@
+package imagecapture
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/22 上午10:00
+* @Package:
+ */
+
+// 归档格式
+const (
+	ArchiveTar     = "tar"
+	ArchiveTarGzip = "tar.gz"
+	ArchiveZip     = "zip"
+)
+
+// 每个分片内的清单文件名
+const archiveManifestFile = "manifest.jsonl"
+
+// Compressor 自定义 tar 分片的压缩方式，例如 zstd：
+//
+//	imagecapture.WithCompressor("tar.zst", func(w io.Writer) (io.WriteCloser, error) {
+//		return zstd.NewWriter(w)
+//	})
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+// ArchiveOption 归档选项
+type ArchiveOption func(*Archive)
+
+// WithArchivePrefix 分片文件名前缀，默认 images，生成 images-000000.tar ...
+func WithArchivePrefix(prefix string) ArchiveOption {
+	return func(a *Archive) {
+		a.prefix = prefix
+	}
+}
+
+// WithShardBytes 单个分片的大小上限（按未压缩的图片大小计算），默认 1GB。
+// 单张图片超过上限时独占一个分片
+func WithShardBytes(n int64) ArchiveOption {
+	return func(a *Archive) {
+		if n > 0 {
+			a.maxBytes = n
+		}
+	}
+}
+
+// WithCompressor 使用自定义压缩方式写 tar 分片，ext 为分片文件后缀，例如 tar.zst
+func WithCompressor(ext string, c Compressor) ArchiveOption {
+	return func(a *Archive) {
+		a.format, a.ext, a.compress = ArchiveTar, ext, c
+	}
+}
+
+// Archive 将下载的图片直接写入按大小滚动的 tar/zip 分片，不在磁盘上生成单独的图片文件，
+// 每个分片末尾附带该分片内图片的 manifest.jsonl。通过 WithArchive 传给 BatchDownload、DownloadResults，
+// 可并发写入，同一时间只有一个文件在写入分片（下载的图片先在分片外暂存，下载完成后再写入），使用完必须 Close
+type Archive struct {
+	mu       sync.Mutex
+	dir      string
+	prefix   string
+	format   string
+	ext      string
+	compress Compressor
+	maxBytes int64
+	shards   []string
+	next     int // 下一个分片序号
+
+	// 当前分片
+	file    *os.File
+	cw      io.WriteCloser // 压缩层，可能为 nil
+	tw      *tar.Writer
+	zw      *zip.Writer
+	written int64
+	records []ManifestRecord
+}
+
+// NewArchive 在 dir 目录下创建归档，format 为 ArchiveTar、ArchiveTarGzip 或 ArchiveZip
+func NewArchive(dir, format string, opts ...ArchiveOption) (*Archive, error) {
+	a := &Archive{dir: dir, prefix: "images", format: format, ext: format, maxBytes: 1 << 30}
+	switch format {
+	case ArchiveTar, ArchiveZip:
+	case ArchiveTarGzip:
+		a.format, a.compress = ArchiveTar, func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+	for _, option := range opts {
+		option(a)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %w", err)
+	}
+	return a, nil
+}
+
+// Add 写入一个文件，返回所在分片的路径。record 为空时不写入分片内的清单
+func (a *Archive) Add(name string, data []byte, record *ManifestRecord) (string, error) {
+	return a.AddReader(name, int64(len(data)), bytes.NewReader(data), record)
+}
+
+// AddReader 从 r 读取 size 字节直接写入分片，返回所在分片的路径。
+// tar 需要在文件头中写入大小，size < 0 时先读入内存；zip 不需要事先知道大小。
+// 读取或写入失败时关闭当前分片，之后的文件写入新的分片
+func (a *Archive) AddReader(name string, size int64, r io.Reader, record *ManifestRecord) (string, error) {
+	if !a.streamable(size) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		size, r = int64(len(data)), bytes.NewReader(data)
+	}
+	modTime := time.Now()
+	if record != nil && !record.FetchedAt.IsZero() {
+		modTime = record.FetchedAt
+	}
+	entry, err := a.create(name, size, modTime)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(entry, r)
+	return entry.close(err, record)
+}
+
+// 单张图片暂存在内存中的上限，超过后写入临时文件
+const archiveSpoolMemory = 4 << 20
+
+// archiveSpool 下载时暂存一张图片，写入分片前不需要持有 Archive 的锁。
+// 超过 archiveSpoolMemory 的部分写入归档目录下的临时文件，用完必须 close
+type archiveSpool struct {
+	dir  string
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (a *Archive) newSpool() *archiveSpool {
+	return &archiveSpool{dir: a.dir}
+}
+
+func (s *archiveSpool) Write(p []byte) (int, error) {
+	if s.file == nil && s.buf.Len()+len(p) > archiveSpoolMemory {
+		file, err := os.CreateTemp(s.dir, ".spool-*")
+		if err != nil {
+			return 0, err
+		}
+		s.file = file
+		if _, err = file.Write(s.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		s.buf = bytes.Buffer{}
+	}
+	var (
+		n   int
+		err error
+	)
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// 从头读取暂存的数据
+func (s *archiveSpool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return &s.buf, nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return s.file, nil
+}
+
+// 删除临时文件
+func (s *archiveSpool) close() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
+
+// 大小为 size 的文件能否不经缓存直接写入分片
+func (a *Archive) streamable(size int64) bool {
+	return size >= 0 || a.format == ArchiveZip
+}
+
+// 正在写入分片的文件，创建后持有 Archive 的锁，直到 close
+type archiveEntry struct {
+	a       *Archive
+	name    string
+	w       io.Writer
+	size    int64 // 文件头中的大小，未知时为 -1
+	written int64
+}
+
+// create 按需滚动分片并写入文件头，之后必须调用 close 释放锁
+func (a *Archive) create(name string, size int64, modTime time.Time) (*archiveEntry, error) {
+	a.mu.Lock()
+	if a.file != nil && a.written > 0 && a.written+size > a.maxBytes {
+		if err := a.closeShard(); err != nil {
+			a.mu.Unlock()
+			return nil, err
+		}
+	}
+	if a.file == nil {
+		if err := a.openShard(); err != nil {
+			a.mu.Unlock()
+			return nil, err
+		}
+	}
+	// 图片本身已经压缩过，zip 中直接存储
+	w, err := a.entryWriter(name, size, modTime, zip.Store)
+	if err != nil {
+		// 文件头可能只写了一半，不再向这个分片写入
+		a.closeShard()
+		a.mu.Unlock()
+		return nil, err
+	}
+	return &archiveEntry{a: a, name: name, w: w, size: size}, nil
+}
+
+func (e *archiveEntry) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	e.written += int64(n)
+	return n, err
+}
+
+// close 结束写入并释放锁，err 为读取或写入数据时的错误。
+// 失败时 tar 用 0 补齐文件头中的大小，保证已写入的文件和分片清单可读，然后关闭分片；
+// 这个不完整的文件不记入分片清单
+func (e *archiveEntry) close(err error, record *ManifestRecord) (string, error) {
+	a := e.a
+	defer a.mu.Unlock()
+	if err == nil && e.size >= 0 && e.written != e.size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		if a.tw != nil && e.written < e.size {
+			io.CopyN(a.tw, zeroReader{}, e.size-e.written)
+		}
+		a.closeShard()
+		return "", err
+	}
+	a.written += e.written
+	if record != nil {
+		r := *record
+		r.File, r.Archive = e.name, ""
+		a.records = append(a.records, r)
+	}
+	return a.shards[len(a.shards)-1], nil
+}
+
+// 无限的 0 字节
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Shards 返回已生成的分片路径
+func (a *Archive) Shards() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.shards...)
+}
+
+// Close 写入最后一个分片的清单并关闭
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.closeShard()
+}
+
+// 已存在的分片（例如上次运行生成的）不会被覆盖，序号顺延
+func (a *Archive) openShard() error {
+	var (
+		name string
+		file *os.File
+		err  error
+	)
+	for {
+		name = filepath.Join(a.dir, fmt.Sprintf("%s-%06d.%s", a.prefix, a.next, a.ext))
+		a.next++
+		file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	var w io.Writer = file
+	if a.compress != nil {
+		if a.cw, err = a.compress(file); err != nil {
+			file.Close()
+			return err
+		}
+		w = a.cw
+	}
+	if a.format == ArchiveZip {
+		a.zw = zip.NewWriter(w)
+	} else {
+		a.tw = tar.NewWriter(w)
+	}
+	a.file, a.written, a.records = file, 0, nil
+	a.shards = append(a.shards, name)
+	return nil
+}
+
+// 写入文件头，返回写入文件数据的 writer
+func (a *Archive) entryWriter(name string, size int64, modTime time.Time, method uint16) (io.Writer, error) {
+	if a.zw != nil {
+		return a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modTime})
+	}
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+func (a *Archive) writeEntry(name string, data []byte, modTime time.Time, method uint16) error {
+	w, err := a.entryWriter(name, int64(len(data)), modTime, method)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// 写入分片内的清单，依次关闭 tar/zip、压缩层和文件
+func (a *Archive) closeShard() error {
+	var err error
+	if len(a.records) > 0 {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		for _, r := range a.records {
+			if err = encoder.Encode(r); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = a.writeEntry(archiveManifestFile, buf.Bytes(), time.Now(), zip.Deflate)
+		}
+	}
+	closers := []io.Closer{a.file}
+	if a.cw != nil {
+		closers = append([]io.Closer{a.cw}, closers...)
+	}
+	if a.zw != nil {
+		closers = append([]io.Closer{a.zw}, closers...)
+	} else {
+		closers = append([]io.Closer{a.tw}, closers...)
+	}
+	for _, c := range closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	a.file, a.cw, a.tw, a.zw, a.records = nil, nil, nil, nil, nil
+	return err
+}
+
+// 归档内的路径：统一使用 /，去掉开头的 / 和越界的 ..
+func archiveName(dir, file string) string {
+	return strings.TrimPrefix(path.Clean("/"+path.Join(filepath.ToSlash(dir), file)), "/")
+}
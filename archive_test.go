@@ -0,0 +1,353 @@
+package imagecapture
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/22 下午2:00
+* @Package:
+ */
+
+// 读取分片中的所有文件
+func readShard(t *testing.T, filename string) map[string][]byte {
+	files := make(map[string][]byte)
+	switch filepath.Ext(filename) {
+	case ".zip":
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name], _ = io.ReadAll(rc)
+			rc.Close()
+		}
+		return files
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if filepath.Ext(filename) == ".gz" {
+		if r, err = gzip.NewReader(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name], _ = io.ReadAll(tr)
+	}
+}
+
+func shardManifest(t *testing.T, data []byte) []ManifestRecord {
+	var records []ManifestRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var r ManifestRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestArchive_Rotate(t *testing.T) {
+	for _, format := range []string{ArchiveTar, ArchiveTarGzip, ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			a, err := NewArchive(dir, format, WithShardBytes(10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 每个分片最多 10 字节：a b 在第一个分片，c 超过上限独占第二个分片，d 在第三个分片
+			for _, f := range []struct{ name, data string }{{"a", "12345"}, {"b", "12345"}, {"c", "123456789012"}, {"d", "1"}} {
+				if _, err = a.Add(f.name, []byte(f.data), &ManifestRecord{URL: "https://example.com/" + f.name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = a.Close(); err != nil {
+				t.Fatal(err)
+			}
+			shards := a.Shards()
+			if len(shards) != 3 || filepath.Base(shards[0]) != "images-000000."+format {
+				t.Fatalf("Shards() = %v", shards)
+			}
+			files := readShard(t, shards[0])
+			if string(files["a"]) != "12345" || string(files["b"]) != "12345" || len(files) != 3 {
+				t.Errorf("shard 0 = %v", files)
+			}
+			records := shardManifest(t, files[archiveManifestFile])
+			if len(records) != 2 || records[1].File != "b" || records[1].URL != "https://example.com/b" {
+				t.Errorf("shard 0 manifest = %+v", records)
+			}
+			if files = readShard(t, shards[1]); string(files["c"]) != "123456789012" {
+				t.Errorf("shard 1 = %v", files)
+			}
+			// 同一目录再次写入不会覆盖已有的分片
+			a, _ = NewArchive(dir, format)
+			a.Add("e", []byte("1"), nil)
+			a.Close()
+			if shards = a.Shards(); len(shards) != 1 || filepath.Base(shards[0]) != "images-000003."+format {
+				t.Errorf("Shards() after reopen = %v", shards)
+			}
+		})
+	}
+}
+
+func TestDownloadResults_Archive(t *testing.T) {
+	server, _ := newBingServer(t)
+	dir := t.TempDir()
+	a, err := NewArchive(dir, ArchiveTarGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := NewManifest(filepath.Join(dir, "manifest.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewBingCapture(1).(*BingCapture)
+	results := []Result{{URL: server.URL + "/full.jpg", Engine: EngineBing, Keyword: "老虎"}}
+	paths, err := d.DownloadResults(results, "./老虎", true, WithArchive(a), WithManifest(manifest))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("DownloadResults() = %v, %v", paths, err)
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	manifest.Close()
+	if _, err = os.Stat("老虎"); !os.IsNotExist(err) {
+		t.Errorf("DownloadResults() created a directory for archived images")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("archive dir = %v", entries)
+	}
+	files := readShard(t, a.Shards()[0])
+	if !bytes.Equal(files[paths[0]], testPNG) {
+		t.Fatalf("archived %s = %v", paths[0], files)
+	}
+	records := shardManifest(t, files[archiveManifestFile])
+	if len(records) != 1 || records[0].File != paths[0] || records[0].Keyword != "老虎" || records[0].Width != 1 {
+		t.Errorf("shard manifest = %+v", records)
+	}
+	outer, err := ReadManifest(filepath.Join(dir, "manifest.jsonl"))
+	if err != nil || len(outer) != 1 || outer[0].Archive != "images-000000.tar.gz" || outer[0].File != paths[0] {
+		t.Errorf("manifest = %+v, %v", outer, err)
+	}
+}
+
+// 读到 n 字节后返回错误，模拟下载中断
+type failingReader struct {
+	data []byte
+	n    int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p[:Min(len(p), r.n)], r.data)
+	r.data, r.n = r.data[n:], r.n-n
+	return n, nil
+}
+
+func TestArchive_AddReaderError(t *testing.T) {
+	for _, format := range []string{ArchiveTar, ArchiveTarGzip, ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			a, err := NewArchive(t.TempDir(), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = a.Add("a", []byte("12345"), &ManifestRecord{URL: "a"}); err != nil {
+				t.Fatal(err)
+			}
+			// 写入一半失败后当前分片关闭，之后的文件写入新的分片
+			if _, err = a.AddReader("b", 10, &failingReader{data: []byte("1234567890"), n: 4}, &ManifestRecord{URL: "b"}); err == nil {
+				t.Fatal("AddReader() succeeded with a failing reader")
+			}
+			if _, err = a.AddReader("c", 3, bytes.NewReader([]byte("123")), &ManifestRecord{URL: "c"}); err != nil {
+				t.Fatal(err)
+			}
+			if err = a.Close(); err != nil {
+				t.Fatal(err)
+			}
+			shards := a.Shards()
+			if len(shards) != 2 {
+				t.Fatalf("Shards() = %v", shards)
+			}
+			// 失败前写入的文件和分片清单仍然可读，失败的文件不在清单中
+			files := readShard(t, shards[0])
+			records := shardManifest(t, files[archiveManifestFile])
+			if string(files["a"]) != "12345" || len(records) != 1 || records[0].File != "a" {
+				t.Errorf("shard 0 = %v, manifest = %+v", files, records)
+			}
+			if files = readShard(t, shards[1]); string(files["c"]) != "123" {
+				t.Errorf("shard 1 = %v", files)
+			}
+		})
+	}
+}
+
+func TestDownloadResults_ArchiveStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /chunked 不返回 Content-Length
+		if r.URL.Path != "/chunked.png" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(testPNG)))
+		}
+		w.Write(testPNG[:10])
+		w.(http.Flusher).Flush()
+		w.Write(testPNG[10:])
+	}))
+	defer server.Close()
+	for _, format := range []string{ArchiveTar, ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			a, err := NewArchive(t.TempDir(), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := newDownloader(http.DefaultClient, nil).(*downloader)
+			defer d.Close()
+			results := []Result{{URL: server.URL + "/a.png", Keyword: "老虎"}, {URL: server.URL + "/chunked.png", Keyword: "老虎"}}
+			// 文件名不依赖图片内容，直接流入分片
+			paths, err := d.DownloadResults(results, "images", false, WithArchive(a), WithFilenameTemplate("{keyword}/{index}"))
+			if err != nil || len(paths) != 2 {
+				t.Fatalf("DownloadResults() = %v, %v", paths, err)
+			}
+			a.Close()
+			files := readShard(t, a.Shards()[0])
+			records := shardManifest(t, files[archiveManifestFile])
+			if len(records) != 2 || records[0].Width != 1 || records[0].MD5 == "" {
+				t.Errorf("shard manifest = %+v", records)
+			}
+			for _, name := range []string{"images/老虎/0.png", "images/老虎/1.png"} {
+				if !bytes.Equal(files[name], testPNG) {
+					t.Errorf("archived %s = %v", name, files[name])
+				}
+			}
+		})
+	}
+}
+
+// 慢速的下载在分片外暂存，不会让其他图片排队等待，也不会因为等待超时而滚动分片
+func TestDownloadResults_ArchiveConcurrent(t *testing.T) {
+	const delay = time.Second
+	// 先返回超过类型检测长度的数据，下载器开始写入后再等待
+	body := append(append([]byte(nil), testPNG...), make([]byte, 2*sniffLen)...)
+	slowStarted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.URL.Path != "/slow.png" {
+			// 慢速的图片开始下载后再返回
+			<-slowStarted
+			time.Sleep(50 * time.Millisecond)
+			w.Write(body)
+			return
+		}
+		w.Write(body[:sniffLen+100])
+		w.(http.Flusher).Flush()
+		close(slowStarted)
+		time.Sleep(delay)
+		w.Write(body[sniffLen+100:])
+	}))
+	defer server.Close()
+	a, err := NewArchive(t.TempDir(), ArchiveTar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBingCapture(1, WithDownloadRoutines(4), WithHostConcurrency(0)).(*BingCapture)
+	defer bc.Close()
+	results := []Result{{URL: server.URL + "/slow.png", Keyword: "老虎"}}
+	for i := 0; i < 3; i++ {
+		results = append(results, Result{URL: fmt.Sprintf("%s/%d.png", server.URL, i), Keyword: "老虎"})
+	}
+	var (
+		mu       sync.Mutex
+		finished = make(map[string]time.Duration)
+	)
+	start := time.Now()
+	paths, err := bc.DownloadResults(results, "images", false, WithArchive(a), WithFilenameTemplate("{index}"),
+		WithReportHook(func(r DownloadReport) {
+			mu.Lock()
+			finished[r.URL] = time.Since(start)
+			mu.Unlock()
+		}))
+	if err != nil || len(paths) != 4 {
+		t.Fatalf("DownloadResults() = %v, %v", paths, err)
+	}
+	for _, r := range results[1:] {
+		if finished[r.URL] >= delay {
+			t.Errorf("%s finished after %s, waiting for the slow download", r.URL, finished[r.URL])
+		}
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if shards := a.Shards(); len(shards) != 1 {
+		t.Fatalf("Shards() = %v", shards)
+	}
+	if files := readShard(t, a.Shards()[0]); len(shardManifest(t, files[archiveManifestFile])) != 4 {
+		t.Errorf("shard files = %d", len(files))
+	}
+}
+
+// 超过内存上限的图片暂存到临时文件，用完后删除
+func TestArchiveSpool(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewArchive(dir, ArchiveTar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), archiveSpoolMemory/10+1)
+	spool := a.newSpool()
+	for i := 0; i < len(data); i += 1000 {
+		if _, err = spool.Write(data[i:Min(i+1000, len(data))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if spool.file == nil || spool.size != int64(len(data)) {
+		t.Fatalf("spool file = %v, size = %d", spool.file, spool.size)
+	}
+	r, err := spool.reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(r); !bytes.Equal(got, data) {
+		t.Errorf("spool data length = %d, want %d", len(got), len(data))
+	}
+	spool.close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("archive dir = %v", entries)
+	}
+}
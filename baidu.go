@@ -6,13 +6,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/panjf2000/ants/v2"
 	"io"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -24,10 +22,17 @@ type BaiduCapture struct {
 	baseUrl  string
 	q        query
 	routines int
+	logger   Logger
+	metrics  Metrics
+	tracer   Tracer
+	limiter  *concurrencyLimiter // 搜索分页的并发数
+	timeout  time.Duration       // 单个分页的超时
+	pool     *workerPool         // 搜索分页的协程池
 }
 
-// NewBaiduCapture 初始化百度图片搜索引擎 传入最大支持并发数量，建议不超过6个
-func NewBaiduCapture(routineSize int) Capture {
+// NewBaiduCapture 初始化百度图片搜索引擎 传入搜索的并发数量，建议不超过6个，
+// 需要更高的并发时可以通过 WithAdaptiveSearch 按请求的延迟和错误率自动调整
+func NewBaiduCapture(routineSize int, opts ...CaptureOption) Capture {
 	headers := map[string]string{
 		"Accept":           "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8",
 		"Proxy-Connection": "keep-alive",
@@ -40,22 +45,32 @@ func NewBaiduCapture(routineSize int) Capture {
 	if routineSize == 0 {
 		routineSize = 6
 	}
+	cfg := newCaptureConfig(opts)
+	timeouts := cfg.timeouts.search()
 	bc := &BaiduCapture{
-		client: &http.Client{
-			Transport: &http.Transport{
-				MaxConnsPerHost:     10,
-				MaxIdleConns:        5,
-				MaxIdleConnsPerHost: 5,
-			},
-			Timeout: 5 * time.Second,
-		},
+		client: newHTTPClient(&http.Transport{
+			MaxConnsPerHost:     10,
+			MaxIdleConns:        5,
+			MaxIdleConnsPerHost: 5,
+		}, timeouts, cfg.dialGuard),
 		routines: routineSize,
 		headers:  headers,
 		q:        newQuery(),
 		baseUrl:  "https://image.baidu.com/search/flip",
+		timeout:  timeouts.Item,
+	}
+	bc.logger, bc.q.logger = cfg.logger, cfg.logger
+	bc.metrics, bc.q.metrics = cfg.metrics, cfg.metrics
+	bc.tracer = cfg.tracer
+	bc.limiter = newConcurrencyLimiter(routineSize, cfg.adaptiveSearch)
+	bc.pool = newWorkerPool(cfg.searchPool, bc.limiter)
+	bc.Downloader = newDownloader(bc.client, bc.headers, append([]downloaderOption{withEngine(EngineBaidu)}, cfg.downloader...)...)
+	bc.init()
+	// 采集器级别的默认搜索选项，单次搜索的选项可以覆盖
+	for _, option := range cfg.defaults {
+		option(&bc.q)
 	}
-	bc.Downloader = newDownloader(bc.client, bc.headers)
-	return bc.init()
+	return bc
 }
 
 func (bc *BaiduCapture) init() Capture {
@@ -74,71 +89,175 @@ func (bc *BaiduCapture) init() Capture {
 	bc.q.Set("z", "")      // 尺寸大小 1-小  2-中  3-大  9-特大
 	bc.q.Set("face", "")
 	bc.q.Set("copyright", "") // 版权问题
+	bc.q.Set("ic", "")        // 颜色
 	return bc
 }
 
-func (bc *BaiduCapture) RangeImages(keyword string, callBack func([]string) bool, opts ...Option) error {
-	q := bc.q
+// 百度颜色筛选参数
+var baiduColors = map[ImageColor]string{
+	ImageColor_RED:        "1",
+	ImageColor_YELLOW:     "2",
+	ImageColor_GREEN:      "4",
+	ImageColor_TEAL:       "8",
+	ImageColor_BLUE:       "16",
+	ImageColor_PURPLE:     "32",
+	ImageColor_PINK:       "64",
+	ImageColor_BROWN:      "128",
+	ImageColor_ORANGE:     "256",
+	ImageColor_BLACK:      "512",
+	ImageColor_WHITE:      "1024",
+	ImageColor_MONOCHROME: "2048",
+}
+
+// 生成单次搜索的查询参数
+func (bc *BaiduCapture) buildQuery(keyword string, opts []Option) (query, error) {
+	q := bc.q.clone()
 	q.Set("word", keyword)
 	for _, option := range opts {
 		option(&q)
 	}
+	return q, translateBaidu(&q)
+}
+
+// 将通用筛选条件转换为百度的请求参数，百度不支持的条件返回 ErrUnsupportedOption
+func translateBaidu(q *query) error {
+	p := q.params
+	switch p.size {
+	case 0:
+	case ImageSize_SMALL, ImageSize_MEDIUM, ImageSize_LARGE, ImageSize_ENORMOUS:
+		q.Set("z", strconv.Itoa(int(p.size)))
+	default:
+		return unsupportedOption(EngineBaidu, "size", p.size)
+	}
+	if p.width > 0 || p.height > 0 {
+		return unsupportedOption(EngineBaidu, "custom size", fmt.Sprintf("%dx%d", p.width, p.height))
+	}
+	if p.color != "" {
+		ic, ok := baiduColors[p.color]
+		if !ok {
+			return unsupportedOption(EngineBaidu, "color", p.color)
+		}
+		q.Set("ic", ic)
+	}
+	switch p.imageType {
+	case "":
+	case ImageType_ANIMATED:
+		q.Set("lm", "6")
+	default:
+		return unsupportedOption(EngineBaidu, "type", p.imageType)
+	}
+	if p.layout != "" {
+		return unsupportedOption(EngineBaidu, "layout", p.layout)
+	}
+	switch p.license {
+	case "":
+	case ImageLicense_SHARE:
+		q.Set("copyright", "1")
+	default:
+		return unsupportedOption(EngineBaidu, "license", p.license)
+	}
+	switch p.freshness {
+	case "":
+	case Freshness_LATEST:
+		q.Set("latest", "1")
+	default:
+		return unsupportedOption(EngineBaidu, "freshness", p.freshness)
+	}
+	// 百度没有安全搜索参数，无法保证过滤级别，任何级别都返回错误而不是静默忽略
+	if p.safe != "" {
+		return unsupportedOption(EngineBaidu, "safe search", p.safe)
+	}
+	if p.hd {
+		q.Set("hd", "1")
+	}
+	return nil
+}
+
+func (bc *BaiduCapture) RangeImages(keyword string, callBack func([]string) bool, opts ...Option) error {
+	return bc.RangeResults(keyword, func(results []Result) bool {
+		return callBack(resultURLs(results))
+	}, opts...)
+}
+
+func (bc *BaiduCapture) RangeResults(keyword string, callBack func([]Result) bool, opts ...Option) (err error) {
+	q, err := bc.buildQuery(keyword, opts)
+	if err != nil {
+		return err
+	}
 	batchSize := 60
-	timeout := 3 * time.Second
-	total, err := bc.queryTotalNums(q)
+	searchCtx, span := bc.tracer.Start(q.ctx, SpanSearch, "engine", EngineBaidu, "keyword", keyword, "offset", q.offset)
+	// 超时或取消导致翻页提前结束时仍然返回 nil，但 span 记为失败
+	var stopErr error
+	defer func() {
+		if err != nil {
+			stopErr = err
+		}
+		span.End(stopErr)
+	}()
+	searchCtx, cancel := withOptionalTimeout(searchCtx, q.timeout)
+	defer cancel()
+	total, err := bc.queryTotalNums(searchCtx, q.clone())
 	if err != nil {
 		return err
 	}
-	collector := make(chan string, batchSize)
-	defer close(collector)
-	for i := 0; i < total; i += batchSize {
+	span.SetAttributes("total", total)
+	pages := bc.pages(keyword)
+	for i := q.offset; i < total && searchCtx.Err() == nil; i += batchSize {
 		q.Set("pn", strconv.Itoa(i))
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		queryURL := fmt.Sprintf("%s?%s", bc.baseUrl, q.Encode())
-		// 在 Goroutine 中执行爬取任务
-		go func(ctx context.Context, url string) {
-			bc.searchBaidu(ctx, url, collector)
-		}(ctx, queryURL)
-		// 收集当前分页的图片 URL
-		urls := make([]string, 0, batchSize)
-	WAIT:
-		for {
-			select {
-			case <-ctx.Done():
-				break WAIT
-			case url, ok := <-collector:
-				if !ok {
-					// 通道已关闭，退出
-					break WAIT
-				}
-				urls = append(urls, url)
-			}
-		}
-		cancel()
-		if !callBack(urls) {
-
+		// 收集当前分页的图片
+		results := pages.page(searchCtx, queryURL, &q, batchSize)
+		more := callBack(results)
+		q.nextPage(i + batchSize)
+		if !more {
 			return nil
 		}
 	}
+	stopErr = searchCtx.Err()
 	return nil
 }
 
+// 并发请求搜索分页
+func (bc *BaiduCapture) pages(keyword string) pageSearch {
+	return pageSearch{
+		engine:  EngineBaidu,
+		tracer:  bc.tracer,
+		metrics: bc.metrics,
+		pool:    bc.pool,
+		limiter: bc.limiter,
+		timeout: bc.timeout,
+		fetch: func(ctx context.Context, pageURL string, collector chan<- Result) (int, error) {
+			return bc.searchBaidu(ctx, pageURL, keyword, collector)
+		},
+	}
+}
+
 // 查询接口能获取的总数量
-func (bc *BaiduCapture) queryTotalNums(q query) (total int, err error) {
+func (bc *BaiduCapture) queryTotalNums(ctx context.Context, q query) (total int, err error) {
 	q.Set("tn", "resultjson_com")
 	queryURL := fmt.Sprintf("https://image.baidu.com/search/acjson?%s", q.Encode())
-	req, err := http.NewRequest("GET", queryURL, nil)
+	ctx, span := bc.tracer.Start(ctx, SpanSearchPage, "engine", EngineBaidu, "url", queryURL)
+	defer func() {
+		span.End(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, "GET", queryURL, nil)
 	if err != nil {
 		return
 	}
 	for k, v := range bc.headers {
 		req.Header.Set(k, v)
 	}
+	start := time.Now()
 	resp, err := bc.client.Do(req)
 	if err != nil {
+		bc.logger.Debug("search total failed", "engine", EngineBaidu, "url", queryURL, "duration", time.Since(start), "error", err)
+		bc.metrics.SearchPage(EngineBaidu, 0, time.Since(start))
 		return 0, err
 	}
 	defer resp.Body.Close()
+	bc.logger.Debug("search total", "engine", EngineBaidu, "url", queryURL, "status", resp.StatusCode, "duration", time.Since(start))
+	bc.metrics.SearchPage(EngineBaidu, resp.StatusCode, time.Since(start))
+	span.SetAttributes("status", resp.StatusCode)
 	// 百度的响应数据是经过压缩的
 	reader, err := gzip.NewReader(resp.Body)
 	if err != nil {
@@ -166,81 +285,108 @@ func (bc *BaiduCapture) queryTotalNums(q query) (total int, err error) {
 	return
 }
 
+// Stats 返回搜索和批量下载当前的并发数
+func (bc *BaiduCapture) Stats() CaptureStats {
+	return CaptureStats{Search: bc.limiter.stats(), Download: downloaderStats(bc.Downloader)}
+}
+
+// Close 释放采集器和内置下载器创建的协程池
+func (bc *BaiduCapture) Close() error {
+	bc.pool.close()
+	if closer, ok := bc.Downloader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (bc *BaiduCapture) SearchImages(keyword string, maxNumber int, opts ...Option) ([]string, error) {
-	pool, err := ants.NewPool(bc.routines)
+	results, err := bc.Search(keyword, maxNumber, opts...)
 	if err != nil {
 		return nil, err
 	}
-	defer pool.Release()
-	q := bc.q
-	q.Set("word", keyword)
-	for _, option := range opts {
-		option(&q)
+	return resultURLs(results), nil
+}
+
+func (bc *BaiduCapture) Search(keyword string, maxNumber int, opts ...Option) ([]Result, error) {
+	q, err := bc.buildQuery(keyword, opts)
+	if err != nil {
+		return nil, err
 	}
 	batchSize := 60
-	var collector = make(chan string, Min(maxNumber, batchSize))
-	// 设置单个任务的基础超时（例如 3 秒）
-	baseTimeout := 5 * time.Second
-	timeout := calculateTimeout(maxNumber, batchSize, bc.routines, baseTimeout)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
 	increment := 0
 	if maxNumber > batchSize/2 {
 		increment = batchSize
 	}
-	var wg sync.WaitGroup
-
+	var pages []string
 	for i := 0; i < maxNumber+increment; i += batchSize {
 		q.Set("pn", strconv.Itoa(i))
-		queryURL := fmt.Sprintf("%s?%s", bc.baseUrl, q.Encode())
-		wg.Add(1)
-		err = pool.Submit(func() {
-			bc.searchBaidu(ctx, queryURL, collector)
-			wg.Done()
-		})
-		if err != nil {
-			return nil, err
-		}
+		pages = append(pages, fmt.Sprintf("%s?%s", bc.baseUrl, q.Encode()))
 	}
-	var imageUrls = make(map[string]struct{}, maxNumber)
-	var urls = make([]string, 0, maxNumber)
-	go func() {
-		wg.Wait()
-		close(collector)
-	}()
-SELECT:
-	for {
-	Next:
-		select {
-		case url, ok := <-collector:
-			if !ok {
-				// 所有goroutine 执行完了，但是数量不够，任然要返回的
-				break SELECT
-			}
-			for _, rules := range defaultFilterRules {
-				if rules.Check(strings.ToLower(url)) {
-					break Next
-				}
-			}
-			if _, ok := imageUrls[url]; !ok {
-				imageUrls[url] = struct{}{}
-				urls = append(urls, url)
-			}
-			if len(urls) >= maxNumber {
-				break SELECT
-			}
-		case <-ctx.Done():
-			break SELECT
+	searchCtx, span := bc.tracer.Start(q.ctx, SpanSearch, "engine", EngineBaidu, "keyword", keyword, "max", maxNumber)
+	ctx, cancel := withOptionalTimeout(searchCtx, q.timeout)
+	defer cancel()
+	results, err := bc.pages(keyword).run(ctx, pages, &q, maxNumber, Min(maxNumber, batchSize))
+	span.SetAttributes("results", len(results))
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// 百度搜索结果页中每张图片的数据以 thumbURL 开头
+const baiduItemPrefix = `"thumbURL":`
+
+var (
+	baiduThumbURL  = regexp.MustCompile(`^"(.*?)",`)
+	baiduObjURL    = regexp.MustCompile(`"objURL":"(.*?)",`)
+	baiduMiddleURL = regexp.MustCompile(`"middleURL":"(.*?)",`)
+	baiduTitle     = regexp.MustCompile(`"fromPageTitleEnc":"(.*?)",`)
+	baiduType      = regexp.MustCompile(`"type":"(.*?)",`)
+	baiduWidth     = regexp.MustCompile(`"width":(\d+)`)
+	baiduHeight    = regexp.MustCompile(`"height":(\d+)`)
+)
+
+// 解析百度搜索结果页
+func parseBaiduResults(page, keyword string) []Result {
+	items := strings.Split(page, baiduItemPrefix)
+	results := make([]Result, 0, len(items))
+	for _, item := range items[1:] {
+		objURL := submatch(baiduObjURL, item)
+		if objURL == "" {
+			continue
+		}
+		r := Result{
+			URL:       objURL,
+			Original:  objURL,
+			Middle:    submatch(baiduMiddleURL, item),
+			Thumbnail: submatch(baiduThumbURL, item),
+			Title:     submatch(baiduTitle, item),
+			Format:    submatch(baiduType, item),
+			Engine:    EngineBaidu,
+			Keyword:   keyword,
 		}
+		r.Width, _ = strconv.Atoi(submatch(baiduWidth, item))
+		r.Height, _ = strconv.Atoi(submatch(baiduHeight, item))
+		results = append(results, r)
 	}
-	return urls, nil
+	return results
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+	match := re.FindStringSubmatch(s)
+	if len(match) > 1 {
+		return match[1]
+	}
+	return ""
 }
 
 // 获取图片
-func (bc *BaiduCapture) searchBaidu(ctx context.Context, url string, collector chan<- string) {
-	req, err := http.NewRequest("GET", url, nil)
+// 请求一个搜索分页，失败时最多尝试 3 次，返回 HTTP 状态码
+func (bc *BaiduCapture) searchBaidu(ctx context.Context, url, keyword string, collector chan<- Result) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return
+		return 0, err
 	}
 	for k, v := range bc.headers {
 		req.Header.Set(k, v)
@@ -249,17 +395,25 @@ func (bc *BaiduCapture) searchBaidu(ctx context.Context, url string, collector c
 	var resp *http.Response
 	for {
 		if try >= 3 {
-			return
+			return 0, &retryError{err: err}
 		}
+		start := time.Now()
 		resp, err = bc.client.Do(req)
 		if err == nil {
+			bc.logger.Debug("search page", "engine", EngineBaidu, "url", url, "attempt", try+1, "status", resp.StatusCode, "duration", time.Since(start))
+			bc.metrics.SearchPage(EngineBaidu, resp.StatusCode, time.Since(start))
 			break
 		}
 		try += 1
+		bc.logger.Debug("search page failed", "engine", EngineBaidu, "url", url, "attempt", try, "duration", time.Since(start), "error", err)
+		bc.metrics.SearchPage(EngineBaidu, 0, time.Since(start))
 	}
+	defer resp.Body.Close()
 	var reader io.ReadCloser
 	if resp.Header.Get("Content-Encoding") == "gzip" {
-		reader, err = gzip.NewReader(resp.Body)
+		if reader, err = gzip.NewReader(resp.Body); err != nil {
+			return resp.StatusCode, err
+		}
 	} else {
 		reader = resp.Body
 	}
@@ -267,20 +421,14 @@ func (bc *BaiduCapture) searchBaidu(ctx context.Context, url string, collector c
 	var data bytes.Buffer
 	_, err = io.Copy(&data, reader)
 	if err != nil {
-		return
+		return resp.StatusCode, err
 	}
-	pattern := `"objURL":"(.*?)",`
-	re := regexp.MustCompile(pattern)
-	for _, data := range re.FindAllStringSubmatch(data.String(), -1) {
+	for _, r := range parseBaiduResults(data.String(), keyword) {
 		select {
 		case <-ctx.Done():
-			return
-		default:
-			if len(data) > 1 {
-				collector <- data[1]
-			}
+			return resp.StatusCode, ctx.Err()
+		case collector <- r:
 		}
 	}
-	return
-
+	return resp.StatusCode, nil
 }
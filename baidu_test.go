@@ -0,0 +1,81 @@
+package imagecapture
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// 模拟百度搜索分页接口，记录收到的查询参数和请求数
+func newBaiduServer(t *testing.T) (*httptest.Server, func() (url.Values, int)) {
+	var (
+		mu       sync.Mutex
+		query    url.Values
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		query = r.URL.Query()
+		requests++
+		mu.Unlock()
+		fmt.Fprint(w, `{"data":[{"thumbURL":"https://img0.baidu.com/t1.jpg","middleURL":"https://img0.baidu.com/m1.jpg",`+
+			`"objURL":"https://example.com/1.jpg","fromPageTitleEnc":"老虎","type":"jpg","width":1024,"height":768}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() (url.Values, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return query, requests
+	}
+}
+
+func TestBaiduCapture_Search(t *testing.T) {
+	tests := []struct {
+		name        string
+		captureOpts []CaptureOption
+		opts        []Option
+		key         string
+		want        string
+		wantErr     error
+	}{
+		{"no filters", nil, nil, "z", "", nil},
+		{"size", nil, []Option{WithImageSize(ImageSize_LARGE)}, "z", "3", nil},
+		{"hd", nil, []Option{WithHd()}, "hd", "1", nil},
+		// 百度没有安全搜索参数，不能静默忽略，也不应发出请求
+		{"safe strict", nil, []Option{WithSafeSearch(SafeSearch_STRICT)}, "", "", ErrUnsupportedOption},
+		{"safe moderate", nil, []Option{WithSafeSearch(SafeSearch_MODERATE)}, "", "", ErrUnsupportedOption},
+		{"default safe strict", []CaptureOption{WithDefaultSafeSearch(SafeSearch_STRICT)}, nil, "", "", ErrUnsupportedOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, lastQuery := newBaiduServer(t)
+			bc := NewBaiduCapture(1, tt.captureOpts...).(*BaiduCapture)
+			defer bc.Close()
+			bc.baseUrl = server.URL + "/search/flip"
+			results, err := bc.Search("老虎", 1, tt.opts...)
+			query, requests := lastQuery()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Search() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if requests != 0 {
+					t.Errorf("Search() sent %d requests, want 0", requests)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].Middle != "https://img0.baidu.com/m1.jpg" {
+				t.Fatalf("Search() = %+v", results)
+			}
+			if got := query.Get(tt.key); got != tt.want {
+				t.Errorf("Search() %s = %q, want %q", tt.key, got, tt.want)
+			}
+			if query.Get("word") != "老虎" {
+				t.Errorf("Search() word = %q", query.Get("word"))
+			}
+		})
+	}
+}
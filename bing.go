@@ -4,10 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/panjf2000/ants/v2"
 	"golang.org/x/net/html"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,38 +21,60 @@ import (
  */
 
 type BingFormat struct {
-	Murl string `json:"murl"`
-	Turl string `json:"turl"`
+	Murl  string `json:"murl"`
+	Turl  string `json:"turl"`
+	Purl  string `json:"purl"`
+	Title string `json:"t"`
 }
 
 type BingCapture struct {
-	client   *http.Client
-	headers  map[string]string
-	baseUrl  string
-	q        query
-	routines int
+	client    *http.Client
+	headers   map[string]string
+	baseUrl   string
+	q         query
+	routines  int
+	logger    Logger
+	metrics   Metrics
+	tracer    Tracer
+	limiter   *concurrencyLimiter // 搜索分页的并发数
+	timeout   time.Duration       // 单个分页的超时
+	pool      *workerPool         // 搜索分页的协程池
+	checkPool *workerPool         // 校验原图地址的协程池
+	validator *urlValidator
 	Downloader
 }
 
-func NewBingCapture(routineSize int) Capture {
+func NewBingCapture(routineSize int, opts ...CaptureOption) Capture {
 	header := map[string]string{
 		"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/130.0.0.0 Safari/537.36",
 	}
+	cfg := newCaptureConfig(opts)
+	timeouts := cfg.timeouts.search()
 	bc := &BingCapture{
-		client: &http.Client{
-			Transport: &http.Transport{
-				MaxConnsPerHost: 10,
-				MaxIdleConns:    5,
-			},
-			Timeout: 5 * time.Second,
-		},
+		client: newHTTPClient(&http.Transport{
+			MaxConnsPerHost: 10,
+			MaxIdleConns:    5,
+		}, timeouts, cfg.dialGuard),
 		baseUrl:  "https://cn.bing.com/images/async",
 		headers:  header,
 		q:        newQuery(),
 		routines: routineSize,
+		timeout:  timeouts.Item,
+	}
+	bc.logger, bc.q.logger = cfg.logger, cfg.logger
+	bc.metrics, bc.q.metrics = cfg.metrics, cfg.metrics
+	bc.tracer = cfg.tracer
+	bc.limiter = newConcurrencyLimiter(routineSize, cfg.adaptiveSearch)
+	bc.pool = newWorkerPool(cfg.searchPool, bc.limiter)
+	bc.checkPool = newWorkerPool(nil, newConcurrencyLimiter(defaultCheckRoutines, nil))
+	bc.validator = newURLValidator(cfg.validation, bc.client, bc.headers, cfg.logger, cfg.tracer)
+	bc.Downloader = newDownloader(bc.client, bc.headers, append([]downloaderOption{withEngine(EngineBing)}, cfg.downloader...)...)
+	bc.init()
+	// 采集器级别的默认搜索选项，单次搜索的选项可以覆盖
+	for _, option := range cfg.defaults {
+		option(&bc.q)
 	}
-	bc.Downloader = newDownloader(bc.client, bc.headers)
-	return bc.init()
+	return bc
 }
 
 func (bc *BingCapture) init() Capture {
@@ -63,200 +86,332 @@ func (bc *BingCapture) init() Capture {
 	bc.q.Set("count", "30")
 	return bc
 }
-func (bc *BingCapture) RangeImages(keyword string, callBack func([]string) bool, opts ...Option) error {
-	q := bc.q
+
+// 必应筛选条件，均以 filterui: 形式拼接在 qft 参数中
+var (
+	bingSizes = map[ImageSize]string{
+		ImageSize_SMALL:     "imagesize-small",
+		ImageSize_MEDIUM:    "imagesize-medium",
+		ImageSize_LARGE:     "imagesize-large",
+		ImageSize_WALLPAPER: "imagesize-wallpaper",
+	}
+	bingColors = map[ImageColor]string{
+		ImageColor_COLORFUL:   "color2-color",
+		ImageColor_MONOCHROME: "color2-bw",
+		ImageColor_RED:        "color2-FGcls_RED",
+		ImageColor_ORANGE:     "color2-FGcls_ORANGE",
+		ImageColor_YELLOW:     "color2-FGcls_YELLOW",
+		ImageColor_GREEN:      "color2-FGcls_GREEN",
+		ImageColor_TEAL:       "color2-FGcls_TEAL",
+		ImageColor_BLUE:       "color2-FGcls_BLUE",
+		ImageColor_PURPLE:     "color2-FGcls_PURPLE",
+		ImageColor_PINK:       "color2-FGcls_PINK",
+		ImageColor_BROWN:      "color2-FGcls_BROWN",
+		ImageColor_BLACK:      "color2-FGcls_BLACK",
+		ImageColor_GRAY:       "color2-FGcls_GRAY",
+		ImageColor_WHITE:      "color2-FGcls_WHITE",
+	}
+	bingTypes = map[ImageType]string{
+		ImageType_PHOTO:       "photo-photo",
+		ImageType_CLIPART:     "photo-clipart",
+		ImageType_ANIMATED:    "photo-animatedgif",
+		ImageType_LINE:        "photo-linedrawing",
+		ImageType_TRANSPARENT: "photo-transparent",
+	}
+	bingLayouts = map[ImageLayout]string{
+		ImageLayout_SQUARE: "aspect-square",
+		ImageLayout_WIDE:   "aspect-wide",
+		ImageLayout_TALL:   "aspect-tall",
+	}
+	bingLicenses = map[ImageLicense]string{
+		ImageLicense_ALL:               "licenseType-Any",
+		ImageLicense_PUBLIC_DOMAIN:     "license-L1",
+		ImageLicense_SHARE:             "license-L2_L3_L4_L5_L6_L7",
+		ImageLicense_COMMERCIAL:        "license-L2_L3_L4",
+		ImageLicense_MODIFY:            "license-L2_L3_L5_L6",
+		ImageLicense_MODIFY_COMMERCIAL: "license-L2_L3",
+	}
+	bingFreshness = map[Freshness]string{
+		Freshness_DAY:   "age-lt1440",
+		Freshness_WEEK:  "age-lt10080",
+		Freshness_MONTH: "age-lt43200",
+		Freshness_YEAR:  "age-lt525600",
+	}
+)
+
+// 查表追加必应筛选条件，未设置时跳过，表中没有的条件返回 ErrUnsupportedOption
+func addBingFilter[K comparable](filters *[]string, table map[K]string, name string, value K) error {
+	var zero K
+	if value == zero {
+		return nil
+	}
+	filter, ok := table[value]
+	if !ok {
+		return unsupportedOption(EngineBing, name, value)
+	}
+	*filters = append(*filters, filter)
+	return nil
+}
+
+// 自定义尺寸，格式为 imagesize-custom_宽_高
+func bingCustomSize(filters *[]string, width, height int) error {
+	if width <= 0 && height <= 0 {
+		return nil
+	}
+	*filters = append(*filters, fmt.Sprintf("imagesize-custom_%d_%d", width, height))
+	return nil
+}
+
+// 生成单次搜索的查询参数
+func (bc *BingCapture) buildQuery(keyword string, opts []Option) (query, error) {
+	q := bc.q.clone()
 	q.Set("q", keyword)
 	for _, option := range opts {
 		option(&q)
 	}
+	return q, translateBing(&q)
+}
+
+// 将通用筛选条件转换为必应的 qft 参数
+func translateBing(q *query) error {
+	p := q.params
+	if p.hd {
+		return unsupportedOption(EngineBing, "hd", p.hd)
+	}
+	var filters []string
+	for _, err := range []error{
+		addBingFilter(&filters, bingSizes, "size", p.size),
+		bingCustomSize(&filters, p.width, p.height),
+		addBingFilter(&filters, bingColors, "color", p.color),
+		addBingFilter(&filters, bingTypes, "type", p.imageType),
+		addBingFilter(&filters, bingLayouts, "layout", p.layout),
+		addBingFilter(&filters, bingLicenses, "license", p.license),
+		addBingFilter(&filters, bingFreshness, "freshness", p.freshness),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	switch p.safe {
+	case "":
+	case SafeSearch_OFF, SafeSearch_MODERATE, SafeSearch_STRICT:
+		q.Set("adlt", string(p.safe))
+	default:
+		return unsupportedOption(EngineBing, "safe search", p.safe)
+	}
+	if len(filters) > 0 {
+		// 必应格式为 qft=+filterui:a+filterui:b，其中的 + 是编码后的空格
+		q.Set("qft", " filterui:"+strings.Join(filters, " filterui:"))
+	}
+	return nil
+}
+func (bc *BingCapture) RangeImages(keyword string, callBack func([]string) bool, opts ...Option) error {
+	return bc.RangeResults(keyword, func(results []Result) bool {
+		return callBack(resultURLs(results))
+	}, opts...)
+}
+
+func (bc *BingCapture) RangeResults(keyword string, callBack func([]Result) bool, opts ...Option) (err error) {
+	q, err := bc.buildQuery(keyword, opts)
+	if err != nil {
+		return err
+	}
 	batchSize := 60
-	// 任务超时时间
-	timeout := 3 * time.Second
 	// 必应拿不到这个数据
 	total := batchSize * 10
-	var collector = make(chan string, batchSize)
-	for i := 0; i < total; i += batchSize {
+	searchCtx, span := bc.tracer.Start(q.ctx, SpanSearch, "engine", EngineBing, "keyword", keyword, "offset", q.offset)
+	// 超时或取消导致翻页提前结束时仍然返回 nil，但 span 记为失败
+	var stopErr error
+	defer func() {
+		if err != nil {
+			stopErr = err
+		}
+		span.End(stopErr)
+	}()
+	searchCtx, cancel := withOptionalTimeout(searchCtx, q.timeout)
+	defer cancel()
+	pages := bc.pages(keyword)
+	for i := q.offset; i < total && searchCtx.Err() == nil; i += batchSize {
 		q.Set("first", strconv.Itoa(i))
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		queryURL := fmt.Sprintf("%s?%s", bc.baseUrl, q.Encode())
-		go func() {
-			defer close(collector)
-			bc.searchBing(ctx, queryURL, collector)
-		}()
-		var urls = make([]string, 0, batchSize)
-	WAIT:
-		for {
-			select {
-			case <-ctx.Done():
-				break WAIT
-			case url, ok := <-collector:
-				if !ok {
-					break WAIT
-				}
-				urls = append(urls, url)
-			}
-		}
-		cancel()
-		if !callBack(urls) {
+		results := pages.page(searchCtx, queryURL, &q, batchSize)
+		more := callBack(results)
+		q.nextPage(i + batchSize)
+		if !more {
 			return nil
 		}
+	}
+	stopErr = searchCtx.Err()
+	return nil
+}
+
+// 并发请求搜索分页
+func (bc *BingCapture) pages(keyword string) pageSearch {
+	return pageSearch{
+		engine:  EngineBing,
+		tracer:  bc.tracer,
+		metrics: bc.metrics,
+		pool:    bc.pool,
+		limiter: bc.limiter,
+		timeout: bc.timeout,
+		fetch: func(ctx context.Context, pageURL string, collector chan<- Result) (int, error) {
+			return bc.searchBing(ctx, pageURL, keyword, collector)
+		},
+	}
+}
 
+// Stats 返回搜索和批量下载当前的并发数
+func (bc *BingCapture) Stats() CaptureStats {
+	return CaptureStats{Search: bc.limiter.stats(), Download: downloaderStats(bc.Downloader)}
+}
+
+// Close 释放采集器和内置下载器创建的协程池
+func (bc *BingCapture) Close() error {
+	bc.pool.close()
+	bc.checkPool.close()
+	if closer, ok := bc.Downloader.(io.Closer); ok {
+		return closer.Close()
 	}
 	return nil
 }
+
 func (bc *BingCapture) SearchImages(keyword string, maxNumber int, opts ...Option) ([]string, error) {
-	pool, err := ants.NewPool(bc.routines)
+	results, err := bc.Search(keyword, maxNumber, opts...)
 	if err != nil {
 		return nil, err
 	}
-	defer pool.Release()
-	q := bc.q
-	q.Set("q", keyword)
-	for _, option := range opts {
-		option(&q)
+	return resultURLs(results), nil
+}
+
+func (bc *BingCapture) Search(keyword string, maxNumber int, opts ...Option) ([]Result, error) {
+	q, err := bc.buildQuery(keyword, opts)
+	if err != nil {
+		return nil, err
 	}
 	batchSize := 35
-	var collector = make(chan string, maxNumber)
-	// 设置单个任务的基础超时（例如 3 秒）
-	baseTimeout := 5 * time.Second
-	timeout := calculateTimeout(maxNumber, batchSize, bc.routines, baseTimeout)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	var wg sync.WaitGroup
-	defer cancel()
 	increment := 0
 	if maxNumber > batchSize/2 {
 		increment = batchSize
 	}
+	var pages []string
 	for i := 0; i < maxNumber+increment; i += batchSize {
 		q.Set("first", strconv.Itoa(i))
-		queryURL := fmt.Sprintf("%s?%s", bc.baseUrl, q.Encode())
-		wg.Add(1)
-		pool.Submit(func() {
-			bc.searchBing(ctx, queryURL, collector)
-			wg.Done()
-		})
+		pages = append(pages, fmt.Sprintf("%s?%s", bc.baseUrl, q.Encode()))
 	}
-	var filter = make(map[string]struct{}, maxNumber)
-	var urls = make([]string, 0, maxNumber)
-	go func() {
-		wg.Wait()
-		close(collector)
-	}()
-SELECT:
-	for {
-		select {
-		case url, ok := <-collector:
-			if !ok {
-				break SELECT
-			}
-			if _, ok := filter[url]; !ok {
-				filter[url] = struct{}{}
-				urls = append(urls, url)
-			}
-			if len(urls) >= maxNumber {
-				break SELECT
-			}
-		case <-ctx.Done():
-			break SELECT
-		}
+	searchCtx, span := bc.tracer.Start(q.ctx, SpanSearch, "engine", EngineBing, "keyword", keyword, "max", maxNumber)
+	ctx, cancel := withOptionalTimeout(searchCtx, q.timeout)
+	defer cancel()
+	results, err := bc.pages(keyword).run(ctx, pages, &q, maxNumber, maxNumber)
+	span.SetAttributes("results", len(results))
+	span.End(err)
+	if err != nil {
+		return nil, err
 	}
-	return urls, nil
+	return results, nil
 }
 
-func (bc *BingCapture) searchBing(ctx context.Context, url string, collector chan<- string) {
+// 请求一个搜索分页，返回 HTTP 状态码
+func (bc *BingCapture) searchBing(ctx context.Context, url, keyword string, collector chan<- Result) (int, error) {
 	select {
 	case <-ctx.Done():
-		return
+		return 0, ctx.Err()
 	default:
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
-			return
+			return 0, err
 		}
 		for k, v := range bc.headers {
 			req.Header.Set(k, v)
 		}
 		// 请求并解析 HTML
+		start := time.Now()
 		resp, err := bc.client.Do(req)
 		if err != nil {
-			return
+			bc.logger.Debug("search page failed", "engine", EngineBing, "url", url, "duration", time.Since(start), "error", err)
+			bc.metrics.SearchPage(EngineBing, 0, time.Since(start))
+			return 0, err
 		}
 		defer resp.Body.Close()
+		bc.logger.Debug("search page", "engine", EngineBing, "url", url, "status", resp.StatusCode, "duration", time.Since(start))
+		bc.metrics.SearchPage(EngineBing, resp.StatusCode, time.Since(start))
 
 		doc, err := html.Parse(resp.Body)
 		if err != nil {
-			return
+			return resp.StatusCode, err
 		}
-		var pool, _ = ants.NewPool(bc.routines)
-		wg := sync.WaitGroup{}
-		// 函数递归解析并检查链接
-		var f func(*html.Node)
-		f = func(n *html.Node) {
-			if n.Type == html.ElementNode && n.Data == "a" {
-				for _, attr := range n.Attr {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						if attr.Key == "class" && attr.Val == "iusc" {
-							for _, attr := range n.Attr {
-								select {
-								case <-ctx.Done():
-									return
-								default:
-									if attr.Key == "m" {
-										byteData := []byte(attr.Val)
-										wg.Add(1)
-										pool.Submit(func() {
-											defer func() {
-												wg.Done()
-											}()
-											var bf BingFormat
-
-											err = json.Unmarshal(byteData, &bf)
-											if err != nil {
-												return
-											}
-											if !bc.checkUseful(bf.Murl) {
-												collector <- bf.Turl
-												return
-											}
-											collector <- bf.Murl
-										})
-									}
-								}
-							}
-						}
-					}
-				}
+		emit := func(r Result) {
+			select {
+			case <-ctx.Done():
+			case collector <- r:
+			}
+		}
+		// 原图地址在采集器共享的协程池中校验，不可用时使用缩略图
+		var wg sync.WaitGroup
+		for _, bf := range parseBingResults(doc) {
+			if ctx.Err() != nil {
+				break
+			}
+			r := Result{
+				URL:       bf.Murl,
+				Original:  bf.Murl,
+				Thumbnail: bf.Turl,
+				Title:     bf.Title,
+				Source:    bf.Purl,
+				Engine:    EngineBing,
+				Keyword:   keyword,
+			}
+			if bf.Murl == "" {
+				r.URL = bf.Turl
+			}
+			if bf.Murl == "" || !bc.validator.enabled() {
+				emit(r)
+				continue
 			}
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				f(c)
+			wg.Add(1)
+			err = bc.checkPool.submit(func() {
+				defer wg.Done()
+				reportPool(bc.metrics, PoolParse, bc.checkPool)
+				// 没有缩略图时保留原图地址，下载时再确认是否可用
+				if !bc.validator.validate(ctx, r.Original) && r.Thumbnail != "" {
+					r.URL = r.Thumbnail
+				}
+				emit(r)
+			})
+			if err != nil {
+				wg.Done()
+				break
 			}
 		}
-		f(doc)
 		wg.Wait()
-		pool.Release()
+		reportPool(bc.metrics, PoolParse, bc.checkPool)
+		return resp.StatusCode, err
 	}
 }
-func (bc *BingCapture) checkUseful(url string) bool {
-	if url == "" {
-		return false
-	}
-	req, err := http.NewRequest("HEAD", url, nil)
-	if err != nil {
-		return false
-	}
-	for k, v := range bc.headers {
-		req.Header.Set(k, v)
-	}
-	resp, err := bc.client.Do(req)
-	if nil != err {
-		return false
-	}
-	defer resp.Body.Close()
-	// 检查状态码是否为 2xx
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return true
+
+// 解析搜索结果页中 class 为 iusc 的链接，图片数据在 m 属性的 json 中
+func parseBingResults(doc *html.Node) []BingFormat {
+	var results []BingFormat
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			var class, m string
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "class":
+					class = attr.Val
+				case "m":
+					m = attr.Val
+				}
+			}
+			var bf BingFormat
+			if class == "iusc" && m != "" && json.Unmarshal([]byte(m), &bf) == nil {
+				results = append(results, bf)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
 	}
-	return false
+	f(doc)
+	return results
 }
@@ -0,0 +1,136 @@
+package imagecapture
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/14 下午4:20
+* @Package:
+ */
+
+// 1x1 的 png 图片
+var testPNG = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48, 0x44, 0x52,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x08, 0x02, 0x00, 0x00, 0x00, 0x90, 0x77, 0x53,
+	0xde, 0x00, 0x00, 0x00, 0x0c, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0xf8, 0xcf, 0xc0, 0x00,
+	0x00, 0x03, 0x01, 0x01, 0x00, 0xc9, 0xfe, 0x92, 0xef, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4e,
+	0x44, 0xae, 0x42, 0x60, 0x82,
+}
+
+// 模拟必应搜索接口，记录收到的查询参数
+func newBingServer(t *testing.T) (*httptest.Server, func() url.Values) {
+	var (
+		mu    sync.Mutex
+		query url.Values
+	)
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/images/async", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		query = r.URL.Query()
+		mu.Unlock()
+		fmt.Fprintf(w, `<html><body>
+<a class="iusc" m='{"murl":"%[1]s/full.jpg","turl":"%[1]s/thumb.jpg","purl":"https://example.com/page","t":"老虎"}'></a>
+<a class="iusc" m='{"murl":"%[1]s/missing.jpg","turl":"%[1]s/thumb2.jpg","t":"狮子"}'></a>
+</body></html>`, server.URL)
+	})
+	image := func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG)
+	}
+	mux.HandleFunc("/full.jpg", image)
+	mux.HandleFunc("/thumb.jpg", image)
+	mux.HandleFunc("/thumb2.jpg", image)
+	t.Cleanup(server.Close)
+	return server, func() url.Values {
+		mu.Lock()
+		defer mu.Unlock()
+		return query
+	}
+}
+
+func TestBingCapture_Search(t *testing.T) {
+	server, lastQuery := newBingServer(t)
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantQft string
+	}{
+		{"no filters", nil, ""},
+		{"wallpaper", []Option{WithImageSize(ImageSize_WALLPAPER)}, " filterui:imagesize-wallpaper"},
+		{"custom size", []Option{WithCustomSize(1920, 1080)}, " filterui:imagesize-custom_1920_1080"},
+		{"monochrome", []Option{WithColor(ImageColor_MONOCHROME)}, " filterui:color2-bw"},
+		{"transparent", []Option{WithImageType(ImageType_TRANSPARENT)}, " filterui:photo-transparent"},
+		{"line", []Option{WithImageType(ImageType_LINE)}, " filterui:photo-linedrawing"},
+		{"tall", []Option{WithLayout(ImageLayout_TALL)}, " filterui:aspect-tall"},
+		{"public domain", []Option{WithLicense(ImageLicense_PUBLIC_DOMAIN)}, " filterui:license-L1"},
+		{"commercial", []Option{WithLicense(ImageLicense_COMMERCIAL)}, " filterui:license-L2_L3_L4"},
+		{"past year", []Option{WithFreshness(Freshness_YEAR)}, " filterui:age-lt525600"},
+		{
+			"combined",
+			[]Option{WithImageSize(ImageSize_LARGE), WithColor(ImageColor_RED), WithFreshness(Freshness_DAY)},
+			" filterui:imagesize-large filterui:color2-FGcls_RED filterui:age-lt1440",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := NewBingCapture(2).(*BingCapture)
+			bc.baseUrl = server.URL + "/images/async"
+			results, err := bc.Search("老虎", 2, tt.opts...)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if got := lastQuery().Get("qft"); got != tt.wantQft {
+				t.Errorf("Search() qft = %q, want %q", got, tt.wantQft)
+			}
+			sort.Slice(results, func(i, j int) bool { return results[i].URL < results[j].URL })
+			want := []string{server.URL + "/full.jpg", server.URL + "/thumb2.jpg"}
+			if len(results) != 2 || results[0].URL != want[0] || results[1].URL != want[1] {
+				t.Fatalf("Search() got = %+v, want urls %v", results, want)
+			}
+			if results[0].Thumbnail != server.URL+"/thumb.jpg" || results[0].Title != "老虎" || results[0].Engine != EngineBing {
+				t.Errorf("Search() got = %+v", results[0])
+			}
+		})
+	}
+}
+
+func TestBingCapture_SafeSearch(t *testing.T) {
+	server, lastQuery := newBingServer(t)
+	tests := []struct {
+		name        string
+		captureOpts []CaptureOption
+		opts        []Option
+		want        string
+	}{
+		{"not set", nil, nil, ""},
+		{"per search", nil, []Option{WithSafeSearch(SafeSearch_MODERATE)}, "moderate"},
+		{"capture default", []CaptureOption{WithDefaultSafeSearch(SafeSearch_STRICT)}, nil, "strict"},
+		{
+			"per search overrides default",
+			[]CaptureOption{WithDefaultSafeSearch(SafeSearch_STRICT)},
+			[]Option{WithSafeSearch(SafeSearch_OFF)},
+			"off",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := NewBingCapture(2, tt.captureOpts...).(*BingCapture)
+			bc.baseUrl = server.URL + "/images/async"
+			if _, err := bc.SearchImages("老虎", 1, tt.opts...); err != nil {
+				t.Fatalf("SearchImages() error = %v", err)
+			}
+			if got := lastQuery().Get("adlt"); got != tt.want {
+				t.Errorf("SearchImages() adlt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
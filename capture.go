@@ -1,50 +1,11 @@
 package imagecapture
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strings"
-)
-
-var defaultFilterRules = []Rule{RULE_DOUYIN, RULES_SINA}
-
-type Rule []string
-
-// 规则校验
-func (r Rule) Check(rawURL string) bool {
-	// 解析 URL
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return false // URL 解析失败
-	}
-	// 获取域名部分
-	host := u.Hostname()
-	// 判断域名是否属于抖音
-	for _, rule := range r {
-		if strings.HasSuffix(host, rule) {
-			return true
-		}
-	}
-	return false
-}
-
-var (
-	//  抖音源
-	RULE_DOUYIN = Rule{
-		"douyin.com",
-		"douyinpic.com",
-		"ixigua.com",
-		"snssdk.com",
-		"amemv.com",
-		"tiktok.com",
-	}
-	// 新浪源
-	RULES_SINA = Rule{
-		"sinaimg.cn",
-		"sinajs.cn",
-		"sina.com.cn",
-		"vip.sina.com",
-	}
+	"sync"
+	"time"
 )
 
 /*
@@ -72,49 +33,464 @@ type Capture interface {
 	@return: 返回当前页码的图片 URL 列表和可能的错误
 	*/
 	RangeImages(keyword string, callBack func(urls []string) bool, opts ...Option) error
+
+	/**
+	搜索图片并返回引擎提供的元数据（缩略图、标题、尺寸等），用法同 SearchImages
+	*/
+	Search(keyword string, maxNumber int, opts ...Option) ([]Result, error)
+
+	/**
+	分页范围搜索并返回引擎提供的元数据，用法同 RangeImages
+	*/
+	RangeResults(keyword string, callBack func(results []Result) bool, opts ...Option) error
+
+	/**
+	返回搜索和批量下载当前的并发数，开启自适应并发时会随请求的延迟和错误率变化
+	*/
+	Stats() CaptureStats
+
+	/**
+	释放采集器创建的协程池，之后的搜索和批量下载返回 ErrCaptureClosed，正在执行的任务会继续执行完
+	*/
+	Close() error
+}
+
+const (
+	EngineBaidu = "baidu"
+	EngineBing  = "bing"
+)
+
+// Result 单张图片的搜索结果，字段取决于搜索引擎能提供的元数据
+type Result struct {
+	URL       string `json:"url"`                 // 图片地址，通常为原图，必应原图校验失败时为缩略图
+	Original  string `json:"original,omitempty"`  // 引擎返回的原图地址
+	Middle    string `json:"middle,omitempty"`    // 中等尺寸图地址
+	Thumbnail string `json:"thumbnail,omitempty"` // 缩略图地址
+	Title     string `json:"title,omitempty"`     // 图片标题
+	Source    string `json:"source,omitempty"`    // 图片所在页面
+	Format    string `json:"format,omitempty"`    // 图片格式  eg: jpg
+	Width     int    `json:"width,omitempty"`     // 宽度，未知时为 0
+	Height    int    `json:"height,omitempty"`    // 高度，未知时为 0
+	Engine    string `json:"engine"`              // 搜索引擎
+	Keyword   string `json:"keyword"`             // 搜索关键词
+}
+
+// 提取结果中的图片地址
+func resultURLs(results []Result) []string {
+	urls := make([]string, 0, len(results))
+	for i := range results {
+		urls = append(urls, results[i].URL)
+	}
+	return urls
+}
+
+// CaptureOption 采集器配置，在 NewBaiduCapture、NewBingCapture 时传入
+type CaptureOption func(*captureConfig)
+
+type captureConfig struct {
+	defaults   []Option           // 每次搜索默认附加的选项
+	downloader []downloaderOption // 内置下载器配置
+	logger     Logger
+	metrics    Metrics
+	tracer     Tracer
+	// 搜索分页的自适应并发配置，nil 时并发数固定
+	adaptiveSearch *AdaptiveConfig
+	timeouts       Timeouts
+	searchPool     WorkerPool // 调用方注入的搜索协程池，nil 时由采集器创建
+	validation     ValidationMode
+	dialGuard      DialGuard // 连接前检查对方地址，nil 时不限制
+}
+
+func newCaptureConfig(opts []CaptureOption) captureConfig {
+	cfg := captureConfig{logger: nopLogger{}, metrics: nopMetrics{}, tracer: nopTracer{}}
+	for _, option := range opts {
+		option(&cfg)
+	}
+	return cfg
+}
+
+// WithDefaultOptions 设置采集器每次搜索默认使用的选项
+func WithDefaultOptions(opts ...Option) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.defaults = append(cfg.defaults, opts...)
+	}
+}
+
+// WithDefaultSafeSearch 设置采集器默认的安全搜索级别
+func WithDefaultSafeSearch(level SafeSearch) CaptureOption {
+	return WithDefaultOptions(WithSafeSearch(level))
+}
+
+// WithDownloadRoutines 设置批量下载的并发数，默认 8
+func WithDownloadRoutines(routines int) CaptureOption {
+	return func(cfg *captureConfig) {
+		if routines <= 0 {
+			return
+		}
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.routines = routines
+		})
+	}
+}
+
+// NewCapture 按搜索引擎名称创建采集器，支持 EngineBaidu、EngineBing
+func NewCapture(engine string, routineSize int, opts ...CaptureOption) (Capture, error) {
+	switch engine {
+	case EngineBaidu:
+		return NewBaiduCapture(routineSize, opts...), nil
+	case EngineBing:
+		return NewBingCapture(routineSize, opts...), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedEngine, engine)
 }
 
 type Option func(*query)
 
 type query struct {
 	url.Values
+	params  searchParams
+	rules   ruleFilter
+	filters []ResultFilter
+	offset  int       // RangeResults 起始的结果偏移
+	onPage  func(int) // 每页回调处理完后通知下一页的偏移
+	logger  Logger
+	metrics Metrics
+	ctx     context.Context // 调用方传入的上下文，默认 context.Background()
+	timeout time.Duration   // 单次搜索的总时长，0 不限制
+}
+
+// 与搜索引擎无关的筛选条件，由各引擎转换为自己的请求参数
+type searchParams struct {
+	size      ImageSize
+	width     int // 自定义尺寸
+	height    int
+	color     ImageColor
+	imageType ImageType
+	layout    ImageLayout
+	license   ImageLicense
+	freshness Freshness
+	safe      SafeSearch
+	hd        bool
+}
+
+// 引擎不支持的筛选条件
+func unsupportedOption(engine, name string, value interface{}) error {
+	return fmt.Errorf("%w: %s does not support %s %v", ErrUnsupportedOption, engine, name, value)
 }
 
 func newQuery() query {
-	return query{url.Values{}}
+	return query{Values: url.Values{}, logger: nopLogger{}, metrics: nopMetrics{}, ctx: context.Background()}
 }
 
-// WithCopyright 过滤版权数据
-func WithCopyright() Option {
+// 复制一份查询参数，避免单次搜索的选项污染采集器的默认参数
+func (q query) clone() query {
+	values := make(url.Values, len(q.Values))
+	for k, v := range q.Values {
+		values[k] = append([]string(nil), v...)
+	}
+	return query{
+		Values:  values,
+		params:  q.params,
+		rules:   q.rules.clone(),
+		filters: append([]ResultFilter(nil), q.filters...),
+		offset:  q.offset,
+		onPage:  q.onPage,
+		logger:  q.logger,
+		metrics: q.metrics,
+		ctx:     q.ctx,
+		timeout: q.timeout,
+	}
+}
+
+// 通知下一页的偏移
+func (q *query) nextPage(next int) {
+	if q.onPage != nil {
+		q.onPage(next)
+	}
+}
+
+// 过滤链：先校验来源规则，再依次执行结果过滤器
+func (q *query) accept(r Result) bool {
+	q.metrics.SearchResults(r.Engine, ResultFound, 1)
+	if !q.rules.accept(r.URL) {
+		q.logger.Debug("result rejected", "engine", r.Engine, "url", r.URL, "reason", "rule")
+		q.metrics.SearchResults(r.Engine, ResultFiltered, 1)
+		return false
+	}
+	for _, filter := range q.filters {
+		if !filter.Accept(r) {
+			q.logger.Debug("result rejected", "engine", r.Engine, "url", r.URL, "reason", "filter")
+			q.metrics.SearchResults(r.Engine, ResultFiltered, 1)
+			return false
+		}
+	}
+	return true
+}
+
+// 在 span 中请求一个搜索分页，poolWait 为在协程池中排队的时间
+func traceSearchPage(ctx context.Context, tracer Tracer, engine, url string, poolWait time.Duration,
+	fetch func(ctx context.Context) (status int, err error)) {
+	ctx, span := tracer.Start(ctx, SpanSearchPage, "engine", engine, "url", url, "pool_wait", poolWait)
+	status, err := fetch(ctx)
+	span.SetAttributes("status", status)
+	span.End(err)
+}
+
+// pageSearch 并发请求搜索分页并收集结果，返回前取消未完成的分页并等待所有协程退出
+type pageSearch struct {
+	engine  string
+	tracer  Tracer
+	metrics Metrics
+	pool    *workerPool
+	limiter *concurrencyLimiter
+	timeout time.Duration // 单个分页的超时
+	// 请求一个分页，结果写入 collector，返回 HTTP 状态码
+	fetch func(ctx context.Context, pageURL string, collector chan<- Result) (int, error)
+}
+
+// 在 span 中请求一个分页，记录到并发控制
+func (s pageSearch) fetchPage(ctx context.Context, pageURL string, poolWait time.Duration, collector chan<- Result) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	traceSearchPage(ctx, s.tracer, s.engine, pageURL, poolWait, s.limiter.observePage(func(ctx context.Context) (int, error) {
+		return s.fetch(ctx, pageURL, collector)
+	}))
+}
+
+// run 使用协程池并发请求 pages，直到收集到 maxNumber 个结果、所有分页结束或 ctx 结束
+func (s pageSearch) run(ctx context.Context, pages []string, q *query, maxNumber, buffer int) ([]Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		collector = make(chan Result, buffer)
+		submitErr error
+	)
+	// 提交协程：与收集并行，避免协程池已满时阻塞收集
+	go func() {
+		var wg sync.WaitGroup
+		defer func() {
+			wg.Wait()
+			reportPool(s.metrics, PoolSearch, s.pool)
+			close(collector)
+		}()
+		for _, pageURL := range pages {
+			if ctx.Err() != nil {
+				return
+			}
+			pageURL := pageURL
+			submitted := time.Now()
+			wg.Add(1)
+			submitErr = s.pool.submit(func() {
+				defer wg.Done()
+				reportPool(s.metrics, PoolSearch, s.pool)
+				s.fetchPage(ctx, pageURL, time.Since(submitted), collector)
+			})
+			if submitErr != nil {
+				wg.Done()
+				return
+			}
+		}
+	}()
+	results := collectResults(ctx, collector, q, maxNumber)
+	cancel()
+	drain(collector)
+	return results, submitErr
+}
+
+// page 请求单个分页并收集结果
+func (s pageSearch) page(ctx context.Context, pageURL string, q *query, batchSize int) []Result {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	collector := make(chan Result, batchSize)
+	go func() {
+		defer close(collector)
+		s.fetchPage(ctx, pageURL, 0, collector)
+	}()
+	results := collectPage(ctx, collector, q, batchSize)
+	cancel()
+	drain(collector)
+	return results
+}
+
+// 丢弃剩余的结果直到生产者关闭通道，用于等待协程退出
+func drain(collector <-chan Result) {
+	for range collector {
+	}
+}
+
+// 收集搜索结果直到数量足够、生产者全部结束或超时，结果经过滤链并去重
+func collectResults(ctx context.Context, collector <-chan Result, q *query, maxNumber int) []Result {
+	var seen = make(map[string]struct{}, maxNumber)
+	var results = make([]Result, 0, maxNumber)
+	for {
+		select {
+		case r, ok := <-collector:
+			if !ok {
+				// 所有goroutine 执行完了，但是数量不够，任然要返回的
+				return results
+			}
+			if !q.accept(r) {
+				continue
+			}
+			if _, ok := seen[r.URL]; !ok {
+				seen[r.URL] = struct{}{}
+				results = append(results, r)
+			} else {
+				q.metrics.SearchResults(r.Engine, ResultDeduped, 1)
+			}
+			if len(results) >= maxNumber {
+				return results
+			}
+		case <-ctx.Done():
+			return results
+		}
+	}
+}
+
+// 收集单页搜索结果，结果经过滤链
+func collectPage(ctx context.Context, collector <-chan Result, q *query, batchSize int) []Result {
+	results := make([]Result, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return results
+		case r, ok := <-collector:
+			if !ok {
+				// 通道已关闭，退出
+				return results
+			}
+			if q.accept(r) {
+				results = append(results, r)
+			}
+		}
+	}
+}
+
+// WithFilters 追加结果过滤器，过滤发生在去重和计数之前
+func WithFilters(filters ...ResultFilter) Option {
+	return func(query *query) {
+		query.filters = append(query.filters, filters...)
+	}
+}
+
+// WithFilterRules 以黑名单方式过滤命中规则的图片来源
+func WithFilterRules(rules ...FilterRule) Option {
+	return func(query *query) {
+		query.rules.deny = append(query.rules.deny, rules...)
+	}
+}
+
+// WithAllowRules 以白名单方式只保留命中规则的图片来源
+func WithAllowRules(rules ...FilterRule) Option {
+	return func(query *query) {
+		query.rules.allow = append(query.rules.allow, rules...)
+	}
+}
+
+// WithRuleSet 按规则集的模式加载过滤规则，规则集可通过 LoadRuleSet 从文件读取
+func WithRuleSet(rs *RuleSet) Option {
 	return func(query *query) {
-		query.Set("copyright", "1")
+		if rs == nil {
+			return
+		}
+		if rs.Mode == FilterAllow {
+			query.rules.allow = append(query.rules.allow, rs.Rules...)
+			return
+		}
+		query.rules.deny = append(query.rules.deny, rs.Rules...)
 	}
 }
 
+// WithoutDefaultRules 不使用默认的来源过滤规则（抖音、新浪等）
+func WithoutDefaultRules() Option {
+	return func(query *query) {
+		query.rules.noDefault = true
+	}
+}
+
+// WithPageCursor 仅对 RangeResults 生效：从 offset 指定的结果偏移开始翻页，
+// 每页回调处理完后调用 onPage 通知下一页的偏移，用于保存进度、断点续抓
+func WithPageCursor(offset int, onPage func(next int)) Option {
+	return func(query *query) {
+		query.offset, query.onPage = offset, onPage
+	}
+}
+
+// WithCopyright 过滤版权数据，等同于 WithLicense(ImageLicense_SHARE)
+func WithCopyright() Option {
+	return WithLicense(ImageLicense_SHARE)
+}
+
 // WithImageSize 搜索的图片大小限制
 func WithImageSize(size ImageSize) Option {
 	return func(query *query) {
-		query.Set("z", fmt.Sprintf("%d", size))
+		query.params.size = size
 	}
 }
 
-// WithLatest 搜索最新图片
-func WithLatest() Option {
+// WithSafeSearch 设置安全搜索级别
+func WithSafeSearch(level SafeSearch) Option {
 	return func(query *query) {
-		query.Set("latest", "1")
+		query.params.safe = level
 	}
 }
 
-// WithGif 搜索动图
-func WithGif() Option {
+// WithCustomSize 搜索不小于指定宽高的图片
+func WithCustomSize(width, height int) Option {
 	return func(query *query) {
-		query.Set("lm", "6")
+		query.params.width = width
+		query.params.height = height
 	}
 }
 
+// WithLatest 搜索最新图片
+func WithLatest() Option {
+	return WithFreshness(Freshness_LATEST)
+}
+
+// WithGif 搜索动图，等同于 WithImageType(ImageType_ANIMATED)
+func WithGif() Option {
+	return WithImageType(ImageType_ANIMATED)
+}
+
 // WithHd 搜素高清图
 func WithHd() Option {
 	return func(query *query) {
-		query.Set("hd", "1")
+		query.params.hd = true
+	}
+}
+
+// WithColor 搜索指定颜色的图片
+func WithColor(color ImageColor) Option {
+	return func(query *query) {
+		query.params.color = color
+	}
+}
+
+// WithImageType 搜索指定类型的图片，例如照片、剪贴画、动图
+func WithImageType(ty ImageType) Option {
+	return func(query *query) {
+		query.params.imageType = ty
+	}
+}
+
+// WithLayout 搜索指定布局（宽高比）的图片
+func WithLayout(layout ImageLayout) Option {
+	return func(query *query) {
+		query.params.layout = layout
+	}
+}
+
+// WithLicense 搜索指定授权的图片
+func WithLicense(license ImageLicense) Option {
+	return func(query *query) {
+		query.params.license = license
+	}
+}
+
+// WithFreshness 搜索指定时间范围内的图片
+func WithFreshness(freshness Freshness) Option {
+	return func(query *query) {
+		query.params.freshness = freshness
 	}
 }
@@ -0,0 +1,68 @@
+package imagecapture
+
+import (
+	"errors"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/14 上午10:30
+* @Package:
+ */
+
+func Test_translate(t *testing.T) {
+	tests := []struct {
+		name      string
+		translate func(*query) error
+		opts      []Option
+		key       string
+		want      string
+		wantErr   error
+	}{
+		{"baidu size", translateBaidu, []Option{WithImageSize(ImageSize_LARGE)}, "z", "3", nil},
+		{"baidu gif", translateBaidu, []Option{WithGif()}, "lm", "6", nil},
+		{"baidu copyright", translateBaidu, []Option{WithCopyright()}, "copyright", "1", nil},
+		{"baidu latest", translateBaidu, []Option{WithLatest()}, "latest", "1", nil},
+		{"baidu color", translateBaidu, []Option{WithColor(ImageColor_RED)}, "ic", "1", nil},
+		{"baidu layout", translateBaidu, []Option{WithLayout(ImageLayout_WIDE)}, "", "", ErrUnsupportedOption},
+		{"baidu custom size", translateBaidu, []Option{WithCustomSize(800, 600)}, "", "", ErrUnsupportedOption},
+		{"baidu wallpaper", translateBaidu, []Option{WithImageSize(ImageSize_WALLPAPER)}, "", "", ErrUnsupportedOption},
+		{"baidu week", translateBaidu, []Option{WithFreshness(Freshness_WEEK)}, "", "", ErrUnsupportedOption},
+		{"baidu safe strict", translateBaidu, []Option{WithSafeSearch(SafeSearch_STRICT)}, "", "", ErrUnsupportedOption},
+		{"baidu safe moderate", translateBaidu, []Option{WithSafeSearch(SafeSearch_MODERATE)}, "", "", ErrUnsupportedOption},
+		{"baidu safe off", translateBaidu, []Option{WithSafeSearch(SafeSearch_OFF)}, "", "", ErrUnsupportedOption},
+		{"bing safe strict", translateBing, []Option{WithSafeSearch(SafeSearch_STRICT)}, "adlt", "strict", nil},
+		{"bing safe off", translateBing, []Option{WithSafeSearch(SafeSearch_OFF)}, "adlt", "off", nil},
+		{"bing no filters", translateBing, nil, "qft", "", nil},
+		{"bing size", translateBing, []Option{WithImageSize(ImageSize_LARGE)}, "qft", " filterui:imagesize-large", nil},
+		{
+			"bing multiple",
+			translateBing,
+			[]Option{WithGif(), WithLayout(ImageLayout_SQUARE), WithFreshness(Freshness_WEEK)},
+			"qft",
+			" filterui:photo-animatedgif filterui:aspect-square filterui:age-lt10080",
+			nil,
+		},
+		{"bing copyright", translateBing, []Option{WithCopyright()}, "qft", " filterui:license-L2_L3_L4_L5_L6_L7", nil},
+		{"bing hd", translateBing, []Option{WithHd()}, "", "", ErrUnsupportedOption},
+		{"bing latest", translateBing, []Option{WithLatest()}, "", "", ErrUnsupportedOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQuery()
+			for _, option := range tt.opts {
+				option(&q)
+			}
+			err := tt.translate(&q)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("translate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := q.Get(tt.key); tt.key != "" && got != tt.want {
+				t.Errorf("translate() %s = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package imagecapture
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/19 上午11:20
+* @Package:
+ */
+
+// 任务进度文件名，保存在输出目录下，删除后任务会从头开始
+const jobCheckpointFile = "checkpoint.jsonl"
+
+// 图片地址的处理状态
+const (
+	checkpointQueued     = "queued"
+	checkpointDownloaded = "downloaded"
+	checkpointFailed     = "failed"
+)
+
+// 进度日志中的一行，按字段区分类型：
+// 有 url 的记录图片状态，done 标记搜索结束，否则记录下一页的偏移
+type checkpointEntry struct {
+	Task   string  `json:"task"`
+	Offset int     `json:"offset,omitempty"`
+	Done   bool    `json:"done,omitempty"`
+	URL    string  `json:"url,omitempty"`
+	Status string  `json:"status,omitempty"`
+	Path   string  `json:"path,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	Result *Result `json:"result,omitempty"` // 入队时记录搜索结果，用于写入清单
+}
+
+// 单个关键词任务的进度
+type taskState struct {
+	offset int                         // 下一页的偏移
+	done   bool                        // 搜索是否已结束
+	urls   []string                    // 按入队顺序保存的图片地址
+	images map[string]*checkpointEntry // 图片地址 -> 最新状态
+}
+
+// checkpoint 追加写入的任务进度日志，每次状态变化写一行 json，
+// 重新打开时按顺序回放即可恢复所有任务的进度。方法可并发调用
+type checkpoint struct {
+	mu    sync.Mutex
+	file  *os.File
+	tasks map[string]*taskState
+}
+
+// 打开进度日志并回放已有记录。进程中断可能导致最后一行不完整，这一行会被丢弃
+func openCheckpoint(filename string) (*checkpoint, error) {
+	cp := &checkpoint{tasks: make(map[string]*taskState)}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	valid := 0
+	for len(data[valid:]) > 0 {
+		line := data[valid:]
+		end := bytes.IndexByte(line, '\n')
+		if end < 0 {
+			break // 未写完的最后一行
+		}
+		var entry checkpointEntry
+		if err = json.Unmarshal(line[:end], &entry); err != nil {
+			return nil, fmt.Errorf("corrupted checkpoint %s: %w", filename, err)
+		}
+		cp.apply(&entry)
+		valid += end + 1
+	}
+	if valid < len(data) {
+		if err = os.Truncate(filename, int64(valid)); err != nil {
+			return nil, err
+		}
+	}
+	cp.file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) task(key string) *taskState {
+	state, ok := cp.tasks[key]
+	if !ok {
+		state = &taskState{images: make(map[string]*checkpointEntry)}
+		cp.tasks[key] = state
+	}
+	return state
+}
+
+// 将一条记录应用到内存中的进度
+func (cp *checkpoint) apply(entry *checkpointEntry) {
+	state := cp.task(entry.Task)
+	switch {
+	case entry.URL != "":
+		if prev, ok := state.images[entry.URL]; !ok {
+			state.urls = append(state.urls, entry.URL)
+		} else if entry.Result == nil {
+			entry.Result = prev.Result
+		}
+		state.images[entry.URL] = entry
+	case entry.Done:
+		state.done = true
+	default:
+		state.offset = entry.Offset
+	}
+}
+
+// 先写日志再更新内存，保证内存中的进度都已落盘
+func (cp *checkpoint) write(entry *checkpointEntry) error {
+	if cp.file == nil {
+		return os.ErrClosed
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = cp.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	cp.apply(entry)
+	return nil
+}
+
+// 记录下一页的偏移
+func (cp *checkpoint) setOffset(key string, offset int) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.write(&checkpointEntry{Task: key, Offset: offset})
+}
+
+// 标记搜索结束
+func (cp *checkpoint) searchDone(key string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.write(&checkpointEntry{Task: key, Done: true})
+}
+
+// 搜索结果入队，已存在的地址直接忽略，返回任务当前的图片数量
+func (cp *checkpoint) queue(key string, r Result) (int, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	state := cp.task(key)
+	if _, ok := state.images[r.URL]; ok {
+		return len(state.urls), nil
+	}
+	err := cp.write(&checkpointEntry{Task: key, URL: r.URL, Status: checkpointQueued, Result: &r})
+	return len(state.urls), err
+}
+
+// 记录图片的下载结果
+func (cp *checkpoint) finish(key string, report DownloadReport) error {
+	entry := &checkpointEntry{Task: key, URL: report.URL, Status: checkpointDownloaded, Path: report.Path}
+	if report.Err != nil {
+		entry.Status, entry.Error = checkpointFailed, report.Err.Error()
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.write(entry)
+}
+
+// 返回任务的进度：下一页偏移、搜索是否结束、图片数量
+func (cp *checkpoint) progress(key string) (offset int, done bool, found int) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	state := cp.task(key)
+	return state.offset, state.done, len(state.urls)
+}
+
+// 返回任务中等待下载的搜索结果，下载失败的不会重试
+func (cp *checkpoint) pending(key string) []Result {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	state := cp.task(key)
+	var results []Result
+	for _, url := range state.urls {
+		image := state.images[url]
+		if image.Status != checkpointQueued {
+			continue
+		}
+		if image.Result != nil {
+			results = append(results, *image.Result)
+		} else {
+			results = append(results, Result{URL: url})
+		}
+	}
+	return results
+}
+
+// 返回任务中每个图片地址的入队序号，用作文件名模板中的 {index}
+func (cp *checkpoint) indexes(key string) map[string]int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	state := cp.task(key)
+	indexes := make(map[string]int, len(state.urls))
+	for i, url := range state.urls {
+		indexes[url] = i
+	}
+	return indexes
+}
+
+// 返回任务中已下载的文件路径，包括之前运行时下载的
+func (cp *checkpoint) files(key string) []string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	state := cp.task(key)
+	var files []string
+	for _, url := range state.urls {
+		if image := state.images[url]; image.Status == checkpointDownloaded {
+			files = append(files, image.Path)
+		}
+	}
+	return files
+}
+
+// 关闭后的写入会返回 os.ErrClosed
+func (cp *checkpoint) close() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.file == nil {
+		return nil
+	}
+	err := cp.file.Close()
+	cp.file = nil
+	return err
+}
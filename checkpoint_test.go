@@ -0,0 +1,68 @@
+package imagecapture
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/19 下午2:10
+* @Package:
+ */
+
+func TestCheckpoint_Replay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), jobCheckpointFile)
+	cp, err := openCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.queue("bing:老虎", Result{URL: "https://a.com/1.jpg"})
+	cp.queue("bing:老虎", Result{URL: "https://a.com/2.jpg"})
+	cp.queue("bing:老虎", Result{URL: "https://a.com/3.jpg"})
+	if found, _ := cp.queue("bing:老虎", Result{URL: "https://a.com/1.jpg"}); found != 3 {
+		t.Errorf("queue() duplicated url, found = %d", found)
+	}
+	cp.setOffset("bing:老虎", 60)
+	cp.finish("bing:老虎", DownloadReport{URL: "https://a.com/1.jpg", Path: "/tmp/1.png"})
+	cp.finish("bing:老虎", DownloadReport{URL: "https://a.com/2.jpg", Err: errors.New("404")})
+	if err = cp.close(); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟写入一半时进程中断
+	file, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"task":"bing:老虎","done":tr`)
+	file.Close()
+
+	cp, err = openCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("openCheckpoint() error = %v", err)
+	}
+	defer cp.close()
+	offset, done, found := cp.progress("bing:老虎")
+	if offset != 60 || done || found != 3 {
+		t.Errorf("progress() = %d %v %d", offset, done, found)
+	}
+	if got := cp.pending("bing:老虎"); !reflect.DeepEqual(got, []Result{{URL: "https://a.com/3.jpg"}}) {
+		t.Errorf("pending() = %v", got)
+	}
+	if got := cp.files("bing:老虎"); !reflect.DeepEqual(got, []string{"/tmp/1.png"}) {
+		t.Errorf("files() = %v", got)
+	}
+	// 丢弃不完整的行后可以继续追加
+	if err = cp.searchDone("bing:老虎"); err != nil {
+		t.Fatal(err)
+	}
+	cp.close()
+	cp, err = openCheckpoint(filename)
+	if err != nil {
+		t.Fatalf("openCheckpoint() error = %v", err)
+	}
+	if _, done, _ = cp.progress("bing:老虎"); !done {
+		t.Errorf("progress() done = false")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/15 下午2:00
+* @Package:
+ */
+
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: imagecapture download [flags] [file]")
+		fmt.Fprintln(fs.Output(), "从文件读取图片地址（每行一个），未指定文件或为 - 时从标准输入读取")
+		fs.PrintDefaults()
+	}
+	var df downloadFlags
+	df.register(fs)
+	engine := fs.String("engine", imagecapture.EngineBaidu, "使用该引擎的请求头下载: baidu, bing")
+	verbose := verboseFlag(fs)
+	fs.Parse(args)
+	input := io.Reader(os.Stdin)
+	if name := fs.Arg(0); name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	urls, err := readURLs(input)
+	if err != nil {
+		return err
+	}
+	captureOpts, err := df.captureOptions()
+	if err != nil {
+		return err
+	}
+	capture, err := imagecapture.NewCapture(*engine, 1, append(captureOpts, logOptions(*verbose)...)...)
+	if err != nil {
+		return err
+	}
+	defer capture.Close()
+	results := make([]imagecapture.Result, len(urls))
+	for i, url := range urls {
+		results[i].URL = url
+	}
+	return download(capture, results, &df)
+}
+
+func runCrawl(args []string) error {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: imagecapture crawl [flags] <keyword>")
+		fs.PrintDefaults()
+	}
+	var (
+		sf searchFlags
+		df downloadFlags
+	)
+	sf.register(fs)
+	df.register(fs)
+	n := fs.Int("n", 20, "最多下载的图片数量")
+	verbose := verboseFlag(fs)
+	fs.Parse(args)
+	keyword, err := keywordArg(fs)
+	if err != nil {
+		return err
+	}
+	captureOpts, err := df.captureOptions()
+	if err != nil {
+		return err
+	}
+	captureOpts = append(captureOpts, sf.captureOptions()...)
+	capture, err := imagecapture.NewCapture(sf.engine, sf.routines, append(captureOpts, logOptions(*verbose)...)...)
+	if err != nil {
+		return err
+	}
+	defer capture.Close()
+	opts, err := sf.options()
+	if err != nil {
+		return err
+	}
+	results, err := capture.Search(keyword, *n, opts...)
+	if err != nil {
+		return err
+	}
+	if !df.quiet {
+		fmt.Fprintf(os.Stderr, "found %d images for %q\n", len(results), keyword)
+	}
+	return download(capture, results, &df)
+}
+
+// 读取图片地址，忽略空行和 # 开头的注释
+func readURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// 分批下载以便输出进度，成功的文件路径输出到标准输出
+func download(d imagecapture.Downloader, results []imagecapture.Result, df *downloadFlags) (err error) {
+	var opts []imagecapture.DownloadOption
+	if df.manifest != "" {
+		var manifest *imagecapture.Manifest
+		if manifest, err = imagecapture.NewManifest(df.manifest); err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := manifest.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		opts = append(opts, imagecapture.WithManifest(manifest))
+	}
+	dir := df.dir
+	if df.archive != "" {
+		var archive *imagecapture.Archive
+		archive, err = imagecapture.NewArchive(df.dir, df.archive, imagecapture.WithShardBytes(df.shardSize<<20))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := archive.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		opts = append(opts, imagecapture.WithArchive(archive))
+		dir = "" // 图片放在分片的根目录
+	}
+	batchSize := imagecapture.Max(df.concurrency, 1) * 4
+	saved := 0
+	for i := 0; i < len(results); i += batchSize {
+		end := imagecapture.Min(i+batchSize, len(results))
+		batchOpts := opts
+		if df.name != "" {
+			batchOpts = append(opts[:len(opts):len(opts)], imagecapture.WithFilenameTemplate(df.name), imagecapture.WithIndexOffset(i))
+		}
+		paths, err := d.DownloadResults(results[i:end], dir, df.md5, batchOpts...)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		saved += len(paths)
+		if !df.quiet {
+			fmt.Fprintf(os.Stderr, "[%d/%d] downloaded %d, failed %d\n", end, len(results), saved, end-saved)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/21 下午4:00
+* @Package:
+ */
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: imagecapture export [flags] <manifest> <dst>")
+		fmt.Fprintln(fs.Output(), "按数据集清单导出训练数据")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "imagefolder", "导出格式: imagefolder, huggingface, webdataset")
+	split := fs.String("split", "0.8,0.1,0.1", "train,val,test 划分比例（webdataset 不划分）")
+	shardSize := fs.Int("shard-size", 1000, "webdataset 每个分片的样本数")
+	link := fs.Bool("link", false, "优先使用硬链接代替复制文件")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("missing manifest or destination")
+	}
+	manifest, dst := fs.Arg(0), fs.Arg(1)
+	opts := []imagecapture.ExportOption{imagecapture.WithShardSize(*shardSize)}
+	if *link {
+		opts = append(opts, imagecapture.WithHardLink())
+	}
+	if *format == "webdataset" {
+		shards, err := imagecapture.ExportWebDataset(manifest, dst, opts...)
+		if err != nil {
+			return err
+		}
+		for _, shard := range shards {
+			fmt.Println(shard)
+		}
+		return nil
+	}
+	ratios, err := parseSplit(*split)
+	if err != nil {
+		return err
+	}
+	var counts map[string]int
+	switch *format {
+	case "imagefolder":
+		counts, err = imagecapture.ExportImageFolder(manifest, dst, ratios, opts...)
+	case "huggingface", "hf":
+		counts, err = imagecapture.ExportHuggingFace(manifest, dst, ratios, opts...)
+	default:
+		return fmt.Errorf("unknown export format: %s", *format)
+	}
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %d\n", name, counts[name])
+	}
+	return nil
+}
+
+// 解析 train,val,test 比例，缺省的部分为 0
+func parseSplit(s string) (imagecapture.Split, error) {
+	var ratios [3]float64
+	parts := splitList(s)
+	if len(parts) == 0 || len(parts) > 3 {
+		return imagecapture.Split{}, fmt.Errorf("invalid split: %q", s)
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return imagecapture.Split{}, fmt.Errorf("invalid split: %q", s)
+		}
+		ratios[i] = v
+	}
+	return imagecapture.Split{Train: ratios[0], Val: ratios[1], Test: ratios[2]}, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/15 下午2:00
+* @Package:
+ */
+
+// 搜索相关参数
+type searchFlags struct {
+	engine      string
+	routines    int
+	maxRoutines int
+	validate    string
+	spec        imagecapture.SearchSpec
+	exts        string
+	domains     string
+}
+
+func (f *searchFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.engine, "engine", imagecapture.EngineBaidu, "搜索引擎: baidu, bing")
+	fs.IntVar(&f.routines, "routines", 3, "搜索并发数")
+	fs.IntVar(&f.maxRoutines, "max-routines", 0, "大于 -routines 时开启自适应搜索并发，作为并发数的上限")
+	fs.StringVar(&f.validate, "validate", "", "原图地址校验方式（仅必应）: head, range, none, deferred，默认 head")
+	fs.StringVar(&f.spec.Size, "size", "", "尺寸: small, medium, large, enormous, wallpaper")
+	fs.IntVar(&f.spec.CustomWidth, "custom-width", 0, "自定义最小宽度（仅必应）")
+	fs.IntVar(&f.spec.CustomHeight, "custom-height", 0, "自定义最小高度（仅必应）")
+	fs.StringVar(&f.spec.Color, "color", "", "颜色: color, bw, red, orange, yellow, green, teal, blue, purple, pink, brown, black, gray, white")
+	fs.StringVar(&f.spec.Type, "type", "", "类型: photo, clipart, animated, line, transparent")
+	fs.StringVar(&f.spec.Layout, "layout", "", "布局: square, wide, tall")
+	fs.StringVar(&f.spec.License, "license", "", "授权: all, public, share, commercial, modify, modify-commercial")
+	fs.StringVar(&f.spec.Freshness, "freshness", "", "时效: latest, day, week, month, year")
+	fs.StringVar(&f.spec.SafeSearch, "safe", "", "安全搜索（仅必应）: off, moderate, strict")
+	fs.BoolVar(&f.spec.Hd, "hd", false, "只搜索高清图（仅百度）")
+	fs.StringVar(&f.exts, "ext", "", "只保留的图片后缀，逗号分隔，例如 jpg,png")
+	fs.StringVar(&f.domains, "domains", "", "只保留的域名，逗号分隔")
+	fs.IntVar(&f.spec.MinWidth, "min-width", 0, "最小宽度（按引擎返回的尺寸过滤）")
+	fs.IntVar(&f.spec.MinHeight, "min-height", 0, "最小高度（按引擎返回的尺寸过滤）")
+	fs.StringVar(&f.spec.TitlePattern, "title", "", "标题正则")
+	fs.StringVar(&f.spec.RulesFile, "rules", "", "来源过滤规则文件（yaml/json）")
+	fs.BoolVar(&f.spec.NoDefaultRules, "no-default-rules", false, "关闭默认的来源过滤规则")
+}
+
+func (f *searchFlags) options() ([]imagecapture.Option, error) {
+	f.spec.Extensions = splitList(f.exts)
+	f.spec.Domains = splitList(f.domains)
+	return f.spec.Options()
+}
+
+// 搜索相关的采集器选项
+func (f *searchFlags) captureOptions() []imagecapture.CaptureOption {
+	var opts []imagecapture.CaptureOption
+	if f.maxRoutines > f.routines {
+		opts = append(opts, imagecapture.WithAdaptiveSearch(imagecapture.AdaptiveConfig{Max: f.maxRoutines}))
+	}
+	if f.validate != "" {
+		opts = append(opts, imagecapture.WithValidation(imagecapture.ValidationMode(f.validate)))
+	}
+	return opts
+}
+
+// 逗号分隔的列表
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// 解析关键词参数，多个参数以空格拼接
+func keywordArg(fs *flag.FlagSet) (string, error) {
+	keyword := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if keyword == "" {
+		return "", fmt.Errorf("missing keyword")
+	}
+	return keyword, nil
+}
+
+// 下载相关参数
+type downloadFlags struct {
+	dir         string
+	md5         bool
+	concurrency int
+	maxConc     int
+	perHost     int
+	quiet       bool
+	manifest    string
+	archive     string
+	shardSize   int64
+	s3          string
+	s3Endpoint  string
+	s3PathStyle bool
+	s3NoList    bool
+	overwrite   string
+	fsync       bool
+	name        string
+}
+
+func (f *downloadFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dir, "dir", "./images", "保存目录")
+	fs.BoolVar(&f.md5, "md5", true, "以图片 md5 命名文件")
+	fs.StringVar(&f.name, "name", "", "文件名模板，设置后忽略 -md5，例如 {keyword}/{index:6}-{width}x{height}.{ext}。\n"+
+		"占位符: {keyword} {engine} {index} {md5} {sha256} {uuid} {host} {date} {width} {height} {ext}")
+	fs.IntVar(&f.concurrency, "concurrency", 8, "下载并发数")
+	fs.IntVar(&f.maxConc, "max-concurrency", 0, "大于 -concurrency 时开启自适应下载并发，作为并发数的上限")
+	fs.IntVar(&f.perHost, "host-concurrency", 4, "每个域名同时下载的图片数，0 表示不限制")
+	fs.BoolVar(&f.quiet, "quiet", false, "不输出下载进度")
+	fs.StringVar(&f.manifest, "manifest", "", "追加写入数据集清单，根据后缀选择格式: .jsonl .csv")
+	fs.StringVar(&f.archive, "archive", "", "将图片写入 -dir 下的归档分片而不是单独的文件: tar, tar.gz, zip")
+	fs.Int64Var(&f.shardSize, "shard-mb", 1024, "单个归档分片的大小上限（MB）")
+	fs.StringVar(&f.s3, "s3", "", "上传到 S3 兼容存储，格式 s3://bucket/prefix，此时 -dir 为 prefix 下的目录。\n"+
+		"密钥读取环境变量 AWS_ACCESS_KEY_ID、AWS_SECRET_ACCESS_KEY，区域读取 AWS_REGION")
+	fs.StringVar(&f.s3Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3 服务地址，例如 MinIO 的 http://127.0.0.1:9000")
+	fs.BoolVar(&f.s3PathStyle, "s3-path-style", false, "使用 path style 地址，MinIO 需要开启")
+	fs.BoolVar(&f.s3NoList, "s3-no-list", false, "密钥没有 s3:ListBucket 权限时开启，检查对象是否存在时把 403 视为不存在")
+	fs.StringVar(&f.overwrite, "overwrite", string(imagecapture.Overwrite_ERROR), "目标文件已存在时: error, skip, overwrite, rename")
+	fs.BoolVar(&f.fsync, "fsync", false, "每张图片写入后 fsync，保证断电后不丢失")
+}
+
+// 下载器相关的采集器选项
+func (f *downloadFlags) captureOptions() ([]imagecapture.CaptureOption, error) {
+	policy := imagecapture.OverwritePolicy(f.overwrite)
+	switch policy {
+	case imagecapture.Overwrite_ERROR, imagecapture.Overwrite_SKIP, imagecapture.Overwrite_REPLACE, imagecapture.Overwrite_RENAME:
+	default:
+		return nil, fmt.Errorf("invalid overwrite policy: %q", f.overwrite)
+	}
+	opts := []imagecapture.CaptureOption{
+		imagecapture.WithDownloadRoutines(f.concurrency),
+		imagecapture.WithHostConcurrency(f.perHost),
+		imagecapture.WithOverwritePolicy(policy),
+	}
+	if f.maxConc > f.concurrency {
+		opts = append(opts, imagecapture.WithAdaptiveDownload(imagecapture.AdaptiveConfig{Max: f.maxConc}))
+	}
+	if f.s3 == "" {
+		if f.fsync {
+			opts = append(opts, imagecapture.WithSink(imagecapture.NewFileSink("", imagecapture.WithFileSync())))
+		}
+		return opts, nil
+	}
+	if f.archive != "" {
+		return nil, fmt.Errorf("-archive can not be used with -s3")
+	}
+	bucket := strings.TrimPrefix(f.s3, "s3://")
+	if bucket == f.s3 || bucket == "" {
+		return nil, fmt.Errorf("invalid s3 location: %q", f.s3)
+	}
+	bucket, prefix, _ := strings.Cut(bucket, "/")
+	sink, err := imagecapture.NewS3Sink(imagecapture.S3Config{
+		Endpoint:           f.s3Endpoint,
+		Region:             os.Getenv("AWS_REGION"),
+		Bucket:             bucket,
+		AccessKey:          os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:          os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Prefix:             prefix,
+		PathStyle:          f.s3PathStyle,
+		ForbiddenAsMissing: f.s3NoList,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, imagecapture.WithSink(sink)), nil
+}
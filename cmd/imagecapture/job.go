@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/18 下午4:00
+* @Package:
+ */
+
+func runJob(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: imagecapture run [flags] <job.yaml>")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "", "覆盖任务文件中的输出目录")
+	concurrency := fs.Int("concurrency", 0, "覆盖任务文件中同时处理的关键词数量")
+	verbose := verboseFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("missing job file")
+	}
+	job, err := imagecapture.LoadJob(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *dir != "" {
+		job.Output.Dir = *dir
+	}
+	if *concurrency > 0 {
+		job.Limits.Concurrency = *concurrency
+	}
+	summary, err := imagecapture.RunJob(job, logOptions(*verbose)...)
+	if err != nil {
+		return err
+	}
+	for _, task := range summary.Tasks {
+		if task.Error != "" {
+			fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", task.Engine, task.Keyword, task.Error)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "[%s] %s: found %d, downloaded %d\n", task.Engine, task.Keyword, task.Found, task.Downloaded)
+	}
+	fmt.Printf("job %s finished in %s: found %d, downloaded %d\n",
+		summary.Name, summary.FinishedAt.Sub(summary.StartedAt).Round(1e6), summary.Found, summary.Downloaded)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/27 上午10:30
+* @Package:
+ */
+
+// 输出到标准错误的日志，每行格式为：时间 级别 消息 key=value ...
+type textLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *textLogger) log(level, msg string, args []any) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", time.Now().Format("15:04:05.000"), level, msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	b.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+func (l *textLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg, args) }
+func (l *textLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args) }
+func (l *textLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args) }
+func (l *textLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args) }
+
+// 注册 -v 参数
+func verboseFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("v", false, "输出调试日志到标准错误：搜索请求、重试、被过滤的结果和每张图片的下载结果")
+}
+
+// 开启 -v 时的日志选项
+func logOptions(verbose bool) []imagecapture.CaptureOption {
+	if !verbose {
+		return nil
+	}
+	return []imagecapture.CaptureOption{imagecapture.WithLogger(&textLogger{w: os.Stderr})}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/15 下午2:00
+* @Package: 命令行工具，支持搜索、批量下载、搜索并下载
+ */
+
+const usage = `imagecapture 图片搜索与批量下载工具
+
+用法:
+  imagecapture <command> [flags]
+
+命令:
+  search    按关键词搜索图片，输出图片地址
+  download  从文件或标准输入读取图片地址并批量下载
+  crawl     搜索并下载
+  run       执行 yaml/json 任务文件，批量抓取多个关键词
+  export    按数据集清单导出 ImageFolder、HuggingFace、WebDataset 格式
+  serve     启动 HTTP 服务，提供搜索、下载任务和图片转发接口
+
+使用 "imagecapture <command> -h" 查看命令参数
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "search":
+		err = runSearch(os.Args[2:])
+	case "download":
+		err = runDownload(os.Args[2:])
+	case "crawl":
+		err = runCrawl(os.Args[2:])
+	case "run":
+		err = runJob(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
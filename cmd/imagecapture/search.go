@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/15 下午2:00
+* @Package:
+ */
+
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: imagecapture search [flags] <keyword>")
+		fs.PrintDefaults()
+	}
+	var sf searchFlags
+	sf.register(fs)
+	n := fs.Int("n", 20, "最多返回的图片数量")
+	format := fs.String("format", "urls", "输出格式: urls, json, ndjson")
+	verbose := verboseFlag(fs)
+	fs.Parse(args)
+	keyword, err := keywordArg(fs)
+	if err != nil {
+		return err
+	}
+	results, err := search(&sf, keyword, *n, logOptions(*verbose)...)
+	if err != nil {
+		return err
+	}
+	return writeResults(os.Stdout, results, *format)
+}
+
+func search(sf *searchFlags, keyword string, n int, opts ...imagecapture.CaptureOption) ([]imagecapture.Result, error) {
+	capture, err := imagecapture.NewCapture(sf.engine, sf.routines, append(sf.captureOptions(), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer capture.Close()
+	searchOpts, err := sf.options()
+	if err != nil {
+		return nil, err
+	}
+	return capture.Search(keyword, n, searchOpts...)
+}
+
+func writeResults(w io.Writer, results []imagecapture.Result, format string) error {
+	switch format {
+	case "urls":
+		for _, r := range results {
+			fmt.Fprintln(w, r.URL)
+		}
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, r := range results {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
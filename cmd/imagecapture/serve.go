@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+	"github.com/code-innovator-zyx/imagecapture/server"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/2 下午2:00
+* @Package:
+ */
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: imagecapture serve [flags]")
+		fmt.Fprintln(fs.Output(), "以 HTTP 服务提供 /search、/download、/download/{id}、/proxy 接口")
+		fs.PrintDefaults()
+	}
+	var cfg server.Config
+	addr := fs.String("addr", ":8080", "监听地址")
+	fs.StringVar(&cfg.Dir, "dir", "./images", "下载任务的输出目录，每个任务保存在 <dir>/<id> 下")
+	keys := fs.String("api-keys", "", "允许访问的 API Key，逗号分隔，为空时读取环境变量 IMAGECAPTURE_API_KEYS，都为空时不校验")
+	fs.IntVar(&cfg.Routines, "routines", 3, "搜索并发数")
+	concurrency := fs.Int("concurrency", 8, "单个下载任务的下载并发数")
+	fs.BoolVar(&cfg.AllowPrivateHosts, "allow-private", false, "允许 /proxy 和下载任务访问内网地址")
+	fs.IntVar(&cfg.Limits.MaxResults, "max-results", 200, "单次搜索最多返回的数量")
+	fs.IntVar(&cfg.Limits.MaxURLs, "max-urls", 1000, "单个下载任务最多的图片数")
+	fs.IntVar(&cfg.Limits.MaxConcurrent, "max-concurrent", 64, "同时处理的请求数")
+	fs.IntVar(&cfg.Limits.MaxJobs, "max-jobs", 2, "同时执行的下载任务数")
+	fs.IntVar(&cfg.Limits.MaxQueuedJobs, "max-queued-jobs", 100, "排队中的下载任务上限")
+	fs.Float64Var(&cfg.Limits.RateLimit, "rate", 0, "每个 API Key（未开启鉴权时为每个客户端 IP）每秒的请求数，0 表示不限制")
+	fs.IntVar(&cfg.Limits.Burst, "burst", 0, "突发请求数，默认为 -rate 向上取整")
+	verbose := verboseFlag(fs)
+	fs.Parse(args)
+
+	if *keys == "" {
+		*keys = os.Getenv("IMAGECAPTURE_API_KEYS")
+	}
+	cfg.APIKeys = splitList(*keys)
+	cfg.CaptureOptions = append(logOptions(*verbose), imagecapture.WithDownloadRoutines(*concurrency))
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	handler := http.Handler(srv)
+	if *verbose {
+		handler = accessLog(handler, &textLogger{w: os.Stderr})
+	}
+	httpServer := &http.Server{Addr: *addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.ListenAndServe()
+	}()
+	if len(cfg.APIKeys) == 0 {
+		fmt.Fprintln(os.Stderr, "warning: no api keys configured, all requests are allowed")
+	}
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+	select {
+	case err = <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// 记录每个请求的方法、路径、状态码和耗时
+func accessLog(next http.Handler, logger imagecapture.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		logger.Info("request", "method", r.Method, "path", r.URL.Path, "status", sw.status,
+			"duration", time.Since(start), "remote", r.RemoteAddr)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,63 @@
+package imagecapture
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/18 上午10:00
+* @Package: 限制可以连接的地址
+ */
+
+// DialGuard 在建立连接前检查对方的地址，返回错误时拒绝连接
+type DialGuard func(ip netip.Addr) error
+
+// WithDialGuard 设置采集器和内置下载器的连接检查。检查的是实际建立连接的地址，
+// 重定向后的地址、DNS 重新解析（重绑定）后的地址同样会被检查，对外提供下载服务时用于禁止访问内网
+func WithDialGuard(guard DialGuard) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.dialGuard = guard
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.dialGuard = guard
+		})
+	}
+}
+
+// 作为 net.Dialer.Control，address 为解析后的 ip:port
+func (g DialGuard) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", address, err)
+	}
+	return g(addrPort.Addr())
+}
+
+// 每次重定向都检查目标地址：只允许 http、https，IP 地址直接检查，域名在连接时检查
+func (g DialGuard) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme: %q", req.URL.Scheme)
+	}
+	if ip, err := netip.ParseAddr(req.URL.Hostname()); err == nil {
+		return g(ip)
+	}
+	return nil
+}
+
+// 为 dialer 和 client 设置连接检查，guard 为 nil 时不做限制
+func (g DialGuard) apply(dialer *net.Dialer, client *http.Client) {
+	if g == nil {
+		return
+	}
+	dialer.Control = g.control
+	client.CheckRedirect = g.checkRedirect
+}
@@ -0,0 +1,88 @@
+package imagecapture
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWithDialGuard(t *testing.T) {
+	// 重定向的目标监听在 127.0.0.2，模拟公网地址重定向到内网
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	var hits int32
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write(testPNG)
+	}))
+	target.Listener.Close()
+	target.Listener = listener
+	target.Start()
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		location := target.URL + "/ok.png"
+		if r.URL.Path == "/file" {
+			location = "file:///etc/passwd"
+		}
+		http.Redirect(w, r, location, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	internal := netip.MustParseAddr("127.0.0.2")
+	tests := []struct {
+		name    string
+		url     string
+		guard   DialGuard
+		wantErr string
+	}{
+		{"no guard", redirect.URL + "/ok", nil, ""},
+		{"allowed", target.URL + "/ok.png", func(ip netip.Addr) error { return nil }, ""},
+		{"denied", target.URL + "/ok.png", func(ip netip.Addr) error {
+			if ip == internal {
+				return errors.New("denied")
+			}
+			return nil
+		}, "denied"},
+		// 第一个地址允许访问，重定向后的地址在连接时被拒绝
+		{"redirect", redirect.URL + "/ok", func(ip netip.Addr) error {
+			if ip == internal {
+				return errors.New("denied")
+			}
+			return nil
+		}, "denied"},
+		{"redirect scheme", redirect.URL + "/file", func(ip netip.Addr) error { return nil }, "unsupported scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&hits, 0)
+			var opts []CaptureOption
+			if tt.guard != nil {
+				opts = append(opts, WithDialGuard(tt.guard))
+			}
+			bc := NewBingCapture(1, opts...).(*BingCapture)
+			defer bc.Close()
+			var buf bytes.Buffer
+			_, err := bc.Download(tt.url, "", &buf)
+			if tt.wantErr == "" {
+				if err != nil || !bytes.Equal(buf.Bytes(), testPNG) {
+					t.Errorf("Download() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Download() error = %v, want %s", err, tt.wantErr)
+			}
+			if n := atomic.LoadInt32(&hits); n != 0 {
+				t.Errorf("target requests = %d", n)
+			}
+		})
+	}
+}
@@ -2,13 +2,16 @@ package imagecapture
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/panjf2000/ants/v2"
 	"hash"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -29,53 +32,259 @@ type Downloader interface {
 	// @param writer: 可选的 io.Writer 用于写入数据
 	// @return: 下载成功返回文件名后缀  eg：[png],返回可能的错误
 	Download(url, filename string, writer io.Writer) (string, error)
+	// 与 Download 相同，ctx 取消时中止请求，例如转发图片时客户端已断开
+	DownloadContext(ctx context.Context, url, filename string, writer io.Writer) (string, error)
+	// 与 Download 相同，原图请求失败时依次下载搜索结果的中等尺寸图、缩略图，另外返回保存的图片版本
+	DownloadResult(result Result, filename string, writer io.Writer) (string, ImageVariant, error)
 	//// 批量下载所有图片到指定目录，是否以图片的 MD5 值命名，返回已下载成功的文件路径。
 	//// @param urls: 图片 URL 列表
 	//// @param dir: 保存目录
 	//// @param useMd5Naming: 是否使用 MD5 值命名
+	//// @param opts: 额外参数，支持多种选项
 	//// @return: 返回已成功下载的文件路径列表和可能的错误
-	BatchDownload(urls []string, dir string, useMd5Naming bool) ([]string, error)
+	BatchDownload(urls []string, dir string, useMd5Naming bool, opts ...DownloadOption) ([]string, error)
+	// 与 BatchDownload 相同，下载报告和清单中会带上搜索结果的引擎、关键词、缩略图等来源信息，
+	// 原图请求失败时按 WithVariants 依次下载中等尺寸图、缩略图
+	DownloadResults(results []Result, dir string, useMd5Naming bool, opts ...DownloadOption) ([]string, error)
+}
+
+// DownloadReport 单张图片的下载结果
+type DownloadReport struct {
+	URL     string       // 图片 URL
+	Path    string       // 保存的文件路径，失败时为空；写入归档时为分片内的路径
+	Err     error        // 失败原因
+	Archive string       // 写入归档时所在分片的路径
+	Skipped bool         // 目标已存在，按 Overwrite_SKIP 保留了已有文件
+	Variant ImageVariant // 保存的图片版本，原图失败后回退时为中等尺寸图或缩略图
+	ImageInfo
+	FetchedAt time.Time // 下载完成时间
+	// 以下来源信息只有 DownloadResults 会填充
+	Engine    string
+	Keyword   string
+	Thumbnail string
+	Title     string
+	Source    string // 图片所在网页
+}
+
+// 填充搜索结果的来源信息
+func (r *DownloadReport) setSource(source Result) {
+	r.Engine, r.Keyword = source.Engine, source.Keyword
+	r.Thumbnail, r.Title, r.Source = source.Thumbnail, source.Title, source.Source
+}
+
+// DownloadOption 批量下载选项
+type DownloadOption func(*downloadConfig)
+
+type downloadConfig struct {
+	onReport func(DownloadReport)
+	manifest *Manifest
+	archive  *Archive
+	template string
+	index    func(pos int, url string) int // 图片在本次下载中的位置转换为文件名模板中的 {index}
+	priority func(r Result) int
+	ctx      context.Context
+	timeout  time.Duration  // 批量下载的总时长，0 不限制
+	variants []ImageVariant // 依次尝试的图片版本，nil 时为 defaultVariants
+}
+
+func newDownloadConfig(opts []DownloadOption) downloadConfig {
+	cfg := downloadConfig{ctx: context.Background()}
+	for _, option := range opts {
+		option(&cfg)
+	}
+	return cfg
+}
+
+func (cfg downloadConfig) report(r DownloadReport) {
+	if cfg.manifest != nil {
+		cfg.manifest.Write(r)
+	}
+	if cfg.onReport != nil {
+		cfg.onReport(r)
+	}
+}
+
+// WithReportHook 每张图片下载完成（成功或失败）后回调，回调会在多个下载协程中并发执行
+func WithReportHook(fn func(DownloadReport)) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.onReport = fn
+	}
+}
+
+// WithArchive 将图片写入归档分片而不是单独的文件，此时 dir 为图片在分片内的目录
+func WithArchive(a *Archive) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.archive = a
+	}
+}
+
+// WithManifest 将下载成功的图片写入数据集清单
+func WithManifest(m *Manifest) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.manifest = m
+	}
+}
+
+// WithFilenameTemplate 使用模板生成文件名，替代 uuid 或 md5 命名（此时忽略 useMd5Naming），
+// 模板中的 / 会生成下载目录下的子目录，例如 {engine}/{keyword}/{date}/{index:6}-{width}x{height}.{ext}。
+// 支持的占位符：{keyword} {engine} {index} {md5} {sha256} {uuid} {host} {date} {width} {height} {ext}，
+// 没有 {ext} 时自动追加后缀。模板无效时 BatchDownload 直接返回错误
+func WithFilenameTemplate(template string) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.template = template
+	}
+}
+
+// WithIndexOffset 文件名模板中 {index} 的起始值，分批下载时保证序号连续
+func WithIndexOffset(offset int) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.index = func(pos int, _ string) int {
+			return offset + pos
+		}
+	}
+}
+
+// WithPriority 设置批量下载的优先级，返回值越大越先下载，相同时按传入的顺序。
+// BatchDownload 传入的 Result 只有 URL，DownloadResults 传入完整的搜索结果
+func WithPriority(fn func(r Result) int) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.priority = fn
+	}
+}
+
+// 按图片地址指定 {index}，用于任务续跑时保持序号不变
+func withIndexes(indexes map[string]int) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.index = func(pos int, url string) int {
+			if i, ok := indexes[url]; ok {
+				return i
+			}
+			return pos
+		}
+	}
+}
+
+// 文件名模板，未设置时按 useMd5Naming 选择 md5 或 uuid 命名
+func (cfg downloadConfig) nameTemplate(useMd5Naming bool) (*nameTemplate, error) {
+	template := cfg.template
+	if template == "" {
+		template = uuidNameTemplate
+		if useMd5Naming {
+			template = md5NameTemplate
+		}
+	}
+	return parseNameTemplate(template)
 }
 
 // Downloader 包含重试和流控制属性
 type downloader struct {
-	client     *http.Client
-	retryTimes int // 最大重试次数
-	header     http.Header
-	bufferSize int // 缓冲区大小
-	md5        hash.Hash
-	connPool   *sync.Pool    // 连接池
-	timeout    time.Duration // 请求超时时间
+	client         *http.Client
+	retryTimes     int // 最大重试次数
+	header         http.Header
+	bufferSize     int // 缓冲区大小
+	md5            hash.Hash
+	connPool       *sync.Pool    // 连接池
+	timeout        time.Duration // 单次请求的超时
+	connectTimeout time.Duration // 建立连接的超时
+	itemTimeout    time.Duration // 单张图片（含重试）的超时
+	routines       int           // 批量下载的初始并发数
+	sink           Sink          // 图片存储位置
+	overwrite      OverwritePolicy
+	logger         Logger
+	metrics        Metrics
+	tracer         Tracer
+	hostLimit      int                 // 批量下载时每个域名同时下载的图片数，<= 0 不限制
+	engine         string              // 所属的搜索引擎，用于指标
+	adaptive       *AdaptiveConfig     // 自适应并发配置，nil 时并发数固定为 routines
+	limiter        *concurrencyLimiter // 批量下载的并发数
+	injectedPool   WorkerPool          // 调用方注入的协程池，nil 时由下载器创建
+	pool           *workerPool
+	keyLocks       [64]sync.Mutex // 按 key 分段加锁，避免并发写入同一个 key 时覆盖策略失效
+	dialGuard      DialGuard      // 连接前检查对方地址，nil 时不限制
+}
+
+type downloaderOption func(*downloader)
+
+func withEngine(engine string) downloaderOption {
+	return func(d *downloader) {
+		d.engine = engine
+	}
+}
+
+// WithOverwritePolicy 设置目标文件已存在时的处理方式，Download 和 BatchDownload 都会遵循，默认 Overwrite_ERROR
+func WithOverwritePolicy(policy OverwritePolicy) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.overwrite = policy
+		})
+	}
+}
+
+// WithHostConcurrency 设置批量下载时每个域名同时下载的图片数，默认 4，n <= 0 时不限制。
+// 批量下载会按域名分组，在域名之间轮转，避免所有下载协程集中请求同一个域名而被限流
+func WithHostConcurrency(n int) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.hostLimit = n
+		})
+	}
 }
 
 // newDownloader 创建新的下载器
-func newDownloader(client *http.Client, h map[string]string) Downloader {
+func newDownloader(client *http.Client, h map[string]string, opts ...downloaderOption) Downloader {
 	handle := &downloader{
-		client:     client,
-		retryTimes: 3,
-		bufferSize: 64 * 1024, //64kb
-		header:     make(http.Header, len(h)),
-		timeout:    10 * time.Second,
-		connPool: &sync.Pool{
-			New: func() interface{} {
-				return &http.Client{
-					Timeout: 10 * time.Second,
-					Transport: &http.Transport{
-						MaxIdleConns:        100,
-						MaxIdleConnsPerHost: 10,
-						IdleConnTimeout:     90 * time.Second,
-					},
-				}
-			},
+		client:         client,
+		retryTimes:     3,
+		routines:       maxDownloadRoutines,
+		hostLimit:      defaultHostConcurrency,
+		sink:           NewFileSink(""),
+		logger:         nopLogger{},
+		metrics:        nopMetrics{},
+		tracer:         nopTracer{},
+		bufferSize:     64 * 1024, //64kb
+		header:         make(http.Header, len(h)),
+		timeout:        defaultDownloadTimeout,
+		connectTimeout: defaultConnectTimeout,
+		itemTimeout:    defaultItemTimeout,
+	}
+	// 超时在选项生效后才确定，client 在第一次使用时创建
+	handle.connPool = &sync.Pool{
+		New: func() interface{} {
+			return newHTTPClient(&http.Transport{
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			}, Timeouts{Connect: handle.connectTimeout, Request: handle.timeout}, handle.dialGuard)
 		},
 	}
 	for k, v := range h {
 		handle.header.Set(k, v)
 	}
+	for _, option := range opts {
+		option(handle)
+	}
+	handle.limiter = newConcurrencyLimiter(handle.routines, handle.adaptive)
+	handle.pool = newWorkerPool(handle.injectedPool, handle.limiter)
 	return handle
 }
 
-func (d *downloader) get(url string, newWriter func(string) (io.Writer, error), mdCallback func(string)) error {
+// Close 释放下载器创建的协程池
+func (d *downloader) Close() error {
+	d.pool.close()
+	return nil
+}
+
+// 批量下载的并发数，d 不是内置下载器时为空
+func downloaderStats(d Downloader) PoolStats {
+	if d, ok := d.(*downloader); ok {
+		return d.limiter.stats()
+	}
+	return PoolStats{}
+}
+
+// 每次请求一个 span，成功的请求在图片数据读完后结束。
+// newWriter 的参数为图片类型和响应的 Content-Length，未知时为 -1
+func (d *downloader) get(ctx context.Context, url string, newWriter func(ty string, size int64) (io.Writer, error), onDone func(ImageInfo)) (err error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
@@ -86,44 +295,74 @@ func (d *downloader) get(url string, newWriter func(string) (io.Writer, error),
 	client := d.connPool.Get().(*http.Client)
 	defer d.connPool.Put(client)
 
-	// 设置请求上下文和超时
-	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	// 单张图片（含重试）的超时
+	ctx, cancel := context.WithTimeout(ctx, d.itemTimeout)
 	defer cancel()
-	req = req.WithContext(ctx)
 
 	try := 0
-	var resp *http.Response
+	var (
+		resp          *http.Response
+		span          Span
+		cancelAttempt context.CancelFunc
+	)
 	for {
 		if try >= d.retryTimes {
-			return ErrMaxRetryExceeded
+			if err == nil {
+				return ErrMaxRetryExceeded
+			}
+			return &retryError{err: err}
 		}
-		resp, err = client.Do(req)
+		var attemptCtx context.Context
+		attemptCtx, span = d.tracer.Start(ctx, SpanDownloadAttempt, "engine", d.engine, "url", url, "attempt", try+1)
+		// 单次请求的超时，请求成功时覆盖到图片数据读完
+		attemptCtx, cancelAttempt = context.WithTimeout(attemptCtx, d.timeout)
+		start := time.Now()
+		resp, err = client.Do(req.WithContext(attemptCtx))
 		if err == nil {
 			break
 		}
+		cancelAttempt()
+		span.End(err)
 		try += 1
-		time.Sleep(time.Duration(try) * 100 * time.Millisecond)
-		if resp.StatusCode != http.StatusOK {
-			fmt.Printf("download [%s] failed: %s\n", url, resp.Status)
-			return fmt.Errorf("download [%s] failed: %s", url, resp.Status)
-		}
-		defer resp.Body.Close()
-		imageReader, err := NewImageReader(resp.Body, mdCallback != nil)
-		if err != nil {
-			return err
-		}
-		writer, err := newWriter(imageReader.Type())
-		if err != nil {
-			return err
+		d.logger.Debug("download request failed", "url", url, "attempt", try, "duration", time.Since(start), "error", err)
+		if try < d.retryTimes {
+			d.metrics.DownloadRetry(d.engine)
 		}
-		_, err = io.Copy(writer, imageReader)
-		if err != nil {
+		// 等待后重试，超时或取消时不再重试
+		select {
+		case <-ctx.Done():
 			return err
+		case <-time.After(time.Duration(try) * 100 * time.Millisecond):
 		}
-		if mdCallback != nil {
-			mdCallback(imageReader.Md5())
-		}
-		return nil
+	}
+	defer cancelAttempt()
+	defer func() {
+		span.End(err)
+	}()
+	defer resp.Body.Close()
+	span.SetAttributes("status", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	imageReader, err := NewImageReader(resp.Body, false)
+	if err != nil {
+		return err
+	}
+	// 防盗链常返回 200 的网页或占位内容，不能当作图片保存
+	if imageReader.Type() == "" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFileType, url)
+	}
+	writer, err := newWriter(imageReader.Type(), resp.ContentLength)
+	if err != nil {
+		return err
+	}
+	meta := newImageMeta()
+	_, err = io.Copy(writer, io.TeeReader(imageReader, meta))
+	if err != nil {
+		return err
+	}
+	if onDone != nil {
+		onDone(meta.info(imageReader.Type()))
 	}
 	return nil
 }
@@ -151,118 +390,346 @@ func newFileWriter(filename string) (*os.File, error) {
 	return file, nil
 }
 
-func (d *downloader) Download(url, filename string, writer io.Writer) (fileSuffix string, err error) {
-	var release func()
-	err = d.get(url, func(suffix string) (io.Writer, error) {
+func (d *downloader) Download(url, filename string, writer io.Writer) (string, error) {
+	return d.DownloadContext(context.Background(), url, filename, writer)
+}
+
+func (d *downloader) DownloadContext(ctx context.Context, url, filename string, writer io.Writer) (fileSuffix string, err error) {
+	var (
+		object SinkObject
+		key    string
+		size   int64
+		start  = time.Now()
+	)
+	defer func() {
+		d.logger.Debug("download", "url", url, "file", key, "duration", time.Since(start), "error", err)
+		d.metrics.Download(d.engine, size, time.Since(start), ErrorClass(err))
+	}()
+	err = d.get(ctx, url, func(suffix string, _ int64) (io.Writer, error) {
 		fileSuffix = suffix
-		if writer == nil {
-			// 写文件
-			writer, err = newFileWriter(fmt.Sprintf("%s.%s", filename, suffix))
-			if nil != err {
-				return nil, err
-			}
-			release = func() {
-				err = writer.(*os.File).Close()
-				if err != nil {
-					fmt.Println("failed to close file:", err)
-				}
-			}
+		if writer != nil {
+			return writer, nil
+		}
+		// 写入 sink，下载前先按覆盖策略检查目标是否已存在
+		key = filepath.ToSlash(fmt.Sprintf("%s.%s", filename, suffix))
+		if _, skip, err := d.resolveKey(ctx, key); err != nil {
+			return nil, err
+		} else if skip {
+			return nil, errSkipped
+		}
+		object, err = d.sink.Create(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	}, func(info ImageInfo) {
+		size = info.Size
+	})
+	if err == errSkipped {
+		return fileSuffix, nil
+	}
+	if object != nil {
+		if err != nil {
+			object.Abort()
+			return
 		}
-		return writer, nil
-	}, nil)
-	if release != nil {
-		release() // 确保打开的文件在完成后被关闭
+		_, _, err = d.commit(ctx, object, key)
 	}
 	return
 }
 
-func (d *downloader) BatchDownload(urls []string, dir string, useMd5Naming bool) ([]string, error) {
-	// firstly created dir
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directories: %w", err)
+func (d *downloader) BatchDownload(urls []string, dir string, useMd5Naming bool, opts ...DownloadOption) ([]string, error) {
+	return d.batchDownload(urls, nil, dir, useMd5Naming, newDownloadConfig(opts))
+}
+
+func (d *downloader) DownloadResults(results []Result, dir string, useMd5Naming bool, opts ...DownloadOption) ([]string, error) {
+	sources := make(map[string]Result, len(results))
+	for _, r := range results {
+		sources[r.URL] = r
 	}
-	pool, _ := ants.NewPool(maxDownloadRoutines)
-	defer pool.Release()
-	var collector = make(chan string, maxDownloadRoutines)
-	var wg sync.WaitGroup
-	var paths = make([]string, 0, len(urls))
-	// 设置单个任务的基础超时（例如 3 秒）
-	baseTimeout := 5 * time.Second
-	timeout := calculateTimeout(len(urls), 1, maxDownloadRoutines, baseTimeout)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return d.batchDownload(resultURLs(results), sources, dir, useMd5Naming, newDownloadConfig(opts))
+}
 
+// sources 为 nil 时下载报告中不填充来源信息
+func (d *downloader) batchDownload(urls []string, sources map[string]Result, dir string, useMd5Naming bool, cfg downloadConfig) ([]string, error) {
+	name, err := cfg.nameTemplate(useMd5Naming)
+	if err != nil {
+		return nil, err
+	}
+	// firstly created dir
+	if fs, ok := d.sink.(*FileSink); ok && cfg.archive == nil {
+		if err := os.MkdirAll(fs.path(dir), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directories: %w", err)
+		}
+	}
+	ctx, cancel := withOptionalTimeout(cfg.ctx, cfg.timeout)
 	defer cancel()
-	for i := range urls {
-		var url = urls[i]
-		wg.Add(1)
-		err := pool.Submit(func() {
-			defer wg.Done()
-			d.saveFile(url, dir, useMd5Naming, collector)
-		})
-		if err != nil {
-			return nil, err
+	batchCtx, span := d.tracer.Start(ctx, SpanBatchDownload, "engine", d.engine, "count", len(urls))
+	defer func() {
+		span.End(err)
+	}()
+	var priority func(pos int, url string) int
+	if cfg.priority != nil {
+		priority = func(_ int, url string) int {
+			source, ok := sources[url]
+			if !ok {
+				source = Result{URL: url}
+			}
+			return cfg.priority(source)
 		}
 	}
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		wg      sync.WaitGroup
+		running int
+		sched   = newHostScheduler(urls, priority, d.hostLimit)
+		paths   = make([]string, 0, len(urls))
+		start   = time.Now()
+		stop    = make(chan struct{})
+	)
+	// 超时或取消后唤醒调度
 	go func() {
-		wg.Wait()
-		close(collector)
-	}()
-SELECT:
-	for {
 		select {
-		case path, ok := <-collector:
-			if !ok {
-				break SELECT
+		case <-batchCtx.Done():
+			mu.Lock()
+			cond.Broadcast()
+			mu.Unlock()
+		case <-stop:
+		}
+	}()
+	// 协程池和域名都有空闲名额时提交下一张图片，超时或取消后不再提交
+	mu.Lock()
+	for !sched.empty() && batchCtx.Err() == nil {
+		if running >= d.limiter.limit() {
+			cond.Wait()
+			continue
+		}
+		item, host, ok := sched.next()
+		if !ok {
+			cond.Wait()
+			continue
+		}
+		index := item.pos
+		if cfg.index != nil {
+			index = cfg.index(item.pos, item.url)
+		}
+		running++
+		wg.Add(1)
+		// 协程池可能被多次批量下载共享，提交时不能持有锁，否则与其他批量下载的任务互相等待
+		mu.Unlock()
+		err = d.pool.submit(func() {
+			defer wg.Done()
+			reportPool(d.metrics, PoolDownload, d.pool)
+			// pool_wait 为从开始批量下载到开始下载这张图片的排队时间
+			report := d.downloadOne(batchCtx, item.url, dir, sources[item.url], index, name, cfg, time.Since(start))
+			mu.Lock()
+			running--
+			sched.done(host)
+			if report.Err == nil {
+				paths = append(paths, report.Path)
+			}
+			cond.Broadcast()
+			mu.Unlock()
+		})
+		mu.Lock()
+		if err != nil {
+			running--
+			sched.done(host)
+			wg.Done()
+			cfg.report(DownloadReport{URL: item.url, Err: err})
+			if err == ErrCaptureClosed {
+				break
 			}
-			paths = append(paths, path)
-		case <-ctx.Done():
-			break SELECT
 		}
 	}
+	mu.Unlock()
+	// 等待已开始的图片结束，超时或取消时它们会随 batchCtx 尽快返回
+	wg.Wait()
+	close(stop)
+	reportPool(d.metrics, PoolDownload, d.pool)
+	if err == ErrCaptureClosed {
+		return paths, err
+	}
+	// 超时或取消导致部分图片没有下载时仍然返回 nil，但 span 记为失败
+	err = batchCtx.Err()
 	return paths, nil
 }
 
-func (d *downloader) saveFile(url, dir string, useMd5Naming bool, collector chan<- string) {
-	var release func()
+// 下载一张图片并记录 span、日志、指标和下载报告，poolWait 为在协程池中排队的时间
+func (d *downloader) downloadOne(ctx context.Context, url, dir string, source Result, index int, name *nameTemplate,
+	cfg downloadConfig, poolWait time.Duration) DownloadReport {
+	start := time.Now()
+	ctx, span := d.tracer.Start(ctx, SpanDownload, "engine", d.engine, "url", url, "pool_wait", poolWait)
+	// 请求失败时依次尝试更小的版本，报告中仍然记录 url，便于按地址对应搜索结果
+	var report DownloadReport
+	for _, c := range fallbackURLs(url, source, cfg.variants) {
+		report = d.save(ctx, c.url, dir, source, index, name, cfg)
+		report.URL, report.Variant = url, c.variant
+		if report.Err == nil || !fetchFailed(report.Err) || ctx.Err() != nil {
+			break
+		}
+		d.logger.Debug("download fallback", "engine", d.engine, "url", c.url, "variant", c.variant, "error", report.Err)
+	}
+	span.SetAttributes("path", report.Path, "size", report.Size, "variant", string(report.Variant))
+	span.End(report.Err)
+	elapsed := time.Since(start)
+	d.limiter.observe(elapsed, report.Err)
+	d.logReport(report, elapsed)
+	d.metrics.Download(d.engine, report.Size, elapsed, ErrorClass(report.Err))
+	cfg.report(report)
+	return report
+}
+
+// 按是否写入归档保存一张图片
+func (d *downloader) save(ctx context.Context, url, dir string, source Result, index int, name *nameTemplate, cfg downloadConfig) DownloadReport {
+	if cfg.archive != nil {
+		return d.saveToArchive(ctx, cfg.archive, url, dir, source, index, name)
+	}
+	return d.saveFile(ctx, url, dir, source, index, name)
+}
+
+// 请求图片失败（包括返回的不是图片），而不是保存失败
+func fetchFailed(err error) bool {
+	if errors.Is(err, ErrUnsupportedFileType) {
+		return true
+	}
+	switch ErrorClass(err) {
+	case ErrorClassHTTP4xx, ErrorClassHTTP5xx, ErrorClassTimeout, ErrorClassNetwork:
+		return true
+	}
+	return false
+}
+
+// 下载完成后才能确定 md5、尺寸等信息，先写入临时的 key，再按文件名模板提交
+func (d *downloader) saveFile(ctx context.Context, url, dir string, source Result, index int, name *nameTemplate) DownloadReport {
+	report := DownloadReport{URL: url}
+	report.setSource(source)
 	uuid, err := GenerateUUID()
 	if err != nil {
-		return
-	}
-	var filename string
-	var md5Callback func(string)
-	var imageSuffix string
-	if useMd5Naming {
-		md5Callback = func(md5 string) {
-			oldName, newName := filename, fmt.Sprintf("%s/%s.%s", dir, md5, imageSuffix)
-			err = os.Rename(oldName, newName)
-			if err != nil {
-				fmt.Println("failed to rename file:", err)
-			}
-			filename = newName
-		}
+		report.Err = err
+		return report
 	}
-	err = d.get(url, func(suffix string) (io.Writer, error) {
-		imageSuffix = suffix
-		filename = fmt.Sprintf("%s/%s.%s", dir, uuid, suffix)
-		writer, err := newFileWriter(filename)
+	var (
+		object SinkObject
+		suffix string
+	)
+	dir = filepath.ToSlash(dir)
+	err = d.get(ctx, url, func(ty string, _ int64) (io.Writer, error) {
+		suffix = ty
+		object, err = d.sink.Create(ctx, path.Join(dir, fmt.Sprintf("%s.%s", uuid, suffix)))
 		if err != nil {
 			return nil, err
 		}
-		release = func() {
-			err = writer.Close()
-			if err != nil {
-				fmt.Println("failed to close writer:", err)
-			}
-		}
-		return writer, nil
-	}, md5Callback)
-	defer func() {
-		if release != nil {
-			release()
+		return object, nil
+	}, func(info ImageInfo) {
+		report.ImageInfo = info
+	})
+	report.FetchedAt = time.Now()
+	if err != nil {
+		if object != nil {
+			object.Abort()
 		}
-	}()
+		report.Err = err
+		return report
+	}
+	key, err := name.expand(&report, index, suffix)
 	if err != nil {
+		object.Abort()
+		report.Err = err
+		return report
+	}
+	report.Path, report.Skipped, report.Err = d.commit(ctx, object, path.Join(dir, key))
+	if report.Err != nil {
+		report.Path = ""
+	}
+	return report
+}
+
+// 输出单张图片的下载结果
+func (d *downloader) logReport(r DownloadReport, elapsed time.Duration) {
+	if r.Err != nil {
+		d.logger.Debug("download failed", "engine", r.Engine, "url", r.URL, "duration", elapsed, "error", r.Err)
 		return
 	}
-	collector <- filename
+	d.logger.Debug("download finished", "engine", r.Engine, "url", r.URL, "path", r.Path, "archive", r.Archive,
+		"size", r.Size, "skipped", r.Skipped, "duration", elapsed)
+}
+
+// 下载前发现目标已存在且策略为跳过时，用于中断下载
+var errSkipped = errors.New("skipped")
+
+// 按覆盖策略确定最终的 key，skip 为 true 表示保留已有对象
+func (d *downloader) resolveKey(ctx context.Context, key string) (final string, skip bool, err error) {
+	exists, err := d.sink.Exists(ctx, key)
+	if err != nil || !exists {
+		return key, false, err
+	}
+	switch d.overwrite {
+	case Overwrite_SKIP:
+		return key, true, nil
+	case Overwrite_REPLACE:
+		return key, false, nil
+	case Overwrite_RENAME:
+		ext := path.Ext(key)
+		base := strings.TrimSuffix(key, ext)
+		for i := 1; ; i++ {
+			final = fmt.Sprintf("%s-%d%s", base, i, ext)
+			if exists, err = d.sink.Exists(ctx, final); err != nil || !exists {
+				return final, false, err
+			}
+		}
+	}
+	return "", false, fmt.Errorf("%w: %s", ErrFileAlreadyExists, key)
+}
+
+// 按覆盖策略保存对象，返回最终的 key
+func (d *downloader) commit(ctx context.Context, object SinkObject, key string) (string, bool, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	lock := &d.keyLocks[h.Sum32()%uint32(len(d.keyLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+	final, skip, err := d.resolveKey(ctx, key)
+	if err != nil || skip {
+		object.Abort()
+		return final, skip, err
+	}
+	return final, false, object.Commit(final)
+}
+
+// 写入归档。图片先暂存在内存或临时文件中，下载完成后才写入分片，下载期间不持有归档的锁，
+// 多张图片可以并发下载，慢速的下载不会拖住其他图片；文件名也可以用到 md5、宽高等下载完成后才能确定的值
+func (d *downloader) saveToArchive(ctx context.Context, a *Archive, url, dir string, source Result, index int, name *nameTemplate) DownloadReport {
+	report := DownloadReport{URL: url}
+	report.setSource(source)
+	spool := a.newSpool()
+	defer spool.close()
+	var suffix string
+	err := d.get(ctx, url, func(ty string, _ int64) (io.Writer, error) {
+		suffix = ty
+		return spool, nil
+	}, func(info ImageInfo) {
+		report.ImageInfo = info
+	})
+	report.FetchedAt = time.Now()
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	key, err := name.expand(&report, index, suffix)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	report.Path = archiveName(dir, key)
+	r, err := spool.reader()
+	if err == nil {
+		record := report.manifestRecord()
+		report.Archive, err = a.AddReader(report.Path, spool.size, r, &record)
+	}
+	if err != nil {
+		report.Path, report.Err = "", err
+	}
+	return report
 }
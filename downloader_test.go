@@ -2,6 +2,7 @@ package imagecapture
 
 import (
 	"bytes"
+	"context"
 	"hash"
 	"io"
 	"net/http"
@@ -116,9 +117,9 @@ func Test_downloader_get(t *testing.T) {
 		md5        hash.Hash
 	}
 	type args struct {
-		url        string
-		newWriter  func(string) (io.Writer, error)
-		mdCallback func(string)
+		url       string
+		newWriter func(string, int64) (io.Writer, error)
+		onDone    func(ImageInfo)
 	}
 	tests := []struct {
 		name    string
@@ -137,7 +138,7 @@ func Test_downloader_get(t *testing.T) {
 				bufferSize: tt.fields.bufferSize,
 				md5:        tt.fields.md5,
 			}
-			if err := d.get(tt.args.url, tt.args.newWriter, tt.args.mdCallback); (err != nil) != tt.wantErr {
+			if err := d.get(context.Background(), tt.args.url, tt.args.newWriter, tt.args.onDone); (err != nil) != tt.wantErr {
 				t.Errorf("get() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -153,10 +154,11 @@ func Test_downloader_saveFile(t *testing.T) {
 		md5        hash.Hash
 	}
 	type args struct {
-		url          string
-		dir          string
-		useMd5Naming bool
-		collector    chan<- string
+		url    string
+		dir    string
+		source Result
+		index  int
+		name   *nameTemplate
 	}
 	tests := []struct {
 		name   string
@@ -174,7 +176,7 @@ func Test_downloader_saveFile(t *testing.T) {
 				bufferSize: tt.fields.bufferSize,
 				md5:        tt.fields.md5,
 			}
-			d.saveFile(tt.args.url, tt.args.dir, tt.args.useMd5Naming, tt.args.collector)
+			d.saveFile(context.Background(), tt.args.url, tt.args.dir, tt.args.source, tt.args.index, tt.args.name)
 		})
 	}
 }
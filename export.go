@@ -0,0 +1,427 @@
+package imagecapture
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/21 上午10:30
+* @Package:
+ */
+
+// 数据集划分名称
+const (
+	SplitTrain = "train"
+	SplitVal   = "val"
+	SplitTest  = "test"
+)
+
+// 关键词为空的记录使用的类别名
+const unknownClass = "unknown"
+
+// Split 训练集、验证集、测试集的比例，按比例之和归一化，比例为 0 的划分不会生成
+type Split struct {
+	Train float64
+	Val   float64
+	Test  float64
+}
+
+// DefaultSplit 8:1:1 划分
+var DefaultSplit = Split{Train: 0.8, Val: 0.1, Test: 0.1}
+
+// ExportOption 数据集导出选项
+type ExportOption func(*exportConfig)
+
+type exportConfig struct {
+	hardLink  bool
+	shardSize int
+}
+
+func newExportConfig(opts []ExportOption) exportConfig {
+	cfg := exportConfig{shardSize: 1000}
+	for _, option := range opts {
+		option(&cfg)
+	}
+	return cfg
+}
+
+// WithHardLink 导出时优先创建硬链接，失败（例如跨文件系统）时再复制文件
+func WithHardLink() ExportOption {
+	return func(cfg *exportConfig) {
+		cfg.hardLink = true
+	}
+}
+
+// WithShardSize WebDataset 每个分片包含的样本数，默认 1000
+func WithShardSize(n int) ExportOption {
+	return func(cfg *exportConfig) {
+		if n > 0 {
+			cfg.shardSize = n
+		}
+	}
+}
+
+// 清单中的一张图片，path 为解析后的实际文件路径，name 为导出后在类别目录中的文件名
+type exportSample struct {
+	ManifestRecord
+	class string
+	path  string
+	name  string
+}
+
+// 读取清单，解析文件路径，按类别（关键词）分组并按 sha256 去重
+func loadExportSamples(manifestFile string) (map[string][]exportSample, []string, error) {
+	records, err := ReadManifest(manifestFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := filepath.Dir(manifestFile)
+	classes := make(map[string][]exportSample)
+	seen := make(map[string]struct{}, len(records))
+	for _, r := range records {
+		if r.Archive != "" {
+			return nil, nil, fmt.Errorf("%s: exporting archived images is not supported", r.File)
+		}
+		class := classOf(r.Keyword)
+		key := class + "/" + r.SHA256
+		if r.SHA256 == "" {
+			key = class + "/" + r.File
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		p := filepath.FromSlash(r.File)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		classes[class] = append(classes[class], exportSample{ManifestRecord: r, class: class, path: p})
+	}
+	names := make([]string, 0, len(classes))
+	for name, samples := range classes {
+		// 按内容摘要排序，同一张图片每次导出都落在同一个划分中
+		sort.Slice(samples, func(i, j int) bool {
+			if samples[i].SHA256 != samples[j].SHA256 {
+				return samples[i].SHA256 < samples[j].SHA256
+			}
+			return samples[i].File < samples[j].File
+		})
+		assignExportNames(samples)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return classes, names, nil
+}
+
+// 为同一类别的样本分配不重复的文件名：优先使用原文件名，同名时改用清单中的相对路径（/ 替换为 _），
+// 仍然重复时追加序号。样本已按摘要排序，同一份清单每次分配的文件名相同
+func assignExportNames(samples []exportSample) {
+	bases := make(map[string]int, len(samples))
+	for _, sample := range samples {
+		bases[filepath.Base(sample.path)]++
+	}
+	used := make(map[string]struct{}, len(samples))
+	for i := range samples {
+		name := filepath.Base(samples[i].path)
+		if bases[name] > 1 {
+			name = strings.NewReplacer("/", "_", ":", "_").Replace(strings.TrimLeft(filepath.ToSlash(samples[i].File), "/"))
+		}
+		unique, ext := name, path.Ext(name)
+		for n := 1; ; n++ {
+			if _, ok := used[unique]; !ok {
+				break
+			}
+			unique = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		used[unique] = struct{}{}
+		samples[i].name = unique
+	}
+}
+
+// 关键词作为类别目录名，去掉路径分隔符
+func classOf(keyword string) string {
+	class := strings.TrimSpace(strings.NewReplacer("/", "_", "\\", "_").Replace(keyword))
+	if class == "" || class == "." || class == ".." {
+		return unknownClass
+	}
+	return class
+}
+
+// 按比例把同一类别的样本切分为 train/val/test
+func (s Split) apply(samples []exportSample) map[string][]exportSample {
+	total := s.Train + s.Val + s.Test
+	if total <= 0 {
+		return map[string][]exportSample{SplitTrain: samples}
+	}
+	n := float64(len(samples))
+	train := int(n*s.Train/total + 0.5)
+	val := int(n*(s.Train+s.Val)/total+0.5) - train
+	if s.Test <= 0 {
+		val = len(samples) - train
+	}
+	splits := make(map[string][]exportSample, 3)
+	if s.Train > 0 {
+		splits[SplitTrain] = samples[:train]
+	}
+	if s.Val > 0 {
+		splits[SplitVal] = samples[train : train+val]
+	}
+	if s.Test > 0 {
+		splits[SplitTest] = samples[train+val:]
+	}
+	return splits
+}
+
+// ExportImageFolder 按清单导出 ImageFolder 目录结构：<dst>/<split>/<关键词>/<文件>，
+// 可直接用于 torchvision.datasets.ImageFolder。返回每个划分导出的图片数量
+func ExportImageFolder(manifestFile, dst string, split Split, opts ...ExportOption) (map[string]int, error) {
+	_, counts, err := exportFolders(manifestFile, dst, split, newExportConfig(opts))
+	return counts, err
+}
+
+// ExportHuggingFace 在 ImageFolder 目录结构的基础上为每个划分生成 metadata.jsonl，
+// 可通过 datasets.load_dataset("imagefolder", data_dir=dst) 加载，清单中的来源信息作为额外的列
+func ExportHuggingFace(manifestFile, dst string, split Split, opts ...ExportOption) (map[string]int, error) {
+	exported, counts, err := exportFolders(manifestFile, dst, split, newExportConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	for name, samples := range exported {
+		file, err := os.Create(filepath.Join(dst, name, "metadata.jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(file)
+		for _, sample := range samples {
+			if err = encoder.Encode(huggingFaceRow(sample)); err != nil {
+				break
+			}
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// metadata.jsonl 中的一行，file_name 为相对 metadata.jsonl 的路径
+func huggingFaceRow(sample exportSample) map[string]interface{} {
+	return map[string]interface{}{
+		"file_name": path.Join(sample.class, sample.name),
+		"label":     sample.class,
+		"url":       sample.URL,
+		"engine":    sample.Engine,
+		"keyword":   sample.Keyword,
+		"title":     sample.Title,
+		"source":    sample.Source,
+		"md5":       sample.MD5,
+		"sha256":    sample.SHA256,
+		"width":     sample.Width,
+		"height":    sample.Height,
+	}
+}
+
+// 导出目录结构，返回每个划分实际导出的样本
+func exportFolders(manifestFile, dst string, split Split, cfg exportConfig) (map[string][]exportSample, map[string]int, error) {
+	classes, names, err := loadExportSamples(manifestFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	exported := make(map[string][]exportSample)
+	counts := make(map[string]int)
+	for _, class := range names {
+		for name, samples := range split.apply(classes[class]) {
+			if len(samples) == 0 {
+				continue
+			}
+			dir := filepath.Join(dst, name, class)
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				return nil, nil, fmt.Errorf("failed to create directories: %w", err)
+			}
+			for _, sample := range samples {
+				if err = exportFile(sample.path, filepath.Join(dir, sample.name), cfg.hardLink); err != nil {
+					return nil, nil, err
+				}
+			}
+			exported[name] = append(exported[name], samples...)
+			counts[name] += len(samples)
+		}
+	}
+	return exported, counts, nil
+}
+
+// 复制或硬链接文件。目标已存在且内容相同时跳过，重复导出不会出错；内容不同时返回错误，不会覆盖
+func exportFile(src, dst string, hardLink bool) error {
+	if _, err := os.Stat(dst); err == nil {
+		same, err := sameContent(src, dst)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return fmt.Errorf("%w: %s", ErrFileAlreadyExists, dst)
+		}
+		return nil
+	}
+	if hardLink && os.Link(src, dst) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// 两个文件的内容是否相同，硬链接到同一文件时不再比较内容
+func sameContent(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(infoA, infoB) {
+		return true, nil
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	dataB, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(dataA, dataB), nil
+}
+
+// ExportWebDataset 按清单导出 WebDataset 格式的 tar 分片：<dst>/shard-000000.tar ...，
+// 每个样本包含 <key>.<后缀>（图片）、<key>.cls（类别序号）、<key>.txt（关键词）和 <key>.json（来源信息）。
+// 类别序号按关键词排序分配，样本按类别交错排列。返回生成的分片路径
+func ExportWebDataset(manifestFile, dst string, opts ...ExportOption) ([]string, error) {
+	cfg := newExportConfig(opts)
+	classes, names, err := loadExportSamples(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dst, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %w", err)
+	}
+	var shards []string
+	var (
+		file *os.File
+		tw   *tar.Writer
+	)
+	closeShard := func() error {
+		if tw == nil {
+			return nil
+		}
+		err := tw.Close()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		tw = nil
+		return err
+	}
+	index := 0
+	for i := 0; ; i++ {
+		more := false
+		for label, class := range names {
+			if i >= len(classes[class]) {
+				continue
+			}
+			more = true
+			if index%cfg.shardSize == 0 {
+				if err = closeShard(); err != nil {
+					return nil, err
+				}
+				name := filepath.Join(dst, fmt.Sprintf("shard-%06d.tar", len(shards)))
+				if file, err = os.Create(name); err != nil {
+					return nil, err
+				}
+				tw = tar.NewWriter(file)
+				shards = append(shards, name)
+			}
+			if err = writeWebDatasetSample(tw, fmt.Sprintf("%08d", index), label, classes[class][i]); err != nil {
+				closeShard()
+				return nil, err
+			}
+			index++
+		}
+		if !more {
+			break
+		}
+	}
+	return shards, closeShard()
+}
+
+func writeWebDatasetSample(tw *tar.Writer, key string, label int, sample exportSample) error {
+	image, err := os.ReadFile(sample.path)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(sample.ManifestRecord)
+	if err != nil {
+		return err
+	}
+	ext := strings.TrimPrefix(filepath.Ext(sample.path), ".")
+	if ext == "" {
+		ext = "img"
+	}
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{key + "." + ext, image},
+		{key + ".cls", []byte(fmt.Sprint(label))},
+		{key + ".txt", []byte(sample.Keyword)},
+		{key + ".json", meta},
+	}
+	modTime := sample.FetchedAt
+	if modTime.IsZero() {
+		modTime = time.Unix(0, 0)
+	}
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    0644,
+			Size:    int64(len(entry.data)),
+			ModTime: modTime,
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err = tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package imagecapture
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/21 下午3:00
+* @Package:
+ */
+
+// 生成包含两个关键词的清单：老虎 10 张，狮子 5 张，另有一条重复记录
+func newTestManifest(t *testing.T) string {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "manifest.jsonl")
+	manifest, err := NewManifest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(keyword string, i int) {
+		path := filepath.Join(dir, "images", fmt.Sprintf("%s-%02d.png", keyword, i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, testPNG, 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Write(DownloadReport{
+			URL:       "https://example.com/" + filepath.Base(path),
+			Path:      path,
+			Keyword:   keyword,
+			Engine:    EngineBing,
+			ImageInfo: ImageInfo{SHA256: fmt.Sprintf("%s-%02d", keyword, i), Width: 1, Height: 1},
+		})
+	}
+	for i := 0; i < 10; i++ {
+		write("老虎", i)
+	}
+	for i := 0; i < 5; i++ {
+		write("狮子", i)
+	}
+	write("狮子", 0)
+	if err = manifest.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestExportImageFolder(t *testing.T) {
+	manifest := newTestManifest(t)
+	dst := t.TempDir()
+	counts, err := ExportImageFolder(manifest, dst, DefaultSplit, WithHardLink())
+	if err != nil {
+		t.Fatalf("ExportImageFolder() error = %v", err)
+	}
+	want := map[string]int{SplitTrain: 12, SplitVal: 2, SplitTest: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("ExportImageFolder() counts = %v, want %v", counts, want)
+	}
+	for _, path := range []string{"train/老虎/老虎-00.png", "val/老虎/老虎-08.png", "test/老虎/老虎-09.png", "train/狮子/狮子-03.png"} {
+		if _, err = os.Stat(filepath.Join(dst, path)); err != nil {
+			t.Errorf("ExportImageFolder() missing %s", path)
+		}
+	}
+	// 重复导出结果不变
+	if again, err := ExportImageFolder(manifest, dst, DefaultSplit); err != nil || !reflect.DeepEqual(again, want) {
+		t.Errorf("ExportImageFolder() again = %v, %v", again, err)
+	}
+}
+
+func TestExportHuggingFace(t *testing.T) {
+	manifest := newTestManifest(t)
+	dst := t.TempDir()
+	counts, err := ExportHuggingFace(manifest, dst, Split{Train: 0.8, Test: 0.2})
+	if err != nil {
+		t.Fatalf("ExportHuggingFace() error = %v", err)
+	}
+	if want := map[string]int{SplitTrain: 12, SplitTest: 3}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("ExportHuggingFace() counts = %v, want %v", counts, want)
+	}
+	file, err := os.Open(filepath.Join(dst, SplitTest, "metadata.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	rows := 0
+	for ; scanner.Scan(); rows++ {
+		var row map[string]interface{}
+		if err = json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(filepath.Join(dst, SplitTest, row["file_name"].(string))); err != nil {
+			t.Errorf("metadata file_name %v: %v", row["file_name"], err)
+		}
+		if row["label"] != row["keyword"] || row["engine"] != EngineBing {
+			t.Errorf("metadata row = %v", row)
+		}
+	}
+	if rows != 3 {
+		t.Errorf("metadata rows = %d, want 3", rows)
+	}
+}
+
+func TestExportWebDataset(t *testing.T) {
+	manifest := newTestManifest(t)
+	dst := t.TempDir()
+	shards, err := ExportWebDataset(manifest, dst, WithShardSize(4))
+	if err != nil {
+		t.Fatalf("ExportWebDataset() error = %v", err)
+	}
+	if len(shards) != 4 {
+		t.Fatalf("ExportWebDataset() shards = %v", shards)
+	}
+	file, err := os.Open(shards[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tr := tar.NewReader(file)
+	var names []string
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		names = append(names, header.Name)
+		files[header.Name] = string(data)
+	}
+	if len(names) != 16 || names[0] != "00000000.png" {
+		t.Fatalf("shard entries = %v", names)
+	}
+	// 类别按关键词排序：狮子 0，老虎 1，样本交错排列
+	if files["00000000.cls"] != "0" || files["00000000.txt"] != "狮子" || files["00000001.cls"] != "1" {
+		t.Errorf("shard labels = %v", files)
+	}
+	if files["00000000.png"] != string(testPNG) {
+		t.Errorf("shard image mismatch")
+	}
+}
+
+// 同一类别中文件名相同的两张图片（例如 {engine}/{index:6}.{ext} 命名）导出为不同的文件
+func TestExportHuggingFace_SameBaseName(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "manifest.jsonl")
+	manifest, err := NewManifest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{EngineBing: "bing image", EngineBaidu: "baidu image"}
+	for engine, content := range contents {
+		path := filepath.Join(dir, engine, "000001.png")
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Write(DownloadReport{Path: path, Keyword: "老虎", Engine: engine, ImageInfo: ImageInfo{SHA256: engine}})
+	}
+	if err = manifest.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	for i := 0; i < 2; i++ { // 重复导出结果不变
+		counts, err := ExportHuggingFace(filename, dst, Split{Train: 1})
+		if err != nil || counts[SplitTrain] != 2 {
+			t.Fatalf("ExportHuggingFace() = %v, %v", counts, err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dst, SplitTrain, "metadata.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNames := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var row map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatal(err)
+		}
+		name := row["file_name"].(string)
+		fileNames[name] = true
+		got, err := os.ReadFile(filepath.Join(dst, SplitTrain, filepath.FromSlash(name)))
+		if err != nil || string(got) != contents[row["engine"].(string)] {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, contents[row["engine"].(string)])
+		}
+	}
+	if len(fileNames) != 2 {
+		t.Errorf("metadata file names = %v", fileNames)
+	}
+
+	// 目标已存在且内容不同时报错，不会跳过
+	for name := range fileNames {
+		if err = os.WriteFile(filepath.Join(dst, SplitTrain, filepath.FromSlash(name)), []byte("other"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = ExportImageFolder(filename, dst, Split{Train: 1}); !errors.Is(err, ErrFileAlreadyExists) {
+		t.Errorf("ExportImageFolder() error = %v, want %v", err, ErrFileAlreadyExists)
+	}
+}
@@ -0,0 +1,144 @@
+package imagecapture
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/26 上午10:00
+* @Package:
+ */
+
+// 文件名模板支持的占位符
+var nameFields = map[string]bool{
+	"keyword": true, // 搜索关键词，为空时为 unknown
+	"engine":  true, // 搜索引擎，为空时为 unknown
+	"index":   true, // 图片在本次下载中的序号，从 0 开始，{index:6} 补零到 6 位
+	"md5":     true,
+	"sha256":  true,
+	"uuid":    true, // 随机 uuid
+	"host":    true, // 图片地址的域名
+	"date":    true, // 下载日期 20060102
+	"width":   true, // 图片宽度，未知时为 0
+	"height":  true,
+	"ext":     true, // 图片后缀，例如 png，模板中没有时自动追加
+}
+
+// 默认模板，与 useMd5Naming 对应
+const (
+	uuidNameTemplate = "{uuid}.{ext}"
+	md5NameTemplate  = "{md5}.{ext}"
+)
+
+// 解析后的文件名模板
+type nameTemplate struct {
+	parts []namePart
+}
+
+// 模板中的一段，field 为空时是普通文本
+type namePart struct {
+	text  string
+	field string
+	width int // {index:6} 的补零宽度
+}
+
+// 解析文件名模板，例如 {engine}/{keyword}/{date}/{index:6}-{width}x{height}.{ext}
+func parseNameTemplate(s string) (*nameTemplate, error) {
+	t := &nameTemplate{}
+	hasExt := false
+	for s != "" {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			t.parts = append(t.parts, namePart{text: s})
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid filename template: unclosed %q", s[start:])
+		}
+		if start > 0 {
+			t.parts = append(t.parts, namePart{text: s[:start]})
+		}
+		part := namePart{field: s[start+1 : start+end]}
+		if name, width, ok := strings.Cut(part.field, ":"); ok {
+			n, err := strconv.Atoi(width)
+			if name != "index" || err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid filename template field: {%s}", part.field)
+			}
+			part.field, part.width = name, n
+		}
+		if !nameFields[part.field] {
+			return nil, fmt.Errorf("unknown filename template field: {%s}", part.field)
+		}
+		hasExt = hasExt || part.field == "ext"
+		t.parts = append(t.parts, part)
+		s = s[start+end+1:]
+	}
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("empty filename template")
+	}
+	if !hasExt {
+		t.parts = append(t.parts, namePart{text: "."}, namePart{field: "ext"})
+	}
+	return t, nil
+}
+
+// 生成相对下载目录的路径。占位符的值中的路径分隔符会被替换，
+// 模板中的 / 用于生成子目录，结果不会超出下载目录
+func (t *nameTemplate) expand(r *DownloadReport, index int, ext string) (string, error) {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.field == "" {
+			b.WriteString(part.text)
+			continue
+		}
+		var value string
+		switch part.field {
+		case "keyword":
+			value = classOf(r.Keyword)
+		case "engine":
+			value = classOf(r.Engine)
+		case "index":
+			value = fmt.Sprintf("%0*d", part.width, index)
+		case "md5":
+			value = r.MD5
+		case "sha256":
+			value = r.SHA256
+		case "uuid":
+			uuid, err := GenerateUUID()
+			if err != nil {
+				return "", err
+			}
+			value = uuid
+		case "host":
+			if u, err := url.Parse(r.URL); err == nil {
+				value = u.Hostname()
+			}
+		case "date":
+			value = r.FetchedAt.Format("20060102")
+		case "width":
+			value = strconv.Itoa(r.Width)
+		case "height":
+			value = strconv.Itoa(r.Height)
+		case "ext":
+			value = ext
+		}
+		b.WriteString(nameReplacer.Replace(value))
+	}
+	name := strings.TrimPrefix(path.Clean("/"+b.String()), "/")
+	if name == "" {
+		return "", fmt.Errorf("filename template produced an empty name")
+	}
+	return name, nil
+}
+
+// 替换文件名中不允许出现的字符
+var nameReplacer = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_",
+)
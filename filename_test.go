@@ -0,0 +1,94 @@
+package imagecapture
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_parseNameTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{"{md5}.{ext}", false},
+		{"{engine}/{keyword}/{index:6}-{width}x{height}", false},
+		{"", true},
+		{"{md5", true},
+		{"{name}.{ext}", true},
+		{"{md5:4}", true},
+		{"{index:x}", true},
+	}
+	for _, tt := range tests {
+		if _, err := parseNameTemplate(tt.template); (err != nil) != tt.wantErr {
+			t.Errorf("parseNameTemplate(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_nameTemplate_expand(t *testing.T) {
+	report := &DownloadReport{
+		URL:       "https://img.example.com:8080/a/b.jpg",
+		ImageInfo: ImageInfo{MD5: "d41d8cd9", SHA256: "e3b0c442", Width: 640, Height: 480},
+		FetchedAt: time.Date(2024, 11, 26, 10, 0, 0, 0, time.Local),
+		Engine:    EngineBing,
+		Keyword:   "猫/狗",
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{md5}.{ext}", "d41d8cd9.jpeg"},
+		{"{engine}/{keyword}/{index:6}-{width}x{height}", "bing/猫_狗/000042-640x480.jpeg"},
+		{"{host}/{date}/{sha256}.{ext}", "img.example.com/20241126/e3b0c442.jpeg"},
+		{"{index}", "42.jpeg"},
+		{"../../{md5}", "d41d8cd9.jpeg"},
+		{"/abs/{md5}", "abs/d41d8cd9.jpeg"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseNameTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tmpl.expand(report, 42, "jpeg"); err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.template, got, err, tt.want)
+		}
+	}
+	tmpl, _ := parseNameTemplate("{keyword}/{uuid}")
+	if got, _ := tmpl.expand(&DownloadReport{}, 0, "png"); filepath.Dir(got) != unknownClass || len(filepath.Base(got)) != 36+4 {
+		t.Errorf("expand() = %q", got)
+	}
+}
+
+func TestWithFilenameTemplate(t *testing.T) {
+	server, _ := newBingServer(t)
+	dir := t.TempDir()
+	d := NewBingCapture(2).(*BingCapture)
+	results := []Result{
+		{URL: server.URL + "/full.jpg", Engine: EngineBing, Keyword: "tiger"},
+		{URL: server.URL + "/thumb.jpg", Engine: EngineBing, Keyword: "tiger"},
+	}
+	paths, err := d.DownloadResults(results, dir, false, WithFilenameTemplate("{engine}/{keyword}/{index:3}-{width}x{height}"), WithIndexOffset(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	want := []string{
+		filepath.Join(dir, "bing/tiger/010-1x1.png"),
+		filepath.Join(dir, "bing/tiger/011-1x1.png"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err = d.BatchDownload(nil, dir, false, WithFilenameTemplate("{unknown}")); err == nil {
+		t.Error("BatchDownload() with invalid template should fail")
+	}
+}
@@ -0,0 +1,95 @@
+package imagecapture
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/13 上午11:05
+* @Package:
+ */
+
+// ResultFilter 结果过滤器，返回 false 的结果会被丢弃
+type ResultFilter interface {
+	Accept(r Result) bool
+}
+
+// FilterFunc 将普通函数适配为 ResultFilter
+type FilterFunc func(r Result) bool
+
+func (f FilterFunc) Accept(r Result) bool {
+	return f(r)
+}
+
+// ExtensionFilter 只保留指定后缀的图片，例如 ExtensionFilter("jpg", "png")
+// 地址中没有后缀时使用引擎返回的图片格式判断
+func ExtensionFilter(exts ...string) ResultFilter {
+	allowed := make(map[string]struct{}, len(exts))
+	for _, ext := range exts {
+		allowed[strings.ToLower(strings.TrimPrefix(ext, "."))] = struct{}{}
+	}
+	return FilterFunc(func(r Result) bool {
+		ext := r.Format
+		if u, err := url.Parse(r.URL); err == nil {
+			if e := path.Ext(u.Path); e != "" {
+				ext = e
+			}
+		}
+		_, ok := allowed[strings.ToLower(strings.TrimPrefix(ext, "."))]
+		return ok
+	})
+}
+
+// DomainFilter 只保留指定域名（后缀匹配）下的图片
+func DomainFilter(domains ...string) ResultFilter {
+	rule := make(Rule, 0, len(domains))
+	for _, domain := range domains {
+		rule = append(rule, strings.ToLower(domain))
+	}
+	return FilterFunc(func(r Result) bool {
+		return rule.Check(r.URL)
+	})
+}
+
+// DimensionFilter 按引擎返回的尺寸过滤小图，引擎未提供尺寸的结果会保留
+func DimensionFilter(minWidth, minHeight int) ResultFilter {
+	return FilterFunc(func(r Result) bool {
+		if r.Width == 0 || r.Height == 0 {
+			return true
+		}
+		return r.Width >= minWidth && r.Height >= minHeight
+	})
+}
+
+// AspectRatioFilter 按宽高比（宽/高）过滤，max 为 0 表示不限制上限，引擎未提供尺寸的结果会保留
+func AspectRatioFilter(min, max float64) ResultFilter {
+	return FilterFunc(func(r Result) bool {
+		if r.Width == 0 || r.Height == 0 {
+			return true
+		}
+		ratio := float64(r.Width) / float64(r.Height)
+		if ratio < min {
+			return false
+		}
+		return max == 0 || ratio <= max
+	})
+}
+
+// RegexFilter 只保留地址匹配正则的图片
+func RegexFilter(re *regexp.Regexp) ResultFilter {
+	return FilterFunc(func(r Result) bool {
+		return re.MatchString(r.URL)
+	})
+}
+
+// TitleFilter 只保留标题匹配正则的图片，例如按关键词过滤
+func TitleFilter(re *regexp.Regexp) ResultFilter {
+	return FilterFunc(func(r Result) bool {
+		return re.MatchString(r.Title)
+	})
+}
@@ -0,0 +1,90 @@
+package imagecapture
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/13 下午3:40
+* @Package:
+ */
+
+func TestResultFilter_Accept(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ResultFilter
+		result Result
+		want   bool
+	}{
+		{"extension hit", ExtensionFilter(".JPG", "png"), Result{URL: "https://a.com/x.jpg?w=1"}, true},
+		{"extension miss", ExtensionFilter("png"), Result{URL: "https://a.com/x.gif"}, false},
+		{"extension from format", ExtensionFilter("png"), Result{URL: "https://a.com/x", Format: "png"}, true},
+		{"domain hit", DomainFilter("example.com"), Result{URL: "https://img.example.com/x.jpg"}, true},
+		{"domain miss", DomainFilter("example.com"), Result{URL: "https://img.other.com/x.jpg"}, false},
+		{"dimension too small", DimensionFilter(800, 600), Result{Width: 640, Height: 480}, false},
+		{"dimension ok", DimensionFilter(800, 600), Result{Width: 1024, Height: 768}, true},
+		{"dimension unknown", DimensionFilter(800, 600), Result{}, true},
+		{"aspect ratio wide", AspectRatioFilter(1.5, 0), Result{Width: 1920, Height: 1080}, true},
+		{"aspect ratio tall", AspectRatioFilter(0.9, 1.1), Result{Width: 600, Height: 1000}, false},
+		{"regex url", RegexFilter(regexp.MustCompile(`/large/`)), Result{URL: "https://a.com/large/x.jpg"}, true},
+		{"regex title", TitleFilter(regexp.MustCompile(`老虎`)), Result{Title: "东北虎 老虎图片"}, true},
+		{"regex title miss", TitleFilter(regexp.MustCompile(`老虎`)), Result{Title: "狮子"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Accept(tt.result); got != tt.want {
+				t.Errorf("Accept() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBaiduResults(t *testing.T) {
+	page := `{"data":[{"thumbURL":"https://img0.baidu.com/t1.jpg","middleURL":"https://img0.baidu.com/m1.jpg",` +
+		`"objURL":"https://example.com/1.jpg","fromPageTitleEnc":"老虎","type":"jpg","width":1024,"height":768,"face_info":{}},` +
+		`{"thumbURL":"https://img0.baidu.com/t2.jpg","middleURL":"","width":10,"height":10},` +
+		`{"thumbURL":"https://img0.baidu.com/t3.jpg","objURL":"https://example.com/3.png","type":"png","width":300,"height":200}]}`
+	got := parseBaiduResults(page, "老虎")
+	if len(got) != 2 {
+		t.Fatalf("parseBaiduResults() got %d results, want 2", len(got))
+	}
+	want := Result{
+		URL:       "https://example.com/1.jpg",
+		Original:  "https://example.com/1.jpg",
+		Middle:    "https://img0.baidu.com/m1.jpg",
+		Thumbnail: "https://img0.baidu.com/t1.jpg",
+		Title:     "老虎",
+		Format:    "jpg",
+		Width:     1024,
+		Height:    768,
+		Engine:    EngineBaidu,
+		Keyword:   "老虎",
+	}
+	if got[0] != want {
+		t.Errorf("parseBaiduResults() got = %+v, want %+v", got[0], want)
+	}
+	if got[1].URL != "https://example.com/3.png" || got[1].Width != 300 {
+		t.Errorf("parseBaiduResults() got = %+v", got[1])
+	}
+}
+
+func Test_collectResults(t *testing.T) {
+	q := newQuery()
+	WithFilters(DimensionFilter(100, 100))(&q)
+	collector := make(chan Result, 5)
+	collector <- Result{URL: "https://a.com/1.jpg", Width: 50, Height: 50}
+	collector <- Result{URL: "https://a.com/2.jpg"}
+	collector <- Result{URL: "https://a.com/2.jpg"}
+	collector <- Result{URL: "https://p3.douyinpic.com/3.jpg"}
+	collector <- Result{URL: "https://a.com/4.jpg"}
+	close(collector)
+	// 被过滤和重复的结果不计入数量
+	got := collectResults(context.Background(), collector, &q, 2)
+	if len(got) != 2 || got[0].URL != "https://a.com/2.jpg" || got[1].URL != "https://a.com/4.jpg" {
+		t.Errorf("collectResults() got = %+v", got)
+	}
+}
@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/panjf2000/ants/v2 v2.10.0
 	golang.org/x/net v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sync v0.8.0 // indirect
@@ -3,8 +3,13 @@ package imagecapture
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"hash"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"net/http"
 	"strings"
@@ -21,7 +26,7 @@ const base = "image"
 
 func checkImageType(data []byte) string {
 	// 获取图片类型
-	ty := http.DetectContentType(data[:sniffLen])
+	ty := http.DetectContentType(data)
 	arrs := strings.Split(ty, "/")
 	if len(arrs) < 2 || arrs[0] != base {
 		return ""
@@ -37,8 +42,9 @@ type ImageReader struct {
 
 func NewImageReader(reader io.Reader, needMd5 bool) (*ImageReader, error) {
 	buf := make([]byte, sniffLen)
-	n, err := reader.Read(buf)
-	if err != nil {
+	// 单次 Read 可能读不满，图片小于 sniffLen 时读到 EOF 也是正常的
+	n, err := io.ReadFull(reader, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
 	// 检测图片类型
@@ -71,3 +77,55 @@ func (ir ImageReader) Md5() string {
 func (ir ImageReader) Read(p []byte) (n int, err error) {
 	return ir.Reader.Read(p)
 }
+
+// 解析图片尺寸时最多缓存的文件头长度
+const headerLen = 64 * 1024
+
+// ImageInfo 下载过程中统计的图片元数据
+type ImageInfo struct {
+	ContentType string // 例如 image/png
+	Size        int64  // 字节数
+	MD5         string
+	SHA256      string
+	Width       int // 无法解析时为 0
+	Height      int
+}
+
+// 写入图片数据时统计大小、摘要，并缓存文件头用于解析尺寸
+type imageMeta struct {
+	size   int64
+	md5    hash.Hash
+	sha256 hash.Hash
+	header []byte
+}
+
+func newImageMeta() *imageMeta {
+	return &imageMeta{md5: md5.New(), sha256: sha256.New()}
+}
+
+func (m *imageMeta) Write(p []byte) (int, error) {
+	m.size += int64(len(p))
+	m.md5.Write(p)
+	m.sha256.Write(p)
+	if rest := headerLen - len(m.header); rest > 0 {
+		if rest > len(p) {
+			rest = len(p)
+		}
+		m.header = append(m.header, p[:rest]...)
+	}
+	return len(p), nil
+}
+
+func (m *imageMeta) info(ty string) ImageInfo {
+	info := ImageInfo{
+		ContentType: base + "/" + ty,
+		Size:        m.size,
+		MD5:         hex.EncodeToString(m.md5.Sum(nil)),
+		SHA256:      hex.EncodeToString(m.sha256.Sum(nil)),
+	}
+	// 只注册了标准库支持的 png、jpeg、gif 解码器，其他格式尺寸为 0
+	if config, _, err := image.DecodeConfig(bytes.NewReader(m.header)); err == nil {
+		info.Width, info.Height = config.Width, config.Height
+	}
+	return info
+}
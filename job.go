@@ -0,0 +1,472 @@
+package imagecapture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/18 上午10:15
+* @Package:
+ */
+
+// 输出目录布局
+const (
+	JobLayoutFlat    = "flat"    // 所有图片保存在输出目录下
+	JobLayoutKeyword = "keyword" // 按关键词分目录：<dir>/<keyword>/
+	JobLayoutEngine  = "engine"  // 按引擎和关键词分目录：<dir>/<engine>/<keyword>/
+)
+
+// 任务汇总文件名，保存在输出目录下
+const jobSummaryFile = "summary.json"
+
+// Job 批量抓取任务，可通过 LoadJob 从 yaml/json 文件加载
+//
+//	name: tigers
+//	engines: [baidu, bing]
+//	count: 100
+//	keywords:
+//	  - 东北虎
+//	  - keyword: 华南虎
+//	    count: 20
+//	keywords_file: ./keywords.txt
+//	filters:
+//	  size: large
+//	  extensions: [jpg, png]
+//	output:
+//	  dir: ./dataset
+//	  layout: keyword
+//	  md5_naming: true
+//	  filename: "{date}/{index:6}-{md5}.{ext}"
+//	  manifest: jsonl
+//	  archive: tar.gz
+//	  overwrite: skip
+//	limits:
+//	  concurrency: 2
+//	  interval: 500ms
+type Job struct {
+	Name         string       `json:"name" yaml:"name"`
+	Engines      []string     `json:"engines" yaml:"engines"`             // 默认使用百度
+	Keywords     []JobKeyword `json:"keywords" yaml:"keywords"`           // 关键词列表
+	KeywordsFile string       `json:"keywords_file" yaml:"keywords_file"` // 关键词文件，每行一个
+	Count        int          `json:"count" yaml:"count"`                 // 每个关键词默认抓取数量
+	Filters      SearchSpec   `json:"filters" yaml:"filters"`
+	Output       JobOutput    `json:"output" yaml:"output"`
+	Limits       JobLimits    `json:"limits" yaml:"limits"`
+}
+
+// JobKeyword 关键词，可以单独指定抓取数量
+type JobKeyword struct {
+	Keyword string `json:"keyword" yaml:"keyword"`
+	Count   int    `json:"count,omitempty" yaml:"count"`
+}
+
+// UnmarshalJSON 支持直接写关键词字符串
+func (k *JobKeyword) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &k.Keyword); err == nil {
+		return nil
+	}
+	type keyword JobKeyword
+	return json.Unmarshal(data, (*keyword)(k))
+}
+
+// UnmarshalYAML 支持直接写关键词字符串
+func (k *JobKeyword) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&k.Keyword)
+	}
+	type keyword JobKeyword
+	return node.Decode((*keyword)(k))
+}
+
+// JobOutput 输出配置
+type JobOutput struct {
+	Dir       string `json:"dir" yaml:"dir"`
+	Layout    string `json:"layout" yaml:"layout"` // flat keyword engine，默认 keyword
+	Md5Naming bool   `json:"md5_naming" yaml:"md5_naming"`
+	Filename  string `json:"filename" yaml:"filename"`   // 文件名模板，例如 {date}/{index:6}-{md5}.{ext}，设置后忽略 md5_naming
+	Manifest  string `json:"manifest" yaml:"manifest"`   // jsonl csv，在输出目录下生成 manifest.jsonl 或 manifest.csv
+	Archive   string `json:"archive" yaml:"archive"`     // tar tar.gz zip，图片写入输出目录下的归档分片，目录布局保留在分片内
+	ShardMB   int64  `json:"shard_mb" yaml:"shard_mb"`   // 单个归档分片的大小上限，默认 1024
+	Overwrite string `json:"overwrite" yaml:"overwrite"` // 目标文件已存在时: skip overwrite rename error，默认 skip，中断后续跑不会因已保存的图片出错
+	Fsync     bool   `json:"fsync" yaml:"fsync"`         // 每张图片写入后 fsync
+}
+
+// JobLimits 并发和频率限制
+type JobLimits struct {
+	Concurrency      int    `json:"concurrency" yaml:"concurrency"`             // 同时处理的关键词数量，默认 2
+	SearchRoutines   int    `json:"search_routines" yaml:"search_routines"`     // 单个关键词的搜索并发，默认 3
+	DownloadRoutines int    `json:"download_routines" yaml:"download_routines"` // 单个关键词的下载并发，默认 8
+	HostConcurrency  int    `json:"host_concurrency" yaml:"host_concurrency"`   // 每个域名同时下载的图片数，默认 4
+	Interval         string `json:"interval" yaml:"interval"`                   // 相邻两个关键词任务开始的最小间隔，例如 500ms
+}
+
+// JobSummary 任务执行汇总，同时写入输出目录下的 summary.json
+type JobSummary struct {
+	Name       string        `json:"name"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Found      int           `json:"found"`
+	Downloaded int           `json:"downloaded"`
+	Tasks      []TaskSummary `json:"tasks"`
+}
+
+// TaskSummary 单个关键词在单个引擎上的执行结果
+type TaskSummary struct {
+	Keyword    string   `json:"keyword"`
+	Engine     string   `json:"engine"`
+	Dir        string   `json:"dir"`
+	Found      int      `json:"found"`
+	Downloaded int      `json:"downloaded"`
+	Files      []string `json:"files"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// LoadJob 从 yaml/json 文件加载任务，根据文件后缀判断格式
+func LoadJob(filename string) (*Job, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = json.Unmarshal(data, &job)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &job)
+	default:
+		return nil, fmt.Errorf("unsupported job file format: %s", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse job file %s: %w", filename, err)
+	}
+	return &job, nil
+}
+
+// 创建采集器，测试时替换
+var newJobCapture = NewCapture
+
+// 补全默认值并校验
+func (j *Job) normalize() error {
+	if len(j.Engines) == 0 {
+		j.Engines = []string{EngineBaidu}
+	}
+	for _, engine := range j.Engines {
+		if engine != EngineBaidu && engine != EngineBing {
+			return fmt.Errorf("%w: %s", ErrUnsupportedEngine, engine)
+		}
+	}
+	if j.KeywordsFile != "" {
+		keywords, err := readKeywords(j.KeywordsFile)
+		if err != nil {
+			return err
+		}
+		j.Keywords = append(j.Keywords, keywords...)
+		j.KeywordsFile = ""
+	}
+	if len(j.Keywords) == 0 {
+		return fmt.Errorf("job %s has no keywords", j.Name)
+	}
+	for _, kw := range j.Keywords {
+		switch strings.TrimSpace(kw.Keyword) {
+		case "", ".", "..":
+			return fmt.Errorf("invalid keyword %q", kw.Keyword)
+		}
+	}
+	if j.Count <= 0 {
+		j.Count = 20
+	}
+	if j.Output.Dir == "" {
+		return ErrInvalidTargetPath
+	}
+	switch j.Output.Layout {
+	case "":
+		j.Output.Layout = JobLayoutKeyword
+	case JobLayoutFlat, JobLayoutKeyword, JobLayoutEngine:
+	default:
+		return fmt.Errorf("unknown output layout: %s", j.Output.Layout)
+	}
+	switch j.Output.Archive {
+	case "", ArchiveTar, ArchiveTarGzip, ArchiveZip:
+	default:
+		return fmt.Errorf("unknown archive format: %s", j.Output.Archive)
+	}
+	switch j.Output.Manifest {
+	case "", ManifestJSONL, ManifestCSV:
+	default:
+		return fmt.Errorf("unknown manifest format: %s", j.Output.Manifest)
+	}
+	if j.Output.Filename != "" {
+		if _, err := parseNameTemplate(j.Output.Filename); err != nil {
+			return err
+		}
+	}
+	if j.Output.Overwrite == "" {
+		j.Output.Overwrite = string(Overwrite_SKIP)
+	}
+	if _, err := parseEnum("overwrite policy", j.Output.Overwrite, Overwrite_SKIP, Overwrite_REPLACE, Overwrite_RENAME, Overwrite_ERROR); err != nil {
+		return err
+	}
+	if j.Limits.Concurrency <= 0 {
+		j.Limits.Concurrency = 2
+	}
+	if j.Limits.SearchRoutines <= 0 {
+		j.Limits.SearchRoutines = 3
+	}
+	if j.Limits.DownloadRoutines <= 0 {
+		j.Limits.DownloadRoutines = maxDownloadRoutines
+	}
+	if j.Limits.Interval != "" {
+		if _, err := time.ParseDuration(j.Limits.Interval); err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+	}
+	return nil
+}
+
+// 读取关键词文件，忽略空行和 # 开头的注释
+func readKeywords(filename string) ([]JobKeyword, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var keywords []JobKeyword
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keywords = append(keywords, JobKeyword{Keyword: line})
+	}
+	return keywords, scanner.Err()
+}
+
+// 关键词任务的保存目录，关键词中的路径分隔符等字符会被替换，不会跳出输出目录
+func (j *Job) taskDir(engine, keyword string) string {
+	keyword = nameReplacer.Replace(classOf(keyword))
+	switch j.Output.Layout {
+	case JobLayoutFlat:
+		return j.Output.Dir
+	case JobLayoutEngine:
+		return filepath.Join(j.Output.Dir, nameReplacer.Replace(classOf(engine)), keyword)
+	}
+	return filepath.Join(j.Output.Dir, keyword)
+}
+
+// RunJob 执行批量抓取任务：每个关键词在每个引擎上搜索并下载，关键词之间按并发和间隔限制执行。
+// 单个关键词失败不会中断任务，失败原因记录在汇总中；执行结束后汇总写入输出目录下的 summary.json。
+// 任务进度记录在输出目录下的 checkpoint.jsonl，中断后再次执行会从断点继续，删除该文件可从头开始。
+// extra 为额外的采集器选项，例如 WithLogger
+func RunJob(spec *Job, extra ...CaptureOption) (*JobSummary, error) {
+	job := *spec
+	job.Keywords = append([]JobKeyword(nil), spec.Keywords...)
+	if err := job.normalize(); err != nil {
+		return nil, err
+	}
+	opts, err := job.Filters.Options()
+	if err != nil {
+		return nil, err
+	}
+	captureOpts := []CaptureOption{
+		WithDownloadRoutines(job.Limits.DownloadRoutines),
+		WithOverwritePolicy(OverwritePolicy(job.Output.Overwrite)),
+	}
+	if job.Output.Fsync {
+		captureOpts = append(captureOpts, WithSink(NewFileSink("", WithFileSync())))
+	}
+	if job.Limits.HostConcurrency > 0 {
+		captureOpts = append(captureOpts, WithHostConcurrency(job.Limits.HostConcurrency))
+	}
+	captureOpts = append(captureOpts, extra...)
+	captures := make(map[string]Capture, len(job.Engines))
+	for _, engine := range job.Engines {
+		captures[engine], err = newJobCapture(engine, job.Limits.SearchRoutines, captureOpts...)
+		if err != nil {
+			return nil, err
+		}
+		defer captures[engine].Close()
+	}
+	if err = os.MkdirAll(job.Output.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %w", err)
+	}
+	cp, err := openCheckpoint(filepath.Join(job.Output.Dir, jobCheckpointFile))
+	if err != nil {
+		return nil, err
+	}
+	defer cp.close()
+	var downloadOpts []DownloadOption
+	if job.Output.Manifest != "" {
+		manifest, err := NewManifest(filepath.Join(job.Output.Dir, "manifest."+job.Output.Manifest))
+		if err != nil {
+			return nil, err
+		}
+		defer manifest.Close()
+		downloadOpts = append(downloadOpts, WithManifest(manifest))
+	}
+	if job.Output.Archive != "" {
+		archive, err := NewArchive(job.Output.Dir, job.Output.Archive, WithShardBytes(job.Output.ShardMB<<20))
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		downloadOpts = append(downloadOpts, WithArchive(archive))
+	}
+	if job.Output.Filename != "" {
+		downloadOpts = append(downloadOpts, WithFilenameTemplate(job.Output.Filename))
+	}
+	runner := &jobRunner{
+		cp:           cp,
+		outDir:       job.Output.Dir,
+		md5Naming:    job.Output.Md5Naming,
+		archived:     job.Output.Archive != "",
+		opts:         opts,
+		downloadOpts: downloadOpts,
+	}
+	pool, err := ants.NewPool(job.Limits.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.Release()
+
+	summary := &JobSummary{
+		Name:      job.Name,
+		StartedAt: time.Now(),
+		Tasks:     make([]TaskSummary, len(job.Keywords)*len(job.Engines)),
+	}
+	interval, _ := time.ParseDuration(job.Limits.Interval)
+	var wg sync.WaitGroup
+	for i, kw := range job.Keywords {
+		for j, engine := range job.Engines {
+			if interval > 0 && i+j > 0 {
+				time.Sleep(interval)
+			}
+			count := kw.Count
+			if count <= 0 {
+				count = job.Count
+			}
+			task := &summary.Tasks[i*len(job.Engines)+j]
+			task.Keyword, task.Engine = kw.Keyword, engine
+			task.Dir = job.taskDir(engine, kw.Keyword)
+			capture := captures[engine]
+			wg.Add(1)
+			err = pool.Submit(func() {
+				defer wg.Done()
+				runner.run(capture, task, count)
+			})
+			if err != nil {
+				wg.Done()
+				task.Error = err.Error()
+			}
+		}
+	}
+	wg.Wait()
+	summary.FinishedAt = time.Now()
+	for _, task := range summary.Tasks {
+		summary.Found += task.Found
+		summary.Downloaded += task.Downloaded
+	}
+	return summary, writeJSONFile(filepath.Join(job.Output.Dir, jobSummaryFile), summary)
+}
+
+// 所有关键词任务共享的配置
+type jobRunner struct {
+	cp           *checkpoint
+	outDir       string
+	md5Naming    bool
+	archived     bool // 写入归档时下载目录为分片内相对输出目录的路径
+	opts         []Option
+	downloadOpts []DownloadOption
+}
+
+// 执行单个关键词任务：逐页搜索并把图片地址记入进度日志，直到数量足够或没有更多结果，
+// 再下载所有等待中的图片。中断后再次执行会从保存的分页偏移继续，已下载的图片不会重复下载
+func (r *jobRunner) run(capture Capture, task *TaskSummary, count int) {
+	cp, opts, downloadOpts := r.cp, r.opts, r.downloadOpts
+	key := task.Engine + ":" + task.Keyword
+	offset, done, found := cp.progress(key)
+	if !done && found < count {
+		var queueErr error
+		cursor := WithPageCursor(offset, func(next int) {
+			// 这一页没有全部入队时不保存偏移，续跑时重新搜索这一页
+			if queueErr != nil {
+				return
+			}
+			queueErr = cp.setOffset(key, next)
+		})
+		err := capture.RangeResults(task.Keyword, func(results []Result) bool {
+			if queueErr != nil {
+				return false
+			}
+			for _, result := range results {
+				if found >= count {
+					break
+				}
+				if found, queueErr = cp.queue(key, result); queueErr != nil {
+					return false
+				}
+			}
+			return found < count
+		}, append(opts[:len(opts):len(opts)], cursor)...)
+		if err == nil {
+			err = queueErr
+		}
+		if err == nil {
+			err = cp.searchDone(key)
+		}
+		if err != nil {
+			task.Error = err.Error()
+		}
+	}
+	if results := cp.pending(key); len(results) > 0 {
+		var (
+			mu        sync.Mutex
+			finishErr error
+		)
+		hook := WithReportHook(func(report DownloadReport) {
+			if err := cp.finish(key, report); err != nil {
+				mu.Lock()
+				if finishErr == nil {
+					finishErr = err
+				}
+				mu.Unlock()
+			}
+		})
+		// {index} 使用入队顺序，续跑时同一张图片的序号不变
+		dir := task.Dir
+		if r.archived {
+			dir, _ = filepath.Rel(r.outDir, task.Dir)
+		}
+		_, err := capture.DownloadResults(results, dir, r.md5Naming, append(downloadOpts[:len(downloadOpts):len(downloadOpts)], hook, withIndexes(cp.indexes(key)))...)
+		// 进度没有写入时续跑会重新下载这些图片
+		if err == nil {
+			err = finishErr
+		}
+		if err != nil {
+			task.Error = err.Error()
+		}
+	}
+	_, _, task.Found = cp.progress(key)
+	task.Files = cp.files(key)
+	task.Downloaded = len(task.Files)
+}
+
+func writeJSONFile(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,314 @@
+package imagecapture
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/18 下午3:00
+* @Package:
+ */
+
+func TestLoadJob(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		want     []JobKeyword
+		wantErr  bool
+	}{
+		{
+			name:     "yaml",
+			filename: "job.yaml",
+			content:  "name: tigers\nkeywords:\n  - 东北虎\n  - keyword: 华南虎\n    count: 5\n",
+			want:     []JobKeyword{{Keyword: "东北虎"}, {Keyword: "华南虎", Count: 5}},
+		},
+		{
+			name:     "json",
+			filename: "job.json",
+			content:  `{"name":"tigers","keywords":["东北虎",{"keyword":"华南虎","count":5}]}`,
+			want:     []JobKeyword{{Keyword: "东北虎"}, {Keyword: "华南虎", Count: 5}},
+		},
+		{
+			name:     "unknown format",
+			filename: "job.toml",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.filename)
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadJob(filename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadJob() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && !reflect.DeepEqual(got.Keywords, tt.want) {
+				t.Errorf("LoadJob() keywords = %v, want %v", got.Keywords, tt.want)
+			}
+		})
+	}
+}
+
+func TestJob_taskDir(t *testing.T) {
+	tests := []struct {
+		layout  string
+		keyword string
+		want    string
+	}{
+		{JobLayoutKeyword, "老虎", filepath.Join("out", "老虎")},
+		{JobLayoutKeyword, "../../x", filepath.Join("out", ".._.._x")},
+		{JobLayoutKeyword, `a\b:c`, filepath.Join("out", "a_b_c")},
+		{JobLayoutEngine, "../x", filepath.Join("out", EngineBing, ".._x")},
+		{JobLayoutFlat, "../x", "out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout+" "+tt.keyword, func(t *testing.T) {
+			job := &Job{Output: JobOutput{Dir: "out", Layout: tt.layout}}
+			if got := job.taskDir(EngineBing, tt.keyword); got != tt.want {
+				t.Errorf("taskDir() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	// 无法作为目录名的关键词直接报错
+	for _, keyword := range []string{"..", " . ", ""} {
+		job := &Job{Keywords: []JobKeyword{{Keyword: keyword}}, Output: JobOutput{Dir: "out"}}
+		if err := job.normalize(); err == nil {
+			t.Errorf("normalize() with keyword %q succeeded", keyword)
+		}
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	server, _ := newBingServer(t)
+	defer func(f func(string, int, ...CaptureOption) (Capture, error)) { newJobCapture = f }(newJobCapture)
+	newJobCapture = func(engine string, routineSize int, opts ...CaptureOption) (Capture, error) {
+		bc := NewBingCapture(routineSize, opts...).(*BingCapture)
+		bc.baseUrl = server.URL + "/images/async"
+		return bc, nil
+	}
+	dir := t.TempDir()
+	keywordsFile := filepath.Join(dir, "keywords.txt")
+	if err := os.WriteFile(keywordsFile, []byte("# 注释\n华南虎\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job := &Job{
+		Name:         "tigers",
+		Engines:      []string{EngineBing},
+		Keywords:     []JobKeyword{{Keyword: "东北虎"}},
+		KeywordsFile: keywordsFile,
+		Count:        2,
+		Output:       JobOutput{Dir: filepath.Join(dir, "out"), Layout: JobLayoutEngine, Manifest: ManifestCSV},
+		Limits:       JobLimits{Concurrency: 2, Interval: "1ms"},
+	}
+	summary, err := RunJob(job)
+	if err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	if len(summary.Tasks) != 2 || summary.Found != 4 || summary.Downloaded != 4 {
+		t.Fatalf("RunJob() summary = %+v", summary)
+	}
+	for _, task := range summary.Tasks {
+		if task.Dir != filepath.Join(dir, "out", EngineBing, task.Keyword) || task.Error != "" {
+			t.Errorf("RunJob() task = %+v", task)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, "out", jobSummaryFile)); err != nil {
+		t.Errorf("RunJob() summary file: %v", err)
+	}
+	records, err := ReadManifest(filepath.Join(dir, "out", "manifest.csv"))
+	if err != nil || len(records) != 4 || records[0].Engine != EngineBing || records[0].Keyword == "" {
+		t.Errorf("RunJob() manifest = %+v", records)
+	}
+	if job.KeywordsFile != keywordsFile {
+		t.Errorf("RunJob() modified the job spec")
+	}
+}
+
+func TestRunJob_Resume(t *testing.T) {
+	server, lastQuery := newBingServer(t)
+	defer func(f func(string, int, ...CaptureOption) (Capture, error)) { newJobCapture = f }(newJobCapture)
+	newJobCapture = func(engine string, routineSize int, opts ...CaptureOption) (Capture, error) {
+		bc := NewBingCapture(routineSize, opts...).(*BingCapture)
+		bc.baseUrl = server.URL + "/images/async"
+		return bc, nil
+	}
+	dir := t.TempDir()
+	// 上次运行已抓取第一页并下载了一张图片，第二页还没有处理
+	cp, err := openCheckpoint(filepath.Join(dir, jobCheckpointFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := EngineBing + ":老虎"
+	cp.queue(key, Result{URL: server.URL + "/full.jpg"})
+	cp.setOffset(key, 60)
+	cp.finish(key, DownloadReport{URL: server.URL + "/full.jpg", Path: filepath.Join(dir, "old.png")})
+	cp.close()
+
+	job := &Job{
+		Engines:  []string{EngineBing},
+		Keywords: []JobKeyword{{Keyword: "老虎"}},
+		Count:    2,
+		Output:   JobOutput{Dir: dir, Layout: JobLayoutFlat},
+	}
+	summary, err := RunJob(job)
+	if err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	if got := lastQuery().Get("first"); got != "60" {
+		t.Errorf("RunJob() resumed from first = %s, want 60", got)
+	}
+	task := summary.Tasks[0]
+	if task.Found != 2 || task.Downloaded != 2 || task.Files[0] != filepath.Join(dir, "old.png") {
+		t.Fatalf("RunJob() task = %+v", task)
+	}
+
+	// 任务已完成，再次执行不会请求搜索引擎和图片
+	server.Close()
+	summary, err = RunJob(job)
+	if err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	if task = summary.Tasks[0]; task.Error != "" || task.Downloaded != 2 {
+		t.Errorf("RunJob() rerun task = %+v", task)
+	}
+}
+
+func TestRunJob_Archive(t *testing.T) {
+	server, _ := newBingServer(t)
+	defer func(f func(string, int, ...CaptureOption) (Capture, error)) { newJobCapture = f }(newJobCapture)
+	newJobCapture = func(engine string, routineSize int, opts ...CaptureOption) (Capture, error) {
+		bc := NewBingCapture(routineSize, opts...).(*BingCapture)
+		bc.baseUrl = server.URL + "/images/async"
+		return bc, nil
+	}
+	dir := t.TempDir()
+	job := &Job{
+		Engines:  []string{EngineBing},
+		Keywords: []JobKeyword{{Keyword: "老虎"}},
+		Count:    2,
+		Output:   JobOutput{Dir: dir, Layout: JobLayoutEngine, Archive: ArchiveZip},
+	}
+	summary, err := RunJob(job)
+	if err != nil || summary.Downloaded != 2 {
+		t.Fatalf("RunJob() = %+v, %v", summary, err)
+	}
+	files := readShard(t, filepath.Join(dir, "images-000000.zip"))
+	for _, file := range summary.Tasks[0].Files {
+		if filepath.Dir(file) != filepath.Join(EngineBing, "老虎") || files[file] == nil {
+			t.Errorf("RunJob() archived file %s, shard = %v", file, files)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, EngineBing)); !os.IsNotExist(err) {
+		t.Errorf("RunJob() created image directories")
+	}
+}
+
+func TestRunJob_FilenameTemplate(t *testing.T) {
+	server, _ := newBingServer(t)
+	defer func(f func(string, int, ...CaptureOption) (Capture, error)) { newJobCapture = f }(newJobCapture)
+	newJobCapture = func(engine string, routineSize int, opts ...CaptureOption) (Capture, error) {
+		bc := NewBingCapture(routineSize, opts...).(*BingCapture)
+		bc.baseUrl = server.URL + "/images/async"
+		return bc, nil
+	}
+	dir := t.TempDir()
+	job := &Job{
+		Engines:  []string{EngineBing},
+		Keywords: []JobKeyword{{Keyword: "东北虎"}},
+		Count:    2,
+		Output:   JobOutput{Dir: dir, Layout: JobLayoutFlat, Filename: "{engine}/{keyword}/{index:2}"},
+	}
+	summary, err := RunJob(job)
+	if err != nil {
+		t.Fatalf("RunJob() error = %v", err)
+	}
+	files := summary.Tasks[0].Files
+	sort.Strings(files)
+	want := []string{filepath.Join(dir, EngineBing, "东北虎", "00.png"), filepath.Join(dir, EngineBing, "东北虎", "01.png")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("RunJob() files = %v, want %v", files, want)
+	}
+	job.Output.Filename = "{name}"
+	if _, err = RunJob(job); err == nil {
+		t.Error("RunJob() with invalid filename template should fail")
+	}
+}
+
+// 只实现任务用到的 RangeResults 和 DownloadResults
+type stubCapture struct {
+	Capture
+	rangeResults    func(callBack func([]Result) bool, opts ...Option) error
+	downloadResults func(results []Result, opts ...DownloadOption) ([]string, error)
+}
+
+func (c stubCapture) RangeResults(_ string, callBack func([]Result) bool, opts ...Option) error {
+	return c.rangeResults(callBack, opts...)
+}
+
+func (c stubCapture) DownloadResults(results []Result, _ string, _ bool, opts ...DownloadOption) ([]string, error) {
+	return c.downloadResults(results, opts...)
+}
+
+func TestJobRunner_CheckpointErrors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), jobCheckpointFile)
+	cp, err := openCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.close()
+	reopen := func() {
+		if cp.file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := EngineBing + ":老虎"
+	runner := &jobRunner{cp: cp}
+	page := []Result{{URL: "https://example.com/1.jpg"}, {URL: "https://example.com/2.jpg"}}
+
+	// 入队失败时不保存下一页的偏移，续跑时重新搜索这一页
+	task := &TaskSummary{Engine: EngineBing, Keyword: "老虎"}
+	runner.run(stubCapture{rangeResults: func(callBack func([]Result) bool, opts ...Option) error {
+		q := newQuery()
+		for _, option := range opts {
+			option(&q)
+		}
+		cp.close()
+		callBack(page)
+		reopen()
+		q.nextPage(60)
+		return nil
+	}}, task, 10)
+	if offset, done, _ := cp.progress(key); task.Error == "" || offset != 0 || done {
+		t.Errorf("run() task = %+v, offset = %d, done = %v", task, offset, done)
+	}
+
+	// 下载结果没有写入进度时任务报错
+	for _, r := range page {
+		cp.queue(key, r)
+	}
+	cp.searchDone(key)
+	task = &TaskSummary{Engine: EngineBing, Keyword: "老虎"}
+	runner.run(stubCapture{downloadResults: func(results []Result, opts ...DownloadOption) ([]string, error) {
+		cfg := newDownloadConfig(opts)
+		cp.close()
+		for _, r := range results {
+			cfg.report(DownloadReport{URL: r.URL, Path: "x.png"})
+		}
+		reopen()
+		return nil, nil
+	}}, task, 10)
+	if task.Error == "" || task.Downloaded != 0 {
+		t.Errorf("run() task = %+v, want checkpoint error", task)
+	}
+}
@@ -0,0 +1,42 @@
+package imagecapture
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/27 上午10:00
+* @Package:
+ */
+
+// Logger 结构化日志接口，args 为交替的键值对，与 log/slog 一致，*slog.Logger 可直接使用：
+//
+//	capture := imagecapture.NewBingCapture(3, imagecapture.WithLogger(slog.Default()))
+//
+// 搜索分页请求、重试、过滤掉的结果和每张图片的下载结果均以 Debug 级别输出，
+// 字段包括 engine、url、attempt、duration、error 等
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// 默认不输出日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+// WithLogger 设置采集器和内置下载器的日志，默认不输出
+func WithLogger(logger Logger) CaptureOption {
+	return func(cfg *captureConfig) {
+		if logger == nil {
+			return
+		}
+		cfg.logger = logger
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.logger = logger
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build go1.21
+
+package imagecapture
+
+import "log/slog"
+
+// *slog.Logger 可以直接作为 Logger 使用
+var _ Logger = (*slog.Logger)(nil)
@@ -0,0 +1,76 @@
+package imagecapture
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// 记录日志，按 消息 和 键值对 保存
+type testLogger struct {
+	mu      sync.Mutex
+	entries []map[string]interface{}
+}
+
+func (l *testLogger) log(level, msg string, args []any) {
+	entry := map[string]interface{}{"level": level, "msg": msg}
+	for i := 0; i+1 < len(args); i += 2 {
+		entry[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+func (l *testLogger) Debug(msg string, args ...any) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...any)  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...any) { l.log("error", msg, args) }
+
+// 返回指定消息的日志
+func (l *testLogger) find(msg string) []map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []map[string]interface{}
+	for _, entry := range l.entries {
+		if entry["msg"] == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestWithLogger(t *testing.T) {
+	server, _ := newBingServer(t)
+	logger := &testLogger{}
+	bc := NewBingCapture(1, WithLogger(logger)).(*BingCapture)
+	bc.baseUrl = server.URL + "/images/async"
+
+	if _, err := bc.Search("老虎", 10, WithFilters(TitleFilter(regexp.MustCompile("老虎")))); err != nil {
+		t.Fatal(err)
+	}
+	pages := logger.find("search page")
+	if len(pages) == 0 || pages[0]["engine"] != EngineBing || pages[0]["status"] != 200 || pages[0]["duration"] == nil {
+		t.Errorf("search page logs = %v", pages)
+	}
+	if rejected := logger.find("result rejected"); len(rejected) == 0 || rejected[0]["reason"] != "filter" {
+		t.Errorf("result rejected logs = %v", rejected)
+	}
+
+	_, err := bc.BatchDownload([]string{server.URL + "/full.jpg", server.URL + "/missing.jpg"}, t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finished := logger.find("download finished"); len(finished) != 1 || finished[0]["url"] != server.URL+"/full.jpg" {
+		t.Errorf("download finished logs = %v", finished)
+	}
+	if failed := logger.find("download failed"); len(failed) != 1 || failed[0]["error"] == nil {
+		t.Errorf("download failed logs = %v", failed)
+	}
+	for _, entry := range logger.entries {
+		if entry["level"] != "debug" {
+			t.Errorf("unexpected log level: %v", entry)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package imagecapture
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/20 上午10:05
+* @Package:
+ */
+
+// 清单格式
+const (
+	ManifestJSONL = "jsonl"
+	ManifestCSV   = "csv"
+)
+
+// ManifestRecord 清单中的一行，记录图片文件及其来源
+type ManifestRecord struct {
+	File        string    `json:"file"` // 相对清单所在目录的路径
+	URL         string    `json:"url"`
+	Engine      string    `json:"engine,omitempty"`
+	Keyword     string    `json:"keyword,omitempty"`
+	Thumbnail   string    `json:"thumbnail,omitempty"`
+	Title       string    `json:"title,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	MD5         string    `json:"md5"`
+	SHA256      string    `json:"sha256"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	Archive     string    `json:"archive,omitempty"` // 写入归档时为分片路径，File 为分片内的路径
+	Variant     string    `json:"variant,omitempty"` // 保存的图片版本：original、middle、thumbnail
+}
+
+var manifestHeader = []string{
+	"file", "url", "engine", "keyword", "thumbnail", "title", "source",
+	"md5", "sha256", "width", "height", "size", "content_type", "fetched_at", "archive", "variant",
+}
+
+func (r ManifestRecord) csvRow() []string {
+	return []string{
+		r.File, r.URL, r.Engine, r.Keyword, r.Thumbnail, r.Title, r.Source,
+		r.MD5, r.SHA256, strconv.Itoa(r.Width), strconv.Itoa(r.Height),
+		strconv.FormatInt(r.Size, 10), r.ContentType, r.FetchedAt.Format(time.RFC3339), r.Archive, r.Variant,
+	}
+}
+
+// 按表头顺序解析 CSV 中的一行，兼容没有 variant 列的旧清单
+func parseManifestRow(row []string) (ManifestRecord, error) {
+	if len(row) != len(manifestHeader) && len(row) != len(manifestHeader)-1 {
+		return ManifestRecord{}, fmt.Errorf("invalid manifest row: want %d columns, got %d", len(manifestHeader), len(row))
+	}
+	r := ManifestRecord{
+		File: row[0], URL: row[1], Engine: row[2], Keyword: row[3], Thumbnail: row[4], Title: row[5], Source: row[6],
+		MD5: row[7], SHA256: row[8], ContentType: row[12], Archive: row[14],
+	}
+	if len(row) > 15 {
+		r.Variant = row[15]
+	}
+	var err error
+	if r.Width, err = strconv.Atoi(row[9]); err != nil {
+		return r, fmt.Errorf("invalid manifest width: %w", err)
+	}
+	if r.Height, err = strconv.Atoi(row[10]); err != nil {
+		return r, fmt.Errorf("invalid manifest height: %w", err)
+	}
+	if r.Size, err = strconv.ParseInt(row[11], 10, 64); err != nil {
+		return r, fmt.Errorf("invalid manifest size: %w", err)
+	}
+	if r.FetchedAt, err = time.Parse(time.RFC3339, row[13]); err != nil {
+		return r, fmt.Errorf("invalid manifest fetched_at: %w", err)
+	}
+	return r, nil
+}
+
+// ReadManifest 读取清单，根据后缀判断格式。返回记录中的 File 仍是相对清单所在目录的路径
+func ReadManifest(filename string) ([]ManifestRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []ManifestRecord
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		rows, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			if i == 0 {
+				continue // 表头
+			}
+			r, err := parseManifestRow(row)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", filename, i+1, err)
+			}
+			records = append(records, r)
+		}
+	case ".jsonl", ".ndjson":
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			var r ManifestRecord
+			if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", filename, line, err)
+			}
+			records = append(records, r)
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported manifest format: %s", filename)
+	}
+	return records, nil
+}
+
+// Manifest 数据集清单，每下载成功一张图片追加一行，可并发写入。
+// 通过 WithManifest 传给 BatchDownload、DownloadResults
+type Manifest struct {
+	mu     sync.Mutex
+	dir    string
+	format string
+	file   *os.File
+	csv    *csv.Writer
+	err    error // 第一次写入失败的原因，Close 时返回
+}
+
+// NewManifest 打开清单文件，根据后缀判断格式：.csv 为 CSV，.jsonl/.ndjson 为 JSON Lines。
+// 文件已存在时继续追加
+func NewManifest(filename string) (*Manifest, error) {
+	var format string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		format = ManifestCSV
+	case ".jsonl", ".ndjson":
+		format = ManifestJSONL
+	default:
+		return nil, fmt.Errorf("unsupported manifest format: %s", filename)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %w", err)
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{dir: filepath.Dir(filename), format: format, file: file}
+	if format == ManifestCSV {
+		m.csv = csv.NewWriter(file)
+		// 新文件先写表头
+		if offset, _ := file.Seek(0, io.SeekEnd); offset == 0 {
+			m.csv.Write(manifestHeader)
+		}
+	}
+	return m, nil
+}
+
+// Write 写入一张下载成功的图片，下载失败的报告会被忽略
+func (m *Manifest) Write(r DownloadReport) error {
+	if r.Err != nil {
+		return nil
+	}
+	record := r.manifestRecord()
+	if record.Archive != "" {
+		record.Archive = m.relative(record.Archive)
+	} else {
+		record.File = m.relative(record.File)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	if m.csv != nil {
+		if err = m.csv.Write(record.csvRow()); err == nil {
+			// 及时落盘，进程中断也不会丢失已下载图片的记录
+			m.csv.Flush()
+			err = m.csv.Error()
+		}
+	} else {
+		var data []byte
+		if data, err = json.Marshal(record); err == nil {
+			_, err = m.file.Write(append(data, '\n'))
+		}
+	}
+	if err != nil && m.err == nil {
+		m.err = err
+	}
+	return err
+}
+
+func (r DownloadReport) manifestRecord() ManifestRecord {
+	return ManifestRecord{
+		File:        r.Path,
+		URL:         r.URL,
+		Engine:      r.Engine,
+		Keyword:     r.Keyword,
+		Thumbnail:   r.Thumbnail,
+		Title:       r.Title,
+		Source:      r.Source,
+		MD5:         r.MD5,
+		SHA256:      r.SHA256,
+		Width:       r.Width,
+		Height:      r.Height,
+		Size:        r.Size,
+		ContentType: r.ContentType,
+		FetchedAt:   r.FetchedAt,
+		Archive:     r.Archive,
+		Variant:     string(r.Variant),
+	}
+}
+
+// 清单目录下的文件记录相对路径，便于整个目录移动
+func (m *Manifest) relative(path string) string {
+	rel, err := filepath.Rel(m.dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Close 关闭清单文件，返回写入过程中的第一个错误
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.csv != nil {
+		m.csv.Flush()
+	}
+	if err := m.file.Close(); err != nil && m.err == nil {
+		m.err = err
+	}
+	return m.err
+}
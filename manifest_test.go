@@ -0,0 +1,76 @@
+package imagecapture
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/20 下午2:30
+* @Package:
+ */
+
+func TestManifest(t *testing.T) {
+	server, _ := newBingServer(t)
+	md5Sum, sha256Sum := md5.Sum(testPNG), sha256.Sum256(testPNG)
+	for _, format := range []string{ManifestJSONL, ManifestCSV} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "manifest."+format)
+			manifest, err := NewManifest(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewBingCapture(1).(*BingCapture)
+			results := []Result{
+				{URL: server.URL + "/full.jpg", Thumbnail: server.URL + "/thumb.jpg", Engine: EngineBing, Keyword: "老虎"},
+				{URL: server.URL + "/missing.jpg", Engine: EngineBing, Keyword: "老虎"},
+			}
+			paths, err := d.DownloadResults(results, filepath.Join(dir, "images"), true, WithManifest(manifest))
+			if err != nil || len(paths) != 1 {
+				t.Fatalf("DownloadResults() = %v, %v", paths, err)
+			}
+			if err = manifest.Close(); err != nil {
+				t.Fatal(err)
+			}
+			records, err := ReadManifest(filename)
+			if err != nil {
+				t.Fatalf("ReadManifest() error = %v", err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("manifest records = %+v", records)
+			}
+			r := records[0]
+			want := ManifestRecord{
+				File:        "images/" + hex.EncodeToString(md5Sum[:]) + ".png",
+				URL:         results[0].URL,
+				Engine:      EngineBing,
+				Keyword:     "老虎",
+				Thumbnail:   results[0].Thumbnail,
+				MD5:         hex.EncodeToString(md5Sum[:]),
+				SHA256:      hex.EncodeToString(sha256Sum[:]),
+				Width:       1,
+				Height:      1,
+				Size:        int64(len(testPNG)),
+				ContentType: "image/png",
+				Variant:     string(Variant_ORIGINAL),
+			}
+			if r.FetchedAt.IsZero() {
+				t.Errorf("manifest fetched_at is zero")
+			}
+			r.FetchedAt = want.FetchedAt
+			if r != want {
+				t.Errorf("manifest record = %+v, want %+v", r, want)
+			}
+			if _, err = os.Stat(filepath.Join(dir, r.File)); err != nil {
+				t.Errorf("manifest file: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package imagecapture
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/28 上午10:00
+* @Package:
+ */
+
+// 搜索结果计数的类别
+const (
+	ResultFound    = "found"    // 引擎返回的结果
+	ResultFiltered = "filtered" // 被来源规则或结果过滤器过滤掉的结果
+	ResultDeduped  = "deduped"  // 重复的结果
+)
+
+// 下载失败的错误分类
+const (
+	ErrorClassTimeout = "timeout"  // 请求超时
+	ErrorClassNetwork = "network"  // 连接失败等网络错误
+	ErrorClassHTTP4xx = "http_4xx" // 服务端返回 4xx
+	ErrorClassHTTP5xx = "http_5xx" // 服务端返回 5xx 或其他非 200 状态码
+	ErrorClassExists  = "exists"   // 目标文件已存在
+	ErrorClassOther   = "other"
+)
+
+// 协程池名称
+const (
+	PoolSearch   = "search"   // 并发请求搜索分页
+	PoolParse    = "parse"    // 必应校验原图地址
+	PoolDownload = "download" // 批量下载
+)
+
+// Metrics 指标采集接口，通过 WithMetrics 设置，方法会在多个协程中并发调用。
+// 子包 prometheus 提供了 Prometheus 的实现
+type Metrics interface {
+	// SearchPage 一次搜索分页请求，status 为 HTTP 状态码，请求失败时为 0
+	SearchPage(engine string, status int, elapsed time.Duration)
+	// SearchResults 搜索结果计数，outcome 为 ResultFound、ResultFiltered 或 ResultDeduped
+	SearchResults(engine, outcome string, n int)
+	// Download 一张图片下载结束，成功时 errClass 为空，否则为 ErrorClassTimeout 等错误分类
+	Download(engine string, bytes int64, elapsed time.Duration, errClass string)
+	// DownloadRetry 下载请求失败后重试
+	DownloadRetry(engine string)
+	// PoolUsage 协程池中正在执行的任务数和容量，pool 为 PoolSearch、PoolParse 或 PoolDownload
+	PoolUsage(pool string, running, capacity int)
+}
+
+// 默认不采集指标
+type nopMetrics struct{}
+
+func (nopMetrics) SearchPage(string, int, time.Duration)         {}
+func (nopMetrics) SearchResults(string, string, int)             {}
+func (nopMetrics) Download(string, int64, time.Duration, string) {}
+func (nopMetrics) DownloadRetry(string)                          {}
+func (nopMetrics) PoolUsage(string, int, int)                    {}
+
+// WithMetrics 设置采集器和内置下载器的指标采集
+func WithMetrics(metrics Metrics) CaptureOption {
+	return func(cfg *captureConfig) {
+		if metrics == nil {
+			return
+		}
+		cfg.metrics = metrics
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.metrics = metrics
+		})
+	}
+}
+
+// 协程池的使用情况
+type poolStats interface {
+	Running() int
+	Cap() int
+}
+
+func reportPool(metrics Metrics, name string, pool poolStats) {
+	metrics.PoolUsage(name, pool.Running(), pool.Cap())
+}
+
+// ErrorClass 下载错误的分类，err 为 nil 时返回空字符串
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 {
+			return ErrorClassHTTP4xx
+		}
+		return ErrorClassHTTP5xx
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrTimeout),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &netErr), errors.Is(err, ErrMaxRetryExceeded), errors.Is(err, ErrConnectionFailed):
+		return ErrorClassNetwork
+	case errors.Is(err, ErrFileAlreadyExists):
+		return ErrorClassExists
+	}
+	return ErrorClassOther
+}
@@ -0,0 +1,119 @@
+package imagecapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录指标调用
+type testMetrics struct {
+	mu        sync.Mutex
+	pages     []int
+	results   map[string]int
+	downloads []string
+	bytes     int64
+	pools     map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{results: make(map[string]int), pools: make(map[string]int)}
+}
+
+func (m *testMetrics) SearchPage(engine string, status int, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pages = append(m.pages, status)
+}
+
+func (m *testMetrics) SearchResults(engine, outcome string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[engine+"/"+outcome] += n
+}
+
+func (m *testMetrics) Download(engine string, bytes int64, elapsed time.Duration, errClass string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloads = append(m.downloads, engine+"/"+errClass)
+	m.bytes += bytes
+}
+
+func (m *testMetrics) DownloadRetry(engine string) {}
+
+func (m *testMetrics) PoolUsage(pool string, running, capacity int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if capacity > m.pools[pool] {
+		m.pools[pool] = capacity
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	server, _ := newBingServer(t)
+	metrics := newTestMetrics()
+	bc := NewBingCapture(1, WithMetrics(metrics), WithDownloadRoutines(2)).(*BingCapture)
+	bc.baseUrl = server.URL + "/images/async"
+
+	if _, err := bc.Search("老虎", 10, WithFilters(TitleFilter(regexp.MustCompile("老虎")))); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.pages) == 0 || metrics.pages[0] != 200 {
+		t.Errorf("search pages = %v", metrics.pages)
+	}
+	if metrics.results["bing/found"] == 0 || metrics.results["bing/filtered"] == 0 {
+		t.Errorf("search results = %v", metrics.results)
+	}
+
+	_, err := bc.BatchDownload([]string{server.URL + "/full.jpg", server.URL + "/missing.jpg"}, t.TempDir(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.downloads) != 2 || metrics.bytes != int64(len(testPNG)) {
+		t.Errorf("downloads = %v, bytes = %d", metrics.downloads, metrics.bytes)
+	}
+	if !contains(metrics.downloads, "bing/") || !contains(metrics.downloads, "bing/"+ErrorClassHTTP4xx) {
+		t.Errorf("downloads = %v", metrics.downloads)
+	}
+	if metrics.pools[PoolSearch] != 1 || metrics.pools[PoolDownload] != 2 {
+		t.Errorf("pools = %v", metrics.pools)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{&StatusError{StatusCode: 404, Status: "404 Not Found"}, ErrorClassHTTP4xx},
+		{fmt.Errorf("wrap: %w", &StatusError{StatusCode: 503}), ErrorClassHTTP5xx},
+		{&retryError{err: context.DeadlineExceeded}, ErrorClassTimeout},
+		{&retryError{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, ErrorClassNetwork},
+		{ErrMaxRetryExceeded, ErrorClassNetwork},
+		{fmt.Errorf("%w: a.png", ErrFileAlreadyExists), ErrorClassExists},
+		{errors.New("boom"), ErrorClassOther},
+	}
+	for _, tt := range tests {
+		if got := ErrorClass(tt.err); got != tt.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+	if err := (&retryError{err: context.DeadlineExceeded}); !errors.Is(err, ErrMaxRetryExceeded) {
+		t.Errorf("retryError should match ErrMaxRetryExceeded")
+	}
+}
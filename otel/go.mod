@@ -0,0 +1,23 @@
+module github.com/code-innovator-zyx/imagecapture/otel
+
+go 1.18
+
+require (
+	github.com/code-innovator-zyx/imagecapture v0.0.0
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+)
+
+require (
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/panjf2000/ants/v2 v2.10.0 // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// 与主模块在同一仓库中开发，发布时改为主模块的版本号
+replace github.com/code-innovator-zyx/imagecapture => ../
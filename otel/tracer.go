@@ -0,0 +1,95 @@
+// Package otel 将 imagecapture 的链路追踪导出到 OpenTelemetry：
+//
+//	tracer := otel.New(provider) // provider 为 nil 时使用全局 TracerProvider
+//	capture := imagecapture.NewBingCapture(3, imagecapture.WithTracer(tracer))
+//	results, err := capture.Search("老虎", 10, imagecapture.WithContext(ctx))
+package otel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+	global "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/29 下午2:00
+* @Package: OpenTelemetry 链路追踪
+ */
+
+// 注册到 TracerProvider 的名称
+const instrumentationName = "github.com/code-innovator-zyx/imagecapture"
+
+// Tracer 实现 imagecapture.Tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ imagecapture.Tracer = (*Tracer)(nil)
+
+// New 使用 tp 创建 Tracer，tp 为 nil 时使用 otel.GetTracerProvider()
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = global.GetTracerProvider()
+	}
+	return NewTracer(tp.Tracer(instrumentationName))
+}
+
+// NewTracer 使用已有的 trace.Tracer 创建 Tracer
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, args ...any) (context.Context, imagecapture.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attributes(args)...))
+	return ctx, &Span{span: span}
+}
+
+// Span 实现 imagecapture.Span
+type Span struct {
+	span trace.Span
+}
+
+func (s *Span) SetAttributes(args ...any) {
+	s.span.SetAttributes(attributes(args)...)
+}
+
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// 将交替的键值对转换为属性，时长以毫秒记录，键名追加 _ms
+func attributes(args []any) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		switch v := args[i+1].(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, v))
+		case int:
+			attrs = append(attrs, attribute.Int(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		case float64:
+			attrs = append(attrs, attribute.Float64(key, v))
+		case time.Duration:
+			attrs = append(attrs, attribute.Float64(key+"_ms", float64(v)/float64(time.Millisecond)))
+		default:
+			attrs = append(attrs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,111 @@
+package otel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer_BatchDownload(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(img.Bytes())
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	capture := imagecapture.NewBingCapture(1, imagecapture.WithTracer(New(tp)))
+	_, err := capture.BatchDownload([]string{server.URL + "/ok.png", server.URL + "/missing.png"}, t.TempDir(), false,
+		imagecapture.WithDownloadContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := map[string][]tracetest.SpanStub{}
+	byID := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = append(spans[span.Name], span)
+		byID[span.SpanContext.SpanID().String()] = span
+	}
+	parentName := func(span tracetest.SpanStub) string {
+		return byID[span.Parent.SpanID().String()].Name
+	}
+	if batch := spans[imagecapture.SpanBatchDownload]; len(batch) != 1 || parentName(batch[0]) != "parent" {
+		t.Fatalf("batch download spans = %v", batch)
+	}
+	downloads := spans[imagecapture.SpanDownload]
+	if len(downloads) != 2 {
+		t.Fatalf("download spans = %d, want 2", len(downloads))
+	}
+	for _, span := range downloads {
+		if parentName(span) != imagecapture.SpanBatchDownload {
+			t.Errorf("download span parent = %s", parentName(span))
+		}
+	}
+	attempts := spans[imagecapture.SpanDownloadAttempt]
+	if len(attempts) != 2 {
+		t.Fatalf("download attempt spans = %d, want 2", len(attempts))
+	}
+	for _, span := range attempts {
+		if parentName(span) != imagecapture.SpanDownload {
+			t.Errorf("download attempt span parent = %s", parentName(span))
+		}
+		status := attribute.NewSet(span.Attributes...)
+		code, _ := status.Value("status")
+		if code.AsInt64() == http.StatusNotFound && span.Status.Code != codes.Error {
+			t.Errorf("failed download attempt status = %v", span.Status)
+		}
+		if code.AsInt64() == http.StatusOK && span.Status.Code == codes.Error {
+			t.Errorf("successful download attempt status = %v", span.Status)
+		}
+	}
+}
+
+func TestSpan_End(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := New(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	_, span := tracer.Start(context.Background(), "test", "engine", "bing", "count", 2, "ok", true, "elapsed", 1500*time.Millisecond)
+	span.SetAttributes("size", int64(1024), "other", []string{"a"})
+	span.End(errors.New("failed"))
+
+	stub := exporter.GetSpans()[0]
+	attrs := attribute.NewSet(stub.Attributes...)
+	want := map[attribute.Key]attribute.Value{
+		"engine":     attribute.StringValue("bing"),
+		"count":      attribute.IntValue(2),
+		"ok":         attribute.BoolValue(true),
+		"elapsed_ms": attribute.Float64Value(1500),
+		"size":       attribute.Int64Value(1024),
+		"other":      attribute.StringValue("[a]"),
+	}
+	for key, value := range want {
+		if got, ok := attrs.Value(key); !ok || got != value {
+			t.Errorf("attribute %s = %v, want %v", key, got.Emit(), value.Emit())
+		}
+	}
+	if stub.Status.Code != codes.Error || stub.Status.Description != "failed" || len(stub.Events) != 1 {
+		t.Errorf("span status = %v, events = %v", stub.Status, stub.Events)
+	}
+}
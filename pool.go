@@ -0,0 +1,88 @@
+package imagecapture
+
+import (
+	"errors"
+	"github.com/panjf2000/ants/v2"
+	"sync/atomic"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/12 下午4:00
+* @Package: 采集器的协程池
+ */
+
+// 必应校验图片地址的并发数
+const defaultCheckRoutines = 16
+
+// WorkerPool 执行任务的协程池，*ants.Pool 实现了该接口。
+// 通过 WithSearchPool、WithDownloadPool 注入后可以在多个采集器之间共享，容量由调用方管理
+type WorkerPool interface {
+	// Submit 提交任务，协程池已满时阻塞等待
+	Submit(task func()) error
+	Running() int
+	Cap() int
+}
+
+// WithSearchPool 使用调用方的协程池请求搜索分页，采集器 Close 时不会释放它，自适应并发也不会调整它的容量
+func WithSearchPool(pool WorkerPool) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.searchPool = pool
+	}
+}
+
+// WithDownloadPool 使用调用方的协程池批量下载，采集器 Close 时不会释放它，自适应并发也不会调整它的容量。
+// 单次批量下载的并发数仍不超过 WithDownloadRoutines 设置的并发数
+func WithDownloadPool(pool WorkerPool) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.injectedPool = pool
+		})
+	}
+}
+
+// workerPool 采集器长期持有的协程池，在多次搜索、下载之间复用。
+// 自己创建的协程池容量跟随 limiter 调整，Close 时释放
+type workerPool struct {
+	WorkerPool
+	owned   *ants.Pool // 自己创建的协程池，注入的协程池为 nil
+	limiter *concurrencyLimiter
+	closed  int32
+}
+
+// injected 为 nil 时按 limiter 当前的并发数创建协程池
+func newWorkerPool(injected WorkerPool, limiter *concurrencyLimiter) *workerPool {
+	if injected != nil {
+		return &workerPool{WorkerPool: injected, limiter: limiter}
+	}
+	pool, _ := ants.NewPool(limiter.limit())
+	return &workerPool{WorkerPool: pool, owned: pool, limiter: limiter}
+}
+
+// submit 提交任务前按 limiter 调整容量，Close 之后返回 ErrCaptureClosed
+func (p *workerPool) submit(task func()) error {
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return ErrCaptureClosed
+	}
+	if p.owned != nil {
+		if limit := p.limiter.limit(); limit != p.owned.Cap() {
+			p.owned.Tune(limit)
+		}
+	}
+	err := p.Submit(task)
+	if errors.Is(err, ants.ErrPoolClosed) {
+		return ErrCaptureClosed
+	}
+	return err
+}
+
+// close 拒绝之后提交的任务，释放自己创建的协程池，已提交的任务会继续执行完
+func (p *workerPool) close() {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return
+	}
+	if p.owned != nil {
+		p.owned.Release()
+	}
+}
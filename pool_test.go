@@ -0,0 +1,94 @@
+package imagecapture
+
+import (
+	"fmt"
+	"github.com/panjf2000/ants/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCapture_Close(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG)
+	}))
+	defer server.Close()
+	tests := []struct {
+		name    string
+		capture Capture
+	}{
+		{"baidu", NewBaiduCapture(1)},
+		{"bing", NewBingCapture(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.capture.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// 重复关闭没有影响
+			tt.capture.Close()
+			if _, err := tt.capture.Search("老虎", 10); err != ErrCaptureClosed {
+				t.Errorf("Search() error = %v, want ErrCaptureClosed", err)
+			}
+			paths, err := tt.capture.BatchDownload([]string{server.URL + "/1.png", server.URL + "/2.png"}, t.TempDir(), false)
+			if err != ErrCaptureClosed || len(paths) != 0 {
+				t.Errorf("BatchDownload() = %v, %v, want ErrCaptureClosed", paths, err)
+			}
+		})
+	}
+}
+
+func TestWithDownloadPool(t *testing.T) {
+	recorder := &hostRecorder{running: map[string]int{}, max: map[string]int{}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	pool, err := ants.NewPool(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+
+	// 两个采集器共享 2 个协程，同时批量下载时互不阻塞，总并发不超过协程池容量
+	captures := []Capture{
+		NewBingCapture(1, WithDownloadPool(pool), WithHostConcurrency(0)),
+		NewBaiduCapture(1, WithDownloadPool(pool), WithHostConcurrency(0)),
+	}
+	var wg sync.WaitGroup
+	for i, capture := range captures {
+		var urls []string
+		for j := 0; j < 6; j++ {
+			urls = append(urls, fmt.Sprintf("%s/%d-%d.png", server.URL, i, j))
+		}
+		wg.Add(1)
+		go func(capture Capture) {
+			defer wg.Done()
+			paths, err := capture.BatchDownload(urls, t.TempDir(), false)
+			if err != nil || len(paths) != len(urls) {
+				t.Errorf("BatchDownload() = %d paths, %v", len(paths), err)
+			}
+		}(capture)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("BatchDownload() with a shared pool did not finish")
+	}
+	if max := recorder.max["127.0.0.1"]; max > 2 {
+		t.Errorf("max concurrency = %d, want <= 2", max)
+	}
+
+	// 关闭采集器不会释放注入的协程池
+	for _, capture := range captures {
+		capture.Close()
+	}
+	if pool.IsClosed() {
+		t.Error("injected pool was released")
+	}
+}
@@ -0,0 +1,28 @@
+module github.com/code-innovator-zyx/imagecapture/prometheus
+
+go 1.18
+
+require (
+	github.com/code-innovator-zyx/imagecapture v0.0.0
+	github.com/prometheus/client_golang v1.14.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/panjf2000/ants/v2 v2.10.0 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.37.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	google.golang.org/protobuf v1.28.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// 与主模块在同一仓库中开发，发布时改为主模块的版本号
+replace github.com/code-innovator-zyx/imagecapture => ../
@@ -0,0 +1,128 @@
+// Package prometheus 将 imagecapture 的指标导出到 Prometheus：
+//
+//	metrics, err := prometheus.New(nil) // 注册到 prometheus.DefaultRegisterer
+//	if err != nil {
+//		panic(err)
+//	}
+//	capture := imagecapture.NewBingCapture(3, imagecapture.WithMetrics(metrics))
+//	http.Handle("/metrics", promhttp.Handler())
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/28 下午2:00
+* @Package: Prometheus 指标
+ */
+
+const namespace = "imagecapture"
+
+// Metrics 实现 imagecapture.Metrics，所有指标以 imagecapture_ 开头
+type Metrics struct {
+	searchPages     *prom.HistogramVec // 搜索分页请求耗时，按引擎和状态码
+	searchResults   *prom.CounterVec   // 搜索结果数，按引擎和类别（found filtered deduped）
+	downloads       *prom.HistogramVec // 下载耗时，按引擎和结果（ok 或错误分类）
+	downloadBytes   *prom.CounterVec   // 下载字节数
+	downloadFailed  *prom.CounterVec   // 下载失败数，按引擎和错误分类
+	downloadRetries *prom.CounterVec   // 下载重试次数
+	poolRunning     *prom.GaugeVec     // 协程池中正在执行的任务数
+	poolCapacity    *prom.GaugeVec     // 协程池容量
+}
+
+var _ imagecapture.Metrics = (*Metrics)(nil)
+
+// New 创建并注册指标，reg 为 nil 时注册到 prometheus.DefaultRegisterer
+func New(reg prom.Registerer) (*Metrics, error) {
+	if reg == nil {
+		reg = prom.DefaultRegisterer
+	}
+	m := &Metrics{
+		searchPages: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "search_page_duration_seconds",
+			Help:      "Latency of search page requests by engine and HTTP status (0 for transport errors).",
+			Buckets:   prom.DefBuckets,
+		}, []string{"engine", "status"}),
+		searchResults: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "search_results_total",
+			Help:      "Search results by engine and outcome (found, filtered, deduped).",
+		}, []string{"engine", "outcome"}),
+		downloads: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "download_duration_seconds",
+			Help:      "Duration of image downloads by engine and result (ok or error class).",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"engine", "result"}),
+		downloadBytes: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "download_bytes_total",
+			Help:      "Bytes of successfully downloaded images.",
+		}, []string{"engine"}),
+		downloadFailed: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "download_failures_total",
+			Help:      "Failed image downloads by engine and error class.",
+		}, []string{"engine", "class"}),
+		downloadRetries: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "download_retries_total",
+			Help:      "Retried image download requests.",
+		}, []string{"engine"}),
+		poolRunning: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pool_running",
+			Help:      "Running tasks of the worker pools.",
+		}, []string{"pool"}),
+		poolCapacity: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pool_capacity",
+			Help:      "Capacity of the worker pools.",
+		}, []string{"pool"}),
+	}
+	for _, c := range []prom.Collector{
+		m.searchPages, m.searchResults, m.downloads, m.downloadBytes,
+		m.downloadFailed, m.downloadRetries, m.poolRunning, m.poolCapacity,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) SearchPage(engine string, status int, elapsed time.Duration) {
+	m.searchPages.WithLabelValues(engine, strconv.Itoa(status)).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) SearchResults(engine, outcome string, n int) {
+	m.searchResults.WithLabelValues(engine, outcome).Add(float64(n))
+}
+
+func (m *Metrics) Download(engine string, bytes int64, elapsed time.Duration, errClass string) {
+	result := "ok"
+	if errClass != "" {
+		result = errClass
+		m.downloadFailed.WithLabelValues(engine, errClass).Inc()
+	} else {
+		m.downloadBytes.WithLabelValues(engine).Add(float64(bytes))
+	}
+	m.downloads.WithLabelValues(engine, result).Observe(elapsed.Seconds())
+}
+
+func (m *Metrics) DownloadRetry(engine string) {
+	m.downloadRetries.WithLabelValues(engine).Inc()
+}
+
+func (m *Metrics) PoolUsage(pool string, running, capacity int) {
+	m.poolRunning.WithLabelValues(pool).Set(float64(running))
+	m.poolCapacity.WithLabelValues(pool).Set(float64(capacity))
+}
@@ -0,0 +1,54 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prom.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SearchPage(imagecapture.EngineBing, 200, 100*time.Millisecond)
+	m.SearchPage(imagecapture.EngineBing, 0, time.Second)
+	m.SearchResults(imagecapture.EngineBing, imagecapture.ResultFound, 3)
+	m.SearchResults(imagecapture.EngineBing, imagecapture.ResultFiltered, 1)
+	m.Download(imagecapture.EngineBing, 1024, time.Second, "")
+	m.Download(imagecapture.EngineBing, 0, time.Second, imagecapture.ErrorClassHTTP4xx)
+	m.DownloadRetry(imagecapture.EngineBing)
+	m.PoolUsage(imagecapture.PoolDownload, 3, 8)
+
+	if got := testutil.ToFloat64(m.searchResults.WithLabelValues(imagecapture.EngineBing, imagecapture.ResultFound)); got != 3 {
+		t.Errorf("search results found = %v", got)
+	}
+	if got := testutil.ToFloat64(m.downloadBytes.WithLabelValues(imagecapture.EngineBing)); got != 1024 {
+		t.Errorf("download bytes = %v", got)
+	}
+	if got := testutil.ToFloat64(m.downloadFailed.WithLabelValues(imagecapture.EngineBing, imagecapture.ErrorClassHTTP4xx)); got != 1 {
+		t.Errorf("download failures = %v", got)
+	}
+	if got := testutil.ToFloat64(m.poolRunning.WithLabelValues(imagecapture.PoolDownload)); got != 3 {
+		t.Errorf("pool running = %v", got)
+	}
+	expected := `
+# HELP imagecapture_download_retries_total Retried image download requests.
+# TYPE imagecapture_download_retries_total counter
+imagecapture_download_retries_total{engine="bing"} 1
+`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "imagecapture_download_retries_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(m.searchPages); n != 2 {
+		t.Errorf("search page series = %d", n)
+	}
+	if _, err = New(reg); err == nil {
+		t.Error("New() should fail when the metrics are already registered")
+	}
+}
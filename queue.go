@@ -0,0 +1,617 @@
+package imagecapture
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/4 上午10:00
+* @Package: 异步下载队列
+ */
+
+// 队列中图片的状态
+const (
+	QueuePending  = "pending"  // 等待下载，包括等待重试的
+	QueueRunning  = "running"  // 正在下载
+	QueueDone     = "done"     // 下载成功
+	QueueDead     = "dead"     // 永久失败或重试次数用尽，可以通过 Retry 重新入队
+	QueueCanceled = "canceled" // 通过 Cancel 取消
+)
+
+// QueueItem 队列中的一张图片
+type QueueItem struct {
+	ID         string    `json:"id"`
+	Seq        int       `json:"seq"` // 入队序号，用作文件名模板中的 {index}
+	Result     Result    `json:"result"`
+	Priority   int       `json:"priority"` // 越大越先下载，相同时按入队顺序
+	State      string    `json:"state"`
+	Attempts   int       `json:"attempts"`        // 已经尝试下载的次数
+	Path       string    `json:"path,omitempty"`  // 下载成功时保存的路径
+	Error      string    `json:"error,omitempty"` // 最后一次失败的原因
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// QueueStats 各状态的图片数量
+type QueueStats struct {
+	Pending  int  `json:"pending"`
+	Running  int  `json:"running"`
+	Done     int  `json:"done"`
+	Dead     int  `json:"dead"`
+	Canceled int  `json:"canceled"`
+	Paused   bool `json:"paused"`
+}
+
+// QueueOption 队列配置
+type QueueOption func(*queueConfig)
+
+type queueConfig struct {
+	workers     int
+	file        string
+	maxAttempts int
+	backoff     time.Duration
+	download    []DownloadOption
+}
+
+// WithQueueWorkers 同时下载的图片数，默认 8
+func WithQueueWorkers(n int) QueueOption {
+	return func(cfg *queueConfig) {
+		if n > 0 {
+			cfg.workers = n
+		}
+	}
+}
+
+// WithQueueFile 将队列持久化到文件，重启后未完成的图片继续下载，死信也会保留。不设置时只保存在内存中
+func WithQueueFile(filename string) QueueOption {
+	return func(cfg *queueConfig) {
+		cfg.file = filename
+	}
+}
+
+// WithMaxAttempts 临时失败（超时、网络错误、5xx、429）时最多尝试的次数，用尽后进入死信，默认 3
+func WithMaxAttempts(n int) QueueOption {
+	return func(cfg *queueConfig) {
+		if n > 0 {
+			cfg.maxAttempts = n
+		}
+	}
+}
+
+// WithRetryBackoff 重试前的等待时间，第 n 次失败后等待 n 倍，默认 1 秒
+func WithRetryBackoff(d time.Duration) QueueOption {
+	return func(cfg *queueConfig) {
+		if d >= 0 {
+			cfg.backoff = d
+		}
+	}
+}
+
+// WithQueueDownloadOptions 下载时使用的选项，例如文件名模板、清单、归档
+func WithQueueDownloadOptions(opts ...DownloadOption) QueueOption {
+	return func(cfg *queueConfig) {
+		cfg.download = append(cfg.download, opts...)
+	}
+}
+
+// Queue 异步下载队列：按优先级调度，使用 ants 协程池下载，临时失败自动重试，
+// 永久失败（4xx、目标已存在等）进入死信，支持暂停、恢复和取消。方法可并发调用
+type Queue struct {
+	cfg      queueConfig
+	d        *downloader
+	dir      string
+	name     *nameTemplate
+	download downloadConfig
+	pool     *ants.Pool
+	log      *queueLog // 未设置 WithQueueFile 时为 nil
+
+	mu      sync.Mutex
+	cond    *sync.Cond // 队列状态变化时广播
+	items   map[string]*QueueItem
+	pending queueHeap
+	gens    map[string]int // 每次入堆加一，堆中代数不一致的是过期的记录
+	running map[string]context.CancelFunc
+	timers  map[string]*time.Timer // 等待重试的图片
+	counts  map[string]int
+	seq     int
+	paused  bool
+	closed  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewQueue 创建下载队列，图片保存到 dir，下载器使用 NewBaiduCapture、NewBingCapture 创建的采集器。
+// 设置了 WithQueueFile 时会恢复文件中未完成的图片，上次中断时正在下载的图片会重新下载
+func NewQueue(d Downloader, dir string, opts ...QueueOption) (*Queue, error) {
+	base, ok := baseDownloader(d)
+	if !ok {
+		return nil, fmt.Errorf("unsupported downloader %T", d)
+	}
+	cfg := queueConfig{workers: maxDownloadRoutines, maxAttempts: 3, backoff: time.Second}
+	for _, option := range opts {
+		option(&cfg)
+	}
+	q := &Queue{
+		cfg:      cfg,
+		d:        base,
+		dir:      dir,
+		download: newDownloadConfig(cfg.download),
+		items:    make(map[string]*QueueItem),
+		gens:     make(map[string]int),
+		running:  make(map[string]context.CancelFunc),
+		timers:   make(map[string]*time.Timer),
+		counts:   make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	var err error
+	if q.name, err = q.download.nameTemplate(false); err != nil {
+		return nil, err
+	}
+	if cfg.file != "" {
+		var items []*QueueItem
+		if q.log, items, err = openQueueLog(cfg.file); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Seq >= q.seq {
+				q.seq = item.Seq + 1
+			}
+			q.items[item.ID] = item
+			q.counts[item.State]++
+			if item.State == QueuePending {
+				q.push(item)
+			}
+		}
+	}
+	if q.pool, err = ants.NewPool(cfg.workers); err != nil {
+		return nil, err
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.wg.Add(1)
+	go q.dispatch()
+	return q, nil
+}
+
+// 采集器内置的下载器
+func baseDownloader(d Downloader) (*downloader, bool) {
+	switch v := d.(type) {
+	case *downloader:
+		return v, true
+	case *BaiduCapture:
+		return baseDownloader(v.Downloader)
+	case *BingCapture:
+		return baseDownloader(v.Downloader)
+	}
+	return nil, false
+}
+
+// Enqueue 将搜索结果加入队列，priority 越大越先下载，返回每张图片在队列中的 id
+func (q *Queue) Enqueue(priority int, results ...Result) ([]string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	ids := make([]string, 0, len(results))
+	now := time.Now()
+	for _, r := range results {
+		id, err := GenerateUUID()
+		if err != nil {
+			return ids, err
+		}
+		item := &QueueItem{ID: id, Seq: q.seq, Result: r, Priority: priority, EnqueuedAt: now}
+		q.items[id] = item
+		if err = q.setState(item, QueuePending); err != nil {
+			return ids, err
+		}
+		q.seq++
+		q.push(item)
+		ids = append(ids, id)
+	}
+	q.cond.Broadcast()
+	return ids, nil
+}
+
+// EnqueueURLs 将图片地址加入队列，用法同 Enqueue
+func (q *Queue) EnqueueURLs(priority int, urls ...string) ([]string, error) {
+	results := make([]Result, len(urls))
+	for i, url := range urls {
+		results[i].URL = url
+	}
+	return q.Enqueue(priority, results...)
+}
+
+// Pause 暂停调度，正在下载的图片会继续完成
+func (q *Queue) Pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = true
+}
+
+// Resume 恢复调度
+func (q *Queue) Resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = false
+	q.cond.Broadcast()
+}
+
+// Cancel 取消等待中或正在下载的图片，返回实际取消的数量
+func (q *Queue) Cancel(ids ...string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, id := range ids {
+		item, ok := q.items[id]
+		if !ok {
+			continue
+		}
+		switch item.State {
+		case QueuePending:
+			if timer, ok := q.timers[id]; ok {
+				timer.Stop()
+				delete(q.timers, id)
+			}
+			q.setState(item, QueueCanceled)
+			n++
+		case QueueRunning:
+			// 下载协程结束时标记为取消
+			q.running[id]()
+			n++
+		}
+	}
+	q.cond.Broadcast()
+	return n
+}
+
+// Retry 将死信或已取消的图片重新入队，尝试次数清零，返回实际入队的数量
+func (q *Queue) Retry(ids ...string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+	n := 0
+	for _, id := range ids {
+		item, ok := q.items[id]
+		if !ok || (item.State != QueueDead && item.State != QueueCanceled) {
+			continue
+		}
+		item.Attempts, item.Error = 0, ""
+		if err := q.setState(item, QueuePending); err != nil {
+			return n, err
+		}
+		q.push(item)
+		n++
+	}
+	q.cond.Broadcast()
+	return n, nil
+}
+
+// Item 返回图片的当前状态
+func (q *Queue) Item(id string) (QueueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[id]
+	if !ok {
+		return QueueItem{}, false
+	}
+	return *item, true
+}
+
+// DeadLetters 返回所有死信，按入队顺序
+func (q *Queue) DeadLetters() []QueueItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var items []QueueItem
+	for _, item := range q.items {
+		if item.State == QueueDead {
+			items = append(items, *item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Seq < items[j].Seq
+	})
+	return items
+}
+
+// Stats 返回各状态的图片数量
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QueueStats{
+		Pending:  q.counts[QueuePending],
+		Running:  q.counts[QueueRunning],
+		Done:     q.counts[QueueDone],
+		Dead:     q.counts[QueueDead],
+		Canceled: q.counts[QueueCanceled],
+		Paused:   q.paused,
+	}
+}
+
+// Wait 等待队列中没有等待和正在下载的图片。队列暂停时会一直等待，直到恢复或 ctx 结束
+func (q *Queue) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		case <-done:
+		}
+	}()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.counts[QueuePending]+q.counts[QueueRunning] > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if q.closed {
+			return ErrQueueClosed
+		}
+		q.cond.Wait()
+	}
+	return nil
+}
+
+// Close 停止调度并中止正在下载的图片，等待下载协程退出。
+// 持久化时被中止和等待中的图片保留在文件中，下次 NewQueue 时继续下载
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	for id, timer := range q.timers {
+		timer.Stop()
+		delete(q.timers, id)
+	}
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.cancel()
+	q.wg.Wait()
+	q.pool.Release()
+	if q.log != nil {
+		return q.log.close()
+	}
+	return nil
+}
+
+// 修改状态并写入持久化文件，调用方需持有锁
+func (q *Queue) setState(item *QueueItem, state string) error {
+	if item.State != "" {
+		q.counts[item.State]--
+	}
+	item.State, item.UpdatedAt = state, time.Now()
+	q.counts[state]++
+	if q.log != nil {
+		return q.log.write(item)
+	}
+	return nil
+}
+
+// 放入优先级堆，调用方需持有锁
+func (q *Queue) push(item *QueueItem) {
+	q.gens[item.ID]++
+	heap.Push(&q.pending, queueEntry{item: item, gen: q.gens[item.ID]})
+}
+
+// 调度协程：未暂停且有空闲协程时取出优先级最高的图片交给协程池
+func (q *Queue) dispatch() {
+	defer q.wg.Done()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for !q.closed && (q.paused || q.pending.Len() == 0 || len(q.running) >= q.cfg.workers) {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return
+		}
+		entry := heap.Pop(&q.pending).(queueEntry)
+		item := entry.item
+		if entry.gen != q.gens[item.ID] || item.State != QueuePending {
+			continue
+		}
+		ctx, cancel := context.WithCancel(q.ctx)
+		q.running[item.ID] = cancel
+		item.Attempts++
+		q.setState(item, QueueRunning)
+		snapshot := *item
+		q.wg.Add(1)
+		if err := q.pool.Submit(func() {
+			defer q.wg.Done()
+			q.run(ctx, snapshot)
+		}); err != nil {
+			q.wg.Done()
+			q.finish(ctx, snapshot.ID, DownloadReport{URL: snapshot.Result.URL, Err: err})
+		}
+	}
+}
+
+// 下载一张图片
+func (q *Queue) run(ctx context.Context, item QueueItem) {
+	report := q.d.downloadOne(ctx, item.Result.URL, q.dir, item.Result, item.Seq, q.name, q.download, time.Since(item.UpdatedAt))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.finish(ctx, item.ID, report)
+}
+
+// 按下载结果更新状态，调用方需持有锁
+func (q *Queue) finish(ctx context.Context, id string, report DownloadReport) {
+	// 先判断是否被取消，再释放 ctx
+	aborted := ctx.Err() != nil
+	q.running[id]()
+	delete(q.running, id)
+	item := q.items[id]
+	defer q.cond.Broadcast()
+	if report.Err == nil {
+		item.Path, item.Error = report.Path, ""
+		q.setState(item, QueueDone)
+		return
+	}
+	item.Error = report.Err.Error()
+	switch {
+	case aborted && q.closed:
+		// 队列关闭导致的中止不计入尝试次数，下次打开时继续下载
+		item.Attempts--
+		q.setState(item, QueuePending)
+	case aborted:
+		q.setState(item, QueueCanceled)
+	case isPermanent(report.Err) || item.Attempts >= q.cfg.maxAttempts:
+		q.setState(item, QueueDead)
+	default:
+		q.setState(item, QueuePending)
+		q.timers[id] = time.AfterFunc(time.Duration(item.Attempts)*q.cfg.backoff, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			delete(q.timers, id)
+			if q.closed || item.State != QueuePending {
+				return
+			}
+			q.push(item)
+			q.cond.Broadcast()
+		})
+	}
+}
+
+// 重试也不会成功的错误：除 408、429 外的 4xx，返回的不是图片，以及目标文件已存在等本地错误
+func isPermanent(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 400 && se.StatusCode < 500 &&
+			se.StatusCode != http.StatusRequestTimeout && se.StatusCode != http.StatusTooManyRequests
+	}
+	return errors.Is(err, ErrFileAlreadyExists) || errors.Is(err, ErrInvalidTargetPath) || errors.Is(err, ErrUnsupportedFileType)
+}
+
+// 堆中的一条记录
+type queueEntry struct {
+	item *QueueItem
+	gen  int
+}
+
+// 按优先级从高到低，相同优先级按入队顺序
+type queueHeap []queueEntry
+
+func (h queueHeap) Len() int { return len(h) }
+func (h queueHeap) Less(i, j int) bool {
+	if h[i].item.Priority != h[j].item.Priority {
+		return h[i].item.Priority > h[j].item.Priority
+	}
+	return h[i].item.Seq < h[j].item.Seq
+}
+func (h queueHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *queueHeap) Push(x interface{}) { *h = append(*h, x.(queueEntry)) }
+func (h *queueHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// queueLog 追加写入的队列日志，每次状态变化写一行完整的 QueueItem，回放时同一 id 以最后一行为准
+type queueLog struct {
+	file *os.File
+}
+
+// 打开队列日志并回放，正在下载的图片恢复为等待。回放后重写文件，只保留等待中的图片和死信
+func openQueueLog(filename string) (*queueLog, []*QueueItem, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	items := make(map[string]*QueueItem)
+	var order []string
+	maxSeq := -1
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break // 进程中断时未写完的最后一行
+		}
+		item := &QueueItem{}
+		if err = json.Unmarshal(data[:end], item); err != nil {
+			return nil, nil, fmt.Errorf("corrupted queue file %s: %w", filename, err)
+		}
+		if _, ok := items[item.ID]; !ok {
+			order = append(order, item.ID)
+		}
+		items[item.ID] = item
+		if item.Seq > maxSeq {
+			maxSeq = item.Seq
+		}
+		data = data[end+1:]
+	}
+	var kept []*QueueItem
+	var buf bytes.Buffer
+	for _, id := range order {
+		item := items[id]
+		if item.State == QueueRunning {
+			item.State = QueuePending
+		}
+		if item.State != QueuePending && item.State != QueueDead {
+			continue
+		}
+		line, err := json.Marshal(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		buf.Write(append(line, '\n'))
+		kept = append(kept, item)
+	}
+	// 已完成的图片不再保留，但序号不能重复使用，保留一条占位记录
+	if maxSeq >= 0 && (len(kept) == 0 || kept[len(kept)-1].Seq != maxSeq) {
+		for _, item := range items {
+			if item.Seq == maxSeq && item.State != QueuePending && item.State != QueueDead {
+				line, _ := json.Marshal(item)
+				buf.Write(append(line, '\n'))
+				kept = append(kept, item)
+				break
+			}
+		}
+	}
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return nil, nil, err
+	}
+	if err = os.Rename(tmp, filename); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &queueLog{file: file}, kept, nil
+}
+
+func (l *queueLog) write(item *QueueItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+func (l *queueLog) close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,224 @@
+package imagecapture
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 图片服务：/ok/* 返回图片，/missing/* 返回 404，/error/* 返回 500，/html/* 返回网页，/slow/* 等待 release 关闭后返回图片。
+// 返回按顺序记录的请求路径
+func newQueueServer(t *testing.T) (server *httptest.Server, paths func() []string, release func()) {
+	var (
+		mu        sync.Mutex
+		requested []string
+		once      sync.Once
+		block     = make(chan struct{})
+	)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/missing/"):
+			http.NotFound(w, r)
+		case strings.HasPrefix(r.URL.Path, "/error/"):
+			http.Error(w, "error", http.StatusInternalServerError)
+		case strings.HasPrefix(r.URL.Path, "/html/"):
+			w.Write([]byte("<html><body>hotlink</body></html>"))
+		case strings.HasPrefix(r.URL.Path, "/slow/"):
+			select {
+			case <-block:
+			case <-r.Context().Done():
+				return
+			}
+			w.Write(testPNG)
+		default:
+			w.Write(testPNG)
+		}
+	}))
+	release = func() { once.Do(func() { close(block) }) }
+	t.Cleanup(func() {
+		release()
+		server.Close()
+	})
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}, release
+}
+
+func waitQueue(t *testing.T, q *Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := q.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v, stats = %+v", err, q.Stats())
+	}
+}
+
+func TestQueue_Priority(t *testing.T) {
+	server, paths, _ := newQueueServer(t)
+	q, err := NewQueue(NewBingCapture(1), t.TempDir(), WithQueueWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.Pause()
+	q.EnqueueURLs(0, server.URL+"/ok/low1", server.URL+"/ok/low2")
+	q.EnqueueURLs(10, server.URL+"/ok/high")
+	q.EnqueueURLs(5, server.URL+"/ok/middle")
+	if stats := q.Stats(); stats.Pending != 4 || !stats.Paused {
+		t.Fatalf("paused stats = %+v", stats)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(paths()) != 0 {
+		t.Fatalf("paused queue downloaded %v", paths())
+	}
+	q.Resume()
+	waitQueue(t, q)
+	want := []string{"/ok/high", "/ok/middle", "/ok/low1", "/ok/low2"}
+	if got := paths(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("download order = %v, want %v", got, want)
+	}
+	if stats := q.Stats(); stats.Done != 4 || stats.Pending != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestQueue_DeadLetter(t *testing.T) {
+	server, paths, _ := newQueueServer(t)
+	q, err := NewQueue(NewBingCapture(1), t.TempDir(), WithMaxAttempts(3), WithRetryBackoff(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	ids, _ := q.EnqueueURLs(0, server.URL+"/missing/a", server.URL+"/error/b", server.URL+"/ok/c", server.URL+"/html/d")
+	waitQueue(t, q)
+
+	dead := q.DeadLetters()
+	if len(dead) != 3 || dead[0].ID != ids[0] || dead[1].ID != ids[1] || dead[2].ID != ids[3] {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+	// 404 和非图片内容是永久失败，不重试；500 重试到次数用尽
+	if dead[0].Attempts != 1 || dead[1].Attempts != 3 || dead[1].Error == "" || dead[2].Attempts != 1 {
+		t.Errorf("dead letter attempts = %d, %d, %d", dead[0].Attempts, dead[1].Attempts, dead[2].Attempts)
+	}
+	if item, _ := q.Item(ids[2]); item.State != QueueDone || item.Path == "" {
+		t.Errorf("item = %+v", item)
+	}
+	count := func(path string) int {
+		n := 0
+		for _, p := range paths() {
+			if p == path {
+				n++
+			}
+		}
+		return n
+	}
+	// 下载器内部对每次请求的网络错误也会重试，这里只统计队列层面的尝试
+	if count("/missing/a") != 1 || count("/error/b") != 3 {
+		t.Errorf("requests = %v", paths())
+	}
+
+	if n, err := q.Retry(ids[0], ids[2]); n != 1 || err != nil {
+		t.Errorf("Retry() = %d, %v", n, err)
+	}
+	waitQueue(t, q)
+	if item, _ := q.Item(ids[0]); item.State != QueueDead || item.Attempts != 1 {
+		t.Errorf("retried item = %+v", item)
+	}
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	server, _, _ := newQueueServer(t)
+	q, err := NewQueue(NewBingCapture(1), t.TempDir(), WithQueueWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	ids, _ := q.EnqueueURLs(0, server.URL+"/slow/a", server.URL+"/ok/b")
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Running == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := q.Cancel(ids...); n != 2 {
+		t.Errorf("Cancel() = %d, want 2", n)
+	}
+	waitQueue(t, q)
+	for _, id := range ids {
+		if item, _ := q.Item(id); item.State != QueueCanceled {
+			t.Errorf("item = %+v", item)
+		}
+	}
+}
+
+func TestQueue_Persistence(t *testing.T) {
+	server, _, release := newQueueServer(t)
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "queue.jsonl")
+	q, err := NewQueue(NewBingCapture(1), dir, WithQueueFile(filename), WithQueueWorkers(1),
+		WithQueueDownloadOptions(WithFilenameTemplate("{index:2}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _ := q.EnqueueURLs(0, server.URL+"/ok/a", server.URL+"/missing/b")
+	waitQueue(t, q)
+	slow, _ := q.EnqueueURLs(0, server.URL+"/slow/c", server.URL+"/ok/d")
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Stats().Running == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = q.EnqueueURLs(0, server.URL+"/ok/e"); err != ErrQueueClosed {
+		t.Errorf("Enqueue() after Close error = %v", err)
+	}
+
+	// 重新打开后继续下载中断的图片，死信保留，已完成的图片不再保留
+	release()
+	q, err = NewQueue(NewBingCapture(1), dir, WithQueueFile(filename), WithQueueWorkers(1),
+		WithQueueDownloadOptions(WithFilenameTemplate("{index:2}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if item, ok := q.Item(slow[0]); !ok || item.State != QueuePending || item.Attempts != 0 {
+		t.Fatalf("restored item = %+v", item)
+	}
+	if _, ok := q.Item(ids[0]); ok {
+		t.Error("done item was restored")
+	}
+	if dead := q.DeadLetters(); len(dead) != 1 || dead[0].ID != ids[1] {
+		t.Errorf("dead letters = %+v", dead)
+	}
+	waitQueue(t, q)
+	for i, id := range slow {
+		item, _ := q.Item(id)
+		if item.State != QueueDone || item.Path != filepath.Join(dir, []string{"02.png", "03.png"}[i]) {
+			t.Errorf("item = %+v", item)
+		}
+	}
+	// 新入队的图片序号不会与已完成的重复
+	more, _ := q.EnqueueURLs(0, server.URL+"/ok/e")
+	waitQueue(t, q)
+	if item, _ := q.Item(more[0]); item.Seq != 4 {
+		t.Errorf("new item seq = %d, want 4", item.Seq)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "00.png")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewQueue_UnsupportedDownloader(t *testing.T) {
+	if _, err := NewQueue(struct{ Downloader }{}, t.TempDir()); err == nil {
+		t.Error("NewQueue() with a custom downloader should fail")
+	}
+}
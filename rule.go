@@ -0,0 +1,261 @@
+package imagecapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/12 上午10:20
+* @Package:
+ */
+
+// 默认过滤的图片来源，可通过 WithoutDefaultRules 关闭
+var defaultFilterRules = []FilterRule{RULE_DOUYIN, RULES_SINA}
+
+// FilterRule 来源过滤规则，命中规则时返回 true
+type FilterRule interface {
+	Check(rawURL string) bool
+}
+
+// Rule 按域名后缀匹配，douyin.com 匹配 douyin.com 及其子域名，不匹配 notdouyin.com
+type Rule []string
+
+// 规则校验
+func (r Rule) Check(rawURL string) bool {
+	// 解析 URL
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false // URL 解析失败
+	}
+	// 获取域名部分
+	host := strings.ToLower(u.Hostname())
+	// 判断域名是否属于规则内的来源
+	for _, rule := range r {
+		rule = strings.TrimPrefix(strings.ToLower(rule), ".")
+		if host == rule || strings.HasSuffix(host, "."+rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllRule 同时命中所有规则时才算命中，规则文件中同一条规则的 hosts、paths、regexps 按此组合
+type AllRule []FilterRule
+
+func (r AllRule) Check(rawURL string) bool {
+	for _, rule := range r {
+		if !rule.Check(rawURL) {
+			return false
+		}
+	}
+	return len(r) > 0
+}
+
+// PathRule 按路径前缀匹配
+type PathRule []string
+
+func (r PathRule) Check(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, rule := range r {
+		if strings.HasPrefix(u.Path, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegexpRule 按正则匹配完整 URL
+type RegexpRule []*regexp.Regexp
+
+// NewRegexpRule 编译正则规则
+func NewRegexpRule(patterns ...string) (RegexpRule, error) {
+	rule := make(RegexpRule, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule pattern %q: %w", pattern, err)
+		}
+		rule = append(rule, re)
+	}
+	return rule, nil
+}
+
+func (r RegexpRule) Check(rawURL string) bool {
+	for _, re := range r {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	//  抖音源
+	RULE_DOUYIN = Rule{
+		"douyin.com",
+		"douyinpic.com",
+		"ixigua.com",
+		"snssdk.com",
+		"amemv.com",
+		"tiktok.com",
+	}
+	// 新浪源
+	RULES_SINA = Rule{
+		"sinaimg.cn",
+		"sinajs.cn",
+		"sina.com.cn",
+		"vip.sina.com",
+	}
+)
+
+/*
+过滤模式
+*/
+type FilterMode string
+
+const (
+	FilterDeny  FilterMode = "deny"  // 黑名单：命中规则的图片被过滤
+	FilterAllow FilterMode = "allow" // 白名单：只保留命中规则的图片
+)
+
+// RuleSet 一组同模式的过滤规则
+type RuleSet struct {
+	Mode  FilterMode
+	Rules []FilterRule
+}
+
+// 规则文件格式，支持 yaml 和 json
+//
+// 同一条规则中 hosts、paths、regexps 需要同时命中，每项内部的多个值命中任意一个即可
+//
+//	mode: deny
+//	rules:
+//	  - name: douyin
+//	    hosts: [douyin.com, douyinpic.com]
+//	    paths: [/aweme/]
+//	    regexps: ['\.webp$']
+type ruleFile struct {
+	Mode  FilterMode `json:"mode" yaml:"mode"`
+	Rules []ruleSpec `json:"rules" yaml:"rules"`
+}
+
+type ruleSpec struct {
+	Name    string   `json:"name" yaml:"name"`
+	Hosts   []string `json:"hosts" yaml:"hosts"`
+	Paths   []string `json:"paths" yaml:"paths"`
+	Regexps []string `json:"regexps" yaml:"regexps"`
+}
+
+// LoadRuleSet 从 yaml/json 文件加载过滤规则，根据文件后缀判断格式
+func LoadRuleSet(filename string) (*RuleSet, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var rf ruleFile
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = json.Unmarshal(data, &rf)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &rf)
+	default:
+		return nil, fmt.Errorf("unsupported rule file format: %s", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rule file %s: %w", filename, err)
+	}
+	return rf.build()
+}
+
+func (rf ruleFile) build() (*RuleSet, error) {
+	rs := &RuleSet{Mode: rf.Mode}
+	switch rs.Mode {
+	case "":
+		rs.Mode = FilterDeny
+	case FilterDeny, FilterAllow:
+	default:
+		return nil, fmt.Errorf("unknown filter mode: %s", rf.Mode)
+	}
+	for _, spec := range rf.Rules {
+		var conditions AllRule
+		if len(spec.Hosts) > 0 {
+			hosts := make(Rule, 0, len(spec.Hosts))
+			for _, host := range spec.Hosts {
+				hosts = append(hosts, strings.ToLower(host))
+			}
+			conditions = append(conditions, hosts)
+		}
+		if len(spec.Paths) > 0 {
+			conditions = append(conditions, PathRule(spec.Paths))
+		}
+		if len(spec.Regexps) > 0 {
+			rule, err := NewRegexpRule(spec.Regexps...)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", spec.Name, err)
+			}
+			conditions = append(conditions, rule)
+		}
+		switch len(conditions) {
+		case 0:
+		case 1:
+			rs.Rules = append(rs.Rules, conditions[0])
+		default:
+			rs.Rules = append(rs.Rules, conditions)
+		}
+	}
+	return rs, nil
+}
+
+// 规则过滤器，每次搜索独立持有
+type ruleFilter struct {
+	noDefault bool
+	deny      []FilterRule
+	allow     []FilterRule
+}
+
+func (rf ruleFilter) clone() ruleFilter {
+	return ruleFilter{
+		noDefault: rf.noDefault,
+		deny:      append([]FilterRule(nil), rf.deny...),
+		allow:     append([]FilterRule(nil), rf.allow...),
+	}
+}
+
+// 判断图片来源是否保留
+func (rf ruleFilter) accept(rawURL string) bool {
+	if !rf.noDefault {
+		for _, rule := range defaultFilterRules {
+			if rule.Check(rawURL) {
+				return false
+			}
+		}
+	}
+	for _, rule := range rf.deny {
+		if rule.Check(rawURL) {
+			return false
+		}
+	}
+	// 未设置白名单则全部保留
+	if len(rf.allow) == 0 {
+		return true
+	}
+	for _, rule := range rf.allow {
+		if rule.Check(rawURL) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package imagecapture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/12 下午2:10
+* @Package:
+ */
+
+func Test_ruleFilter_accept(t *testing.T) {
+	regexpRule, err := NewRegexpRule(`\.webp$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		opts   []Option
+		rawURL string
+		want   bool
+	}{
+		{
+			name:   "default rules deny douyin",
+			rawURL: "https://p3.douyinpic.com/a.jpg",
+			want:   false,
+		},
+		{
+			name:   "without default rules",
+			opts:   []Option{WithoutDefaultRules()},
+			rawURL: "https://p3.douyinpic.com/a.jpg",
+			want:   true,
+		},
+		{
+			name:   "deny by host",
+			opts:   []Option{WithFilterRules(Rule{"example.com"})},
+			rawURL: "https://img.EXAMPLE.com/a.jpg",
+			want:   false,
+		},
+		{
+			name:   "host suffix needs a dot boundary",
+			opts:   []Option{WithFilterRules(Rule{"douyin.com"}), WithoutDefaultRules()},
+			rawURL: "https://notdouyin.com/a.jpg",
+			want:   true,
+		},
+		{
+			name:   "deny by path",
+			opts:   []Option{WithFilterRules(PathRule{"/thumb/"})},
+			rawURL: "https://img.example.com/thumb/a.jpg",
+			want:   false,
+		},
+		{
+			name:   "deny by regexp",
+			opts:   []Option{WithFilterRules(regexpRule)},
+			rawURL: "https://img.example.com/a.webp",
+			want:   false,
+		},
+		{
+			name:   "allow list hit",
+			opts:   []Option{WithAllowRules(Rule{"example.com"})},
+			rawURL: "https://img.example.com/a.jpg",
+			want:   true,
+		},
+		{
+			name:   "allow list miss",
+			opts:   []Option{WithAllowRules(Rule{"example.com"})},
+			rawURL: "https://img.other.com/a.jpg",
+			want:   false,
+		},
+		{
+			name:   "deny wins over allow",
+			opts:   []Option{WithAllowRules(Rule{"example.com"}), WithFilterRules(PathRule{"/private"})},
+			rawURL: "https://img.example.com/private/a.jpg",
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQuery()
+			for _, option := range tt.opts {
+				option(&q)
+			}
+			if got := q.rules.accept(tt.rawURL); got != tt.want {
+				t.Errorf("accept() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRuleSet(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		wantMode FilterMode
+		wantLen  int
+		match    []string // 命中第一条规则的地址
+		miss     []string // 不命中第一条规则的地址
+		wantErr  bool
+	}{
+		{
+			name:     "yaml",
+			filename: "rules.yaml",
+			content:  "mode: allow\nrules:\n  - name: example\n    hosts: [example.com]\n    paths: [/img/]\n",
+			wantMode: FilterAllow,
+			wantLen:  1,
+			// 同一条规则的 hosts 和 paths 需要同时命中
+			match: []string{"https://cdn.example.com/img/a.jpg"},
+			miss:  []string{"https://other.com/img/a.jpg", "https://example.com/video/a.jpg", "https://notexample.com/img/a.jpg"},
+		},
+		{
+			name:     "json",
+			filename: "rules.json",
+			content:  `{"rules":[{"name":"webp","regexps":["\\.webp$"]}]}`,
+			wantMode: FilterDeny,
+			wantLen:  1,
+		},
+		{
+			name:     "bad mode",
+			filename: "bad.yml",
+			content:  "mode: maybe\n",
+			wantErr:  true,
+		},
+		{
+			name:     "bad regexp",
+			filename: "bad.json",
+			content:  `{"rules":[{"regexps":["("]}]}`,
+			wantErr:  true,
+		},
+		{
+			name:     "unknown format",
+			filename: "rules.txt",
+			content:  "",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.filename)
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadRuleSet(filename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadRuleSet() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Mode != tt.wantMode || len(got.Rules) != tt.wantLen {
+				t.Errorf("LoadRuleSet() got mode %v with %d rules, want %v with %d", got.Mode, len(got.Rules), tt.wantMode, tt.wantLen)
+				return
+			}
+			for _, u := range tt.match {
+				if !got.Rules[0].Check(u) {
+					t.Errorf("Check(%s) = false, want true", u)
+				}
+			}
+			for _, u := range tt.miss {
+				if got.Rules[0].Check(u) {
+					t.Errorf("Check(%s) = true, want false", u)
+				}
+			}
+		})
+	}
+}
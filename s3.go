@@ -0,0 +1,244 @@
+package imagecapture
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/25 下午2:00
+* @Package:
+ */
+
+// S3Config S3 兼容对象存储（AWS S3、MinIO 等）的连接配置
+type S3Config struct {
+	Endpoint  string // 例如 https://s3.us-east-1.amazonaws.com、http://127.0.0.1:9000
+	Region    string // 默认 us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string       // 所有 key 的前缀，例如 datasets/tiger
+	PathStyle bool         // 使用 <endpoint>/<bucket>/<key> 形式的地址，MinIO 需要开启
+	Client    *http.Client // 默认 http.DefaultClient
+	// 没有 s3:ListBucket 权限时，HEAD 不存在的对象返回 403 而不是 404。
+	// 开启后 HEAD 返回 403 视为对象不存在，否则 Exists 返回错误（Overwrite_SKIP 等覆盖策略需要 Exists）
+	ForbiddenAsMissing bool
+}
+
+// S3Sink 把图片上传到 S3 兼容的对象存储。图片先写入内存，Commit 时以 PUT 上传
+type S3Sink struct {
+	cfg      S3Config
+	endpoint *url.URL
+	now      func() time.Time // 测试时替换
+}
+
+// NewS3Sink 创建 S3 存储
+func NewS3Sink(cfg S3Config) (*S3Sink, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: missing bucket")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &S3Sink{cfg: cfg, endpoint: endpoint, now: time.Now}, nil
+}
+
+// 对象地址，path style 为 <endpoint>/<bucket>/<key>，否则为 <bucket>.<host>/<key>
+func (s *S3Sink) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	key = path.Join(s.cfg.Prefix, key)
+	objectPath := "/" + key
+	if s.cfg.PathStyle {
+		objectPath = "/" + s.cfg.Bucket + objectPath
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + awsEscapePath(objectPath)
+	return &u
+}
+
+func (s *S3Sink) Create(ctx context.Context, key string) (SinkObject, error) {
+	return &s3Object{sink: s, ctx: ctx}, nil
+}
+
+func (s *S3Sink) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusForbidden:
+		if s.cfg.ForbiddenAsMissing {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("s3: head %s failed: %s", key, resp.Status)
+}
+
+// 发送签名后的请求
+func (s *S3Sink) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	signV4(req, body, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, "s3", s.now())
+	return s.cfg.Client.Do(req)
+}
+
+type s3Object struct {
+	sink *S3Sink
+	ctx  context.Context
+	buf  bytes.Buffer
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+func (o *s3Object) Commit(key string) error {
+	header := make(http.Header)
+	if ty := mime.TypeByExtension(path.Ext(key)); ty != "" {
+		header.Set("Content-Type", ty)
+	}
+	resp, err := o.sink.do(o.ctx, http.MethodPut, key, o.buf.Bytes(), header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3: put %s failed: %s %s", key, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (o *s3Object) Abort() error {
+	o.buf.Reset()
+	return nil
+}
+
+// AWS Signature Version 4 签名，签名范围为 Host 和请求中已设置的所有头
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// 按 AWS 的规则编码路径，保留 /
+func awsEscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// 除 A-Z a-z 0-9 - _ . ~ 外全部编码
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
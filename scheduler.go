@@ -0,0 +1,113 @@
+package imagecapture
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/5 上午10:00
+* @Package: 批量下载的域名调度
+ */
+
+// 每个域名默认同时下载的图片数
+const defaultHostConcurrency = 4
+
+// 批量下载中的一张图片
+type scheduledURL struct {
+	pos      int // 在 urls 中的位置
+	url      string
+	priority int
+}
+
+// 同一个域名的待下载图片
+type hostQueue struct {
+	host    string
+	items   []scheduledURL // 按优先级从高到低，相同时按位置
+	running int
+}
+
+// hostScheduler 按域名分组调度：每个域名同时下载的图片数不超过 perHost，
+// 每次取可下载的图片中优先级最高的，优先级相同时在域名之间轮转。不是并发安全的
+type hostScheduler struct {
+	perHost   int // <= 0 不限制
+	hosts     map[string]*hostQueue
+	order     []*hostQueue // 按域名首次出现的顺序轮转
+	cursor    int
+	remaining int
+}
+
+func newHostScheduler(urls []string, priority func(pos int, url string) int, perHost int) *hostScheduler {
+	s := &hostScheduler{perHost: perHost, hosts: make(map[string]*hostQueue), remaining: len(urls)}
+	for pos, u := range urls {
+		host := hostOf(u)
+		hq, ok := s.hosts[host]
+		if !ok {
+			hq = &hostQueue{host: host}
+			s.hosts[host] = hq
+			s.order = append(s.order, hq)
+		}
+		item := scheduledURL{pos: pos, url: u}
+		if priority != nil {
+			item.priority = priority(pos, u)
+		}
+		hq.items = append(hq.items, item)
+	}
+	for _, hq := range s.order {
+		items := hq.items
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].priority > items[j].priority
+		})
+	}
+	return s
+}
+
+// 图片地址的域名，解析失败时为空，这些地址归为一组
+func hostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// next 取出下一张可以开始下载的图片，所有域名都没有空闲名额或没有剩余图片时 ok 为 false
+func (s *hostScheduler) next() (item scheduledURL, host string, ok bool) {
+	var best *hostQueue
+	bestIndex := 0
+	for i := 0; i < len(s.order); i++ {
+		index := (s.cursor + i) % len(s.order)
+		hq := s.order[index]
+		if len(hq.items) == 0 || (s.perHost > 0 && hq.running >= s.perHost) {
+			continue
+		}
+		// 从游标开始第一个遇到的优先，实现同优先级的轮转
+		if best == nil || hq.items[0].priority > best.items[0].priority {
+			best, bestIndex = hq, index
+		}
+	}
+	if best == nil {
+		return scheduledURL{}, "", false
+	}
+	item = best.items[0]
+	best.items = best.items[1:]
+	best.running++
+	s.remaining--
+	s.cursor = (bestIndex + 1) % len(s.order)
+	return item, best.host, true
+}
+
+// done 标记域名的一张图片下载结束
+func (s *hostScheduler) done(host string) {
+	if hq, ok := s.hosts[host]; ok {
+		hq.running--
+	}
+}
+
+// empty 所有图片都已取出
+func (s *hostScheduler) empty() bool {
+	return s.remaining == 0
+}
@@ -0,0 +1,129 @@
+package imagecapture
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostScheduler(t *testing.T) {
+	urls := []string{
+		"https://a.com/1.jpg", "https://a.com/2.jpg", "https://A.com/3.jpg",
+		"https://b.com/1.jpg", "https://c.com/1.jpg", "::invalid",
+	}
+	next := func(s *hostScheduler) string {
+		item, _, ok := s.next()
+		if !ok {
+			return ""
+		}
+		return item.url
+	}
+
+	// 每个域名 1 个名额：在域名之间轮转，名额用完后需要等待
+	s := newHostScheduler(urls, nil, 1)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, next(s))
+	}
+	want := []string{urls[0], urls[3], urls[4], urls[5]}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("next() = %v, want %v", got, want)
+	}
+	if url := next(s); url != "" {
+		t.Errorf("next() with all hosts busy = %s", url)
+	}
+	s.done("a.com")
+	if url := next(s); url != urls[1] {
+		t.Errorf("next() after done = %s", url)
+	}
+
+	// 优先级高的先取出，不受轮转影响
+	priorities := map[string]int{urls[2]: 10, urls[4]: 5}
+	s = newHostScheduler(urls, func(_ int, url string) int { return priorities[url] }, 0)
+	got = got[:0]
+	for !s.empty() {
+		got = append(got, next(s))
+	}
+	// 取出 c.com 后游标指向下一个域名（无法解析的地址），之后继续轮转
+	want = []string{urls[2], urls[4], urls[5], urls[0], urls[3], urls[1]}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("next() with priorities = %v, want %v", got, want)
+	}
+}
+
+// 记录每个域名的最大并发数和请求顺序
+type hostRecorder struct {
+	mu      sync.Mutex
+	running map[string]int
+	max     map[string]int
+	order   []string
+}
+
+func (h *hostRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := hostOf("http://" + r.Host)
+	h.mu.Lock()
+	h.running[host]++
+	if h.running[host] > h.max[host] {
+		h.max[host] = h.running[host]
+	}
+	h.order = append(h.order, host+r.URL.Path)
+	h.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	w.Write(testPNG)
+	h.mu.Lock()
+	h.running[host]--
+	h.mu.Unlock()
+}
+
+func TestBatchDownload_HostConcurrency(t *testing.T) {
+	recorder := &hostRecorder{running: map[string]int{}, max: map[string]int{}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	// 同一个服务通过 127.0.0.1 和 localhost 两个域名访问
+	other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	var urls []string
+	for _, name := range []string{"/1.png", "/2.png", "/3.png", "/4.png", "/5.png", "/6.png"} {
+		urls = append(urls, server.URL+name)
+	}
+	urls = append(urls, other+"/7.png", other+"/8.png")
+
+	bc := NewBingCapture(1, WithHostConcurrency(2))
+	paths, err := bc.BatchDownload(urls, t.TempDir(), false)
+	if err != nil || len(paths) != len(urls) {
+		t.Fatalf("BatchDownload() = %d paths, %v", len(paths), err)
+	}
+	if recorder.max["127.0.0.1"] != 2 || recorder.max["localhost"] != 2 {
+		t.Errorf("max concurrency per host = %v, want 2", recorder.max)
+	}
+	// localhost 的图片不会排在 127.0.0.1 的所有图片之后
+	for i, request := range recorder.order {
+		if strings.HasPrefix(request, "localhost") {
+			if i > 3 {
+				t.Errorf("first localhost request at %d, order = %v", i, recorder.order)
+			}
+			break
+		}
+	}
+}
+
+func TestBatchDownload_Priority(t *testing.T) {
+	recorder := &hostRecorder{running: map[string]int{}, max: map[string]int{}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	results := []Result{{URL: server.URL + "/low.png"}, {URL: server.URL + "/high.png", Width: 1920}, {URL: server.URL + "/mid.png", Width: 800}}
+
+	bc := NewBingCapture(1, WithDownloadRoutines(1))
+	_, err := bc.DownloadResults(results, t.TempDir(), false, WithPriority(func(r Result) int {
+		return r.Width
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "127.0.0.1/high.png,127.0.0.1/mid.png,127.0.0.1/low.png"
+	if got := strings.Join(recorder.order, ","); got != want {
+		t.Errorf("download order = %s, want %s", got, want)
+	}
+}
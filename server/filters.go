@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/2 上午10:00
+* @Package:
+ */
+
+// 客户端不能指定的字段，避免读取服务器上的文件
+var forbiddenFilters = map[string]bool{
+	"rules_file": true,
+}
+
+// ParseFilters 解析 /search 的 filters 参数，格式为逗号分隔的 key:value，key 与 imagecapture.SearchSpec 的 json 字段相同，
+// 列表用 | 分隔，布尔值可以省略 value，例如 size:large,color:red,extensions:jpg|png,min_width:800,hd
+func ParseFilters(s string) (imagecapture.SearchSpec, error) {
+	var spec imagecapture.SearchSpec
+	fields := specFields()
+	v := reflect.ValueOf(&spec).Elem()
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(item, ":")
+		index, ok := fields[key]
+		if !ok || forbiddenFilters[key] {
+			return spec, fmt.Errorf("unknown filter: %q", key)
+		}
+		field := v.Field(index)
+		if field.Kind() != reflect.Bool && !hasValue {
+			return spec, fmt.Errorf("missing value for filter: %q", key)
+		}
+		var err error
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			var n int
+			n, err = strconv.Atoi(value)
+			field.SetInt(int64(n))
+		case reflect.Float64:
+			var f float64
+			f, err = strconv.ParseFloat(value, 64)
+			field.SetFloat(f)
+		case reflect.Bool:
+			b := true
+			if hasValue {
+				b, err = strconv.ParseBool(value)
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			field.Set(reflect.ValueOf(strings.Split(value, "|")))
+		}
+		if err != nil {
+			return spec, fmt.Errorf("invalid value for filter %q: %q", key, value)
+		}
+	}
+	return spec, nil
+}
+
+// SearchSpec 的 json 字段名到字段序号
+func specFields() map[string]int {
+	t := reflect.TypeOf(imagecapture.SearchSpec{})
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = i
+	}
+	return fields
+}
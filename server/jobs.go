@@ -0,0 +1,272 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/2 上午10:00
+* @Package:
+ */
+
+// 下载任务状态
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"   // 任务本身失败，例如文件名模板无效，单张图片失败不影响任务状态
+	JobCanceled = "canceled" // 服务关闭时未完成的任务
+)
+
+// DownloadRequest POST /download 的请求体，urls 和 results 至少设置一个
+type DownloadRequest struct {
+	Engine    string                `json:"engine"`     // 使用哪个引擎的下载器（请求头、Referer），默认 baidu
+	URLs      []string              `json:"urls"`       // 图片地址
+	Results   []imagecapture.Result `json:"results"`    // /search 返回的结果，下载报告中会带上来源信息
+	Md5Naming bool                  `json:"md5_naming"` // 以图片 md5 命名，默认 uuid
+	Filename  string                `json:"filename"`   // 文件名模板，设置后忽略 md5_naming
+}
+
+// Job 下载任务状态，GET /download/{id} 的响应
+type Job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Engine     string     `json:"engine"`
+	Total      int        `json:"total"`
+	Downloaded int        `json:"downloaded"`
+	Failed     int        `json:"failed"`
+	Files      []string   `json:"files,omitempty"`  // 相对任务目录的文件路径
+	Errors     []JobError `json:"errors,omitempty"` // 下载失败的图片
+	Error      string     `json:"error,omitempty"`  // 任务失败的原因
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobError 单张图片的下载失败原因
+type JobError struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// 保存所有任务，限制同时执行和排队的任务数
+type jobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	queued    int
+	maxQueued int
+	retention time.Duration
+	slots     chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newJobStore(maxJobs, maxQueued int, retention time.Duration) *jobStore {
+	return &jobStore{
+		jobs:      make(map[string]*Job),
+		maxQueued: maxQueued,
+		retention: retention,
+		slots:     make(chan struct{}, maxJobs),
+	}
+}
+
+var errQueueFull = errors.New("too many queued download jobs")
+
+// 添加任务，同时清理超过保留时长的已结束任务
+func (js *jobStore) add(job *Job) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.queued >= js.maxQueued {
+		return errQueueFull
+	}
+	now := time.Now()
+	for id, j := range js.jobs {
+		if j.FinishedAt != nil && now.Sub(*j.FinishedAt) > js.retention {
+			delete(js.jobs, id)
+		}
+	}
+	js.jobs[job.ID] = job
+	js.queued++
+	return nil
+}
+
+// 返回任务的副本
+func (js *jobStore) get(id string) (Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	job, ok := js.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	snapshot := *job
+	snapshot.Files = append([]string(nil), job.Files...)
+	snapshot.Errors = append([]JobError(nil), job.Errors...)
+	return snapshot, true
+}
+
+// 在锁内修改任务
+func (js *jobStore) update(job *Job, fn func(job *Job)) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if job.Status == JobQueued {
+		js.queued--
+	}
+	fn(job)
+	if job.Status == JobQueued {
+		js.queued++
+	}
+}
+
+func (js *jobStore) wait() {
+	js.wg.Wait()
+}
+
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var req DownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	engine, capture, err := s.capture(req.Engine)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	results := req.Results
+	for _, u := range req.URLs {
+		results = append(results, imagecapture.Result{URL: u, Engine: engine})
+	}
+	if len(results) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("missing urls"))
+		return
+	}
+	if len(results) > s.cfg.Limits.MaxURLs {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("urls exceed the limit of %d", s.cfg.Limits.MaxURLs))
+		return
+	}
+	id, err := imagecapture.GenerateUUID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	job := &Job{ID: id, Status: JobQueued, Engine: engine, Total: len(results), CreatedAt: time.Now()}
+	if err = s.jobs.add(job); err != nil {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	s.jobs.wg.Add(1)
+	go func() {
+		defer s.jobs.wg.Done()
+		s.runJob(job, capture, results, req)
+	}()
+	snapshot, _ := s.jobs.get(id)
+	w.Header().Set("Location", "/download/"+id)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	job, ok := s.jobs.get(strings.TrimPrefix(r.URL.Path, "/download/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// 等待空闲的执行槽位后下载，服务关闭时未开始的任务直接取消
+func (s *Server) runJob(job *Job, capture imagecapture.Capture, results []imagecapture.Result, req DownloadRequest) {
+	finish := func(status string, err error) {
+		s.jobs.update(job, func(job *Job) {
+			now := time.Now()
+			job.Status, job.FinishedAt = status, &now
+			if err != nil {
+				job.Error = err.Error()
+			}
+		})
+	}
+	select {
+	case s.jobs.slots <- struct{}{}:
+		defer func() { <-s.jobs.slots }()
+	case <-s.ctx.Done():
+		finish(JobCanceled, s.ctx.Err())
+		return
+	}
+	s.jobs.update(job, func(job *Job) {
+		now := time.Now()
+		job.Status, job.StartedAt = JobRunning, &now
+	})
+	dir := filepath.Join(s.cfg.Dir, job.ID)
+	record := func(r imagecapture.DownloadReport) {
+		s.jobs.update(job, func(job *Job) {
+			if r.Err != nil {
+				job.Failed++
+				job.Errors = append(job.Errors, JobError{URL: r.URL, Error: r.Err.Error()})
+				return
+			}
+			job.Downloaded++
+			if rel, err := filepath.Rel(dir, r.Path); err == nil {
+				job.Files = append(job.Files, filepath.ToSlash(rel))
+			}
+		})
+	}
+	// 先排除不允许访问的地址
+	allowed := results[:0:0]
+	for _, result := range results {
+		result, err := s.checkResult(result)
+		if err != nil {
+			record(imagecapture.DownloadReport{URL: result.URL, Err: err})
+			continue
+		}
+		allowed = append(allowed, result)
+	}
+	opts := []imagecapture.DownloadOption{
+		imagecapture.WithDownloadContext(s.ctx),
+		imagecapture.WithReportHook(record),
+	}
+	if req.Filename != "" {
+		opts = append(opts, imagecapture.WithFilenameTemplate(req.Filename))
+	}
+	if len(allowed) > 0 {
+		if _, err := capture.DownloadResults(allowed, dir, req.Md5Naming, opts...); err != nil {
+			finish(JobFailed, err)
+			return
+		}
+	}
+	if s.ctx.Err() != nil {
+		finish(JobCanceled, s.ctx.Err())
+		return
+	}
+	finish(JobDone, nil)
+}
+
+// 检查下载地址和原图失败时回退的地址，它们都来自请求体。
+// 下载地址不允许访问时拒绝整个结果，回退地址不允许访问时只去掉该版本
+func (s *Server) checkResult(result imagecapture.Result) (imagecapture.Result, error) {
+	if err := checkURL(s.ctx, result.URL, s.cfg.AllowPrivateHosts); err != nil {
+		return result, err
+	}
+	for _, u := range []*string{&result.Original, &result.Middle, &result.Thumbnail} {
+		if *u != "" && *u != result.URL && checkURL(s.ctx, *u, s.cfg.AllowPrivateHosts) != nil {
+			*u = ""
+		}
+	}
+	return result, nil
+}
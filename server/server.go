@@ -0,0 +1,464 @@
+// Package server 以 HTTP 接口提供图片搜索和下载，供不使用 Go 的服务调用：
+//
+//	srv, err := server.New(server.Config{Dir: "./images", APIKeys: []string{"secret"}})
+//	if err != nil {
+//		panic(err)
+//	}
+//	defer srv.Close()
+//	http.ListenAndServe(":8080", srv)
+//
+// 接口：
+//
+//	GET  /search?engine=bing&q=老虎&n=20&filters=size:large,extensions:jpg|png  搜索，返回 JSON
+//	POST /download                                                            创建下载任务，返回任务状态
+//	GET  /download/{id}                                                       查询下载任务状态
+//	GET  /proxy?engine=bing&url=...                                           通过下载器转发一张图片
+//	GET  /healthz                                                             健康检查，不需要鉴权
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/2 上午10:00
+* @Package: HTTP 服务
+ */
+
+// Config 服务配置
+type Config struct {
+	Dir            string                          // 下载任务的输出目录，每个任务保存在 Dir/{id} 下，默认 ./images
+	APIKeys        []string                        // 允许访问的 API Key，通过 X-API-Key 或 Authorization: Bearer 传入，为空时不校验
+	Routines       int                             // 搜索并发，默认 3
+	CaptureOptions []imagecapture.CaptureOption    // 创建采集器时使用的选项
+	Captures       map[string]imagecapture.Capture // 按引擎指定采集器，未指定的引擎通过 imagecapture.NewCapture 创建
+	// 允许 /proxy 和下载任务访问内网地址，默认拒绝。服务创建的采集器在连接时检查对方地址，重定向和 DNS 重绑定同样会被拒绝；
+	// Captures 中的采集器由调用方创建，未开启时应传入 imagecapture.WithDialGuard(DenyPrivateAddr)
+	AllowPrivateHosts bool
+	Limits            Limits
+}
+
+// Limits 请求限制，零值使用默认值
+type Limits struct {
+	MaxResults    int           // 单次搜索最多返回的数量，默认 200
+	MaxURLs       int           // 单个下载任务最多的图片数，默认 1000
+	MaxBodyBytes  int64         // 请求体大小上限，默认 1MB
+	MaxConcurrent int           // 同时处理的请求数，超出返回 429，默认 64
+	MaxJobs       int           // 同时执行的下载任务数，默认 2
+	MaxQueuedJobs int           // 排队中的下载任务上限，超出返回 429，默认 100
+	RateLimit     float64       // 每个 API Key（未开启鉴权时为每个客户端 IP）每秒的请求数，0 表示不限制
+	Burst         int           // 突发请求数，默认为 RateLimit 向上取整
+	JobRetention  time.Duration // 已结束的任务保留多久，默认 1 小时
+}
+
+func (l *Limits) setDefaults() {
+	if l.MaxResults <= 0 {
+		l.MaxResults = 200
+	}
+	if l.MaxURLs <= 0 {
+		l.MaxURLs = 1000
+	}
+	if l.MaxBodyBytes <= 0 {
+		l.MaxBodyBytes = 1 << 20
+	}
+	if l.MaxConcurrent <= 0 {
+		l.MaxConcurrent = 64
+	}
+	if l.MaxJobs <= 0 {
+		l.MaxJobs = 2
+	}
+	if l.MaxQueuedJobs <= 0 {
+		l.MaxQueuedJobs = 100
+	}
+	if l.Burst <= 0 {
+		l.Burst = int(math.Ceil(l.RateLimit))
+	}
+	if l.JobRetention <= 0 {
+		l.JobRetention = time.Hour
+	}
+}
+
+// Server 实现 http.Handler
+type Server struct {
+	cfg      Config
+	captures map[string]imagecapture.Capture
+	mux      *http.ServeMux
+	inflight chan struct{} // 正在处理的请求
+	limiter  *rateLimiter  // 未设置 RateLimit 时为 nil
+	jobs     *jobStore
+	owned    []imagecapture.Capture // 服务创建的采集器，Close 时关闭
+	ctx      context.Context        // Close 时取消，用于中止下载任务
+	cancel   context.CancelFunc
+}
+
+// New 创建服务，默认提供百度和必应两个引擎
+func New(cfg Config) (*Server, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "./images"
+	}
+	if cfg.Routines <= 0 {
+		cfg.Routines = 3
+	}
+	cfg.Limits.setDefaults()
+	s := &Server{
+		cfg:      cfg,
+		captures: make(map[string]imagecapture.Capture),
+		mux:      http.NewServeMux(),
+		inflight: make(chan struct{}, cfg.Limits.MaxConcurrent),
+	}
+	for engine, capture := range cfg.Captures {
+		s.captures[engine] = capture
+	}
+	opts := cfg.CaptureOptions[:len(cfg.CaptureOptions):len(cfg.CaptureOptions)]
+	if !cfg.AllowPrivateHosts {
+		opts = append(opts, imagecapture.WithDialGuard(DenyPrivateAddr))
+	}
+	for _, engine := range []string{imagecapture.EngineBaidu, imagecapture.EngineBing} {
+		if s.captures[engine] != nil {
+			continue
+		}
+		capture, err := imagecapture.NewCapture(engine, cfg.Routines, opts...)
+		if err != nil {
+			s.closeCaptures()
+			return nil, err
+		}
+		s.captures[engine] = capture
+		s.owned = append(s.owned, capture)
+	}
+	if cfg.Limits.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.Limits.RateLimit, cfg.Limits.Burst)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.jobs = newJobStore(cfg.Limits.MaxJobs, cfg.Limits.MaxQueuedJobs, cfg.Limits.JobRetention)
+	s.mux.HandleFunc("/search", s.handleSearch)
+	s.mux.HandleFunc("/download", s.handleCreateJob)
+	s.mux.HandleFunc("/download/", s.handleGetJob)
+	s.mux.HandleFunc("/proxy", s.handleProxy)
+	return s, nil
+}
+
+// Close 取消正在执行和排队中的下载任务，等待它们结束后关闭服务创建的采集器
+func (s *Server) Close() error {
+	s.cancel()
+	s.jobs.wait()
+	s.closeCaptures()
+	return nil
+}
+
+// 关闭服务创建的采集器，Config.Captures 传入的由调用方关闭
+func (s *Server) closeCaptures() {
+	for _, capture := range s.owned {
+		capture.Close()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	select {
+	case s.inflight <- struct{}{}:
+		defer func() { <-s.inflight }()
+	default:
+		writeError(w, http.StatusTooManyRequests, errors.New("too many concurrent requests"))
+		return
+	}
+	key, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="imagecapture"`)
+		writeError(w, http.StatusUnauthorized, errors.New("invalid or missing api key"))
+		return
+	}
+	if s.limiter != nil {
+		if key == "" {
+			key, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		if ok, wait := s.limiter.allow(key, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+			return
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.Limits.MaxBodyBytes)
+	s.mux.ServeHTTP(w, r)
+}
+
+// 校验 API Key，未配置时允许所有请求，返回的 key 用于限流
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	if len(s.cfg.APIKeys) == 0 {
+		return "", true
+	}
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key == "" {
+		return "", false
+	}
+	for _, allowed := range s.cfg.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(allowed)) == 1 {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// 按引擎名称获取采集器，为空时使用百度
+func (s *Server) capture(engine string) (string, imagecapture.Capture, error) {
+	if engine == "" {
+		engine = imagecapture.EngineBaidu
+	}
+	capture := s.captures[engine]
+	if capture == nil {
+		return "", nil, fmt.Errorf("%w: %s", imagecapture.ErrUnsupportedEngine, engine)
+	}
+	return engine, capture, nil
+}
+
+// SearchResponse GET /search 的响应
+type SearchResponse struct {
+	Engine  string                `json:"engine"`
+	Keyword string                `json:"keyword"`
+	Count   int                   `json:"count"`
+	Results []imagecapture.Result `json:"results"`
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	params := r.URL.Query()
+	keyword := strings.TrimSpace(params.Get("q"))
+	if keyword == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing q"))
+		return
+	}
+	n := 20
+	if v := params.Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid n: %q", v))
+			return
+		}
+	}
+	if n > s.cfg.Limits.MaxResults {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("n exceeds the limit of %d", s.cfg.Limits.MaxResults))
+		return
+	}
+	engine, capture, err := s.capture(params.Get("engine"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	spec, err := ParseFilters(params.Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := spec.Options()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	results, err := capture.Search(keyword, n, append(opts, imagecapture.WithContext(r.Context()))...)
+	if errors.Is(err, imagecapture.ErrUnsupportedOption) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, SearchResponse{Engine: engine, Keyword: keyword, Count: len(results), Results: results})
+}
+
+// 通过下载器将图片写入响应，Content-Type 由图片数据推断，不是图片时返回 502
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	_, capture, err := s.capture(r.URL.Query().Get("engine"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	imageURL := r.URL.Query().Get("url")
+	if err = checkURL(r.Context(), imageURL, s.cfg.AllowPrivateHosts); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	iw := &imageWriter{w: w}
+	// 客户端断开时停止请求上游
+	if _, err = capture.DownloadContext(r.Context(), imageURL, "", iw); err != nil && iw.n == 0 {
+		status := http.StatusBadGateway
+		if imagecapture.ErrorClass(err) == imagecapture.ErrorClassTimeout {
+			status = http.StatusGatewayTimeout
+		}
+		writeError(w, status, err)
+	}
+	// 已经开始写入时只能中断响应，客户端会收到不完整的内容
+}
+
+// 第一次写入时按图片数据设置响应头，不是图片时拒绝写入，避免上游的网页、SVG 以服务的域名返回给浏览器。
+// 记录已写入的字节数，用于判断是否还能返回错误
+type imageWriter struct {
+	w http.ResponseWriter
+	n int64
+}
+
+func (iw *imageWriter) Write(p []byte) (int, error) {
+	if iw.n == 0 {
+		ty := http.DetectContentType(p)
+		if !strings.HasPrefix(ty, "image/") {
+			return 0, fmt.Errorf("%w: %s", imagecapture.ErrUnsupportedFileType, ty)
+		}
+		header := iw.w.Header()
+		header.Set("Content-Type", ty)
+		header.Set("Cache-Control", "private, max-age=3600")
+	}
+	n, err := iw.w.Write(p)
+	iw.n += int64(n)
+	return n, err
+}
+
+// 只允许 http、https 地址，未开启 AllowPrivateHosts 时拒绝解析到内网地址的域名。
+// 这里只是提前返回明确的错误，重定向和 DNS 重新解析后的地址由采集器的 DialGuard 在连接时检查
+func checkURL(ctx context.Context, raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("invalid url: %q", raw)
+	}
+	if allowPrivate {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return DenyPrivateAddr(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		// 解析结果中的 IPv4 地址可能以 IPv4 映射的形式返回
+		if err = DenyPrivateAddr(addr.Unmap()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 不允许访问的地址段
+var privatePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // 本网络
+	netip.MustParsePrefix("10.0.0.0/8"),     // 内网
+	netip.MustParsePrefix("100.64.0.0/10"),  // 运营商级 NAT
+	netip.MustParsePrefix("127.0.0.0/8"),    // 回环
+	netip.MustParsePrefix("169.254.0.0/16"), // 链路本地，包括云服务器的元数据服务
+	netip.MustParsePrefix("172.16.0.0/12"),  // 内网
+	netip.MustParsePrefix("192.0.0.0/24"),   // 协议分配
+	netip.MustParsePrefix("192.168.0.0/16"), // 内网
+	netip.MustParsePrefix("198.18.0.0/15"),  // 基准测试
+	netip.MustParsePrefix("224.0.0.0/4"),    // 组播
+	netip.MustParsePrefix("240.0.0.0/4"),    // 保留地址和广播
+	netip.MustParsePrefix("::/128"),         // 未指定
+	netip.MustParsePrefix("::1/128"),        // 回环
+	netip.MustParsePrefix("::ffff:0:0/96"),  // IPv4 映射
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64，可能映射到内网的 IPv4 地址
+	netip.MustParsePrefix("fc00::/7"),       // 唯一本地地址
+	netip.MustParsePrefix("fe80::/10"),      // 链路本地
+	netip.MustParsePrefix("ff00::/8"),       // 组播
+}
+
+// DenyPrivateAddr 拒绝回环、内网、链路本地、运营商级 NAT、IPv6 唯一本地和 IPv4 映射等地址，
+// 可作为 imagecapture.WithDialGuard 的参数
+func DenyPrivateAddr(ip netip.Addr) error {
+	for _, prefix := range privatePrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("private host is not allowed: %s", ip)
+		}
+	}
+	return nil
+}
+
+// 每个 key 一个令牌桶
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// 取一个令牌，失败时返回需要等待的时间
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= 10000 {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// 删除已经回满的令牌桶，它们与新建的桶没有区别
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
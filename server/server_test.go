@@ -0,0 +1,398 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/code-innovator-zyx/imagecapture"
+)
+
+// 搜索返回固定结果，下载使用真实的下载器
+type fakeCapture struct {
+	imagecapture.Capture
+	results []imagecapture.Result
+}
+
+func (c *fakeCapture) Search(keyword string, maxNumber int, opts ...imagecapture.Option) ([]imagecapture.Result, error) {
+	if maxNumber > len(c.results) {
+		maxNumber = len(c.results)
+	}
+	return c.results[:maxNumber], nil
+}
+
+// 图片服务，/ok.png 返回图片，/page.png 返回网页，/slow.png 在请求取消前不返回，其他路径返回 404
+func newImageServer(t *testing.T) (*httptest.Server, []byte) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.png":
+			w.Write(img.Bytes())
+		case "/page.png":
+			w.Write([]byte("<html><script>alert(document.cookie)</script></html>"))
+		case "/slow.png":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, img.Bytes()
+}
+
+func newTestServer(t *testing.T, cfg Config) *Server {
+	// 与服务创建的采集器一样，不允许访问内网时在连接时检查地址
+	var opts []imagecapture.CaptureOption
+	if !cfg.AllowPrivateHosts {
+		opts = append(opts, imagecapture.WithDialGuard(DenyPrivateAddr))
+	}
+	capture := &fakeCapture{
+		Capture: imagecapture.NewBingCapture(1, opts...),
+		results: []imagecapture.Result{
+			{URL: "https://example.com/1.jpg", Engine: imagecapture.EngineBing, Keyword: "老虎"},
+			{URL: "https://example.com/2.jpg", Engine: imagecapture.EngineBing, Keyword: "老虎"},
+		},
+	}
+	cfg.Captures = map[string]imagecapture.Capture{imagecapture.EngineBing: capture}
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func do(srv http.Handler, method, target string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	var reader bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reader).Encode(body)
+	}
+	req := httptest.NewRequest(method, target, &reader)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	return w
+}
+
+func TestServer_Search(t *testing.T) {
+	srv := newTestServer(t, Config{Limits: Limits{MaxResults: 10}})
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"ok", "/search?engine=bing&q=老虎&n=1&filters=size:large,extensions:jpg|png", http.StatusOK},
+		{"missing keyword", "/search?engine=bing", http.StatusBadRequest},
+		{"too many", "/search?engine=bing&q=老虎&n=11", http.StatusBadRequest},
+		{"unknown engine", "/search?engine=google&q=老虎", http.StatusBadRequest},
+		{"invalid filter", "/search?engine=bing&q=老虎&filters=size:huge", http.StatusBadRequest},
+		{"rules file", "/search?engine=bing&q=老虎&filters=rules_file:/etc/passwd", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(srv, http.MethodGet, tt.target, nil, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp SearchResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Engine != imagecapture.EngineBing || resp.Count != 1 || resp.Results[0].URL != "https://example.com/1.jpg" {
+				t.Errorf("response = %+v", resp)
+			}
+		})
+	}
+	if w := do(srv, http.MethodPost, "/search?q=老虎", nil, nil); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /search status = %d", w.Code)
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	srv := newTestServer(t, Config{APIKeys: []string{"secret"}})
+	target := "/search?engine=bing&q=老虎"
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"missing", nil, http.StatusUnauthorized},
+		{"wrong", http.Header{"X-Api-Key": {"wrong"}}, http.StatusUnauthorized},
+		{"header", http.Header{"X-Api-Key": {"secret"}}, http.StatusOK},
+		{"bearer", http.Header{"Authorization": {"Bearer secret"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(srv, http.MethodGet, target, nil, tt.header); w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+	if w := do(srv, http.MethodGet, "/healthz", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("healthz status = %d", w.Code)
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	srv := newTestServer(t, Config{APIKeys: []string{"a", "b"}, Limits: Limits{RateLimit: 0.1, Burst: 1}})
+	target := "/search?engine=bing&q=老虎"
+	if w := do(srv, http.MethodGet, target, nil, http.Header{"X-Api-Key": {"a"}}); w.Code != http.StatusOK {
+		t.Fatalf("first request status = %d", w.Code)
+	}
+	w := do(srv, http.MethodGet, target, nil, http.Header{"X-Api-Key": {"a"}})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("second request status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// 每个 key 单独计算
+	if w = do(srv, http.MethodGet, target, nil, http.Header{"X-Api-Key": {"b"}}); w.Code != http.StatusOK {
+		t.Errorf("other key status = %d", w.Code)
+	}
+}
+
+// 轮询任务直到结束
+func waitJob(t *testing.T, srv *Server, id string) Job {
+	var job Job
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		w := do(srv, http.MethodGet, "/download/"+id, nil, nil)
+		json.Unmarshal(w.Body.Bytes(), &job)
+		if job.Status != JobQueued && job.Status != JobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish: %+v", id, job)
+	return job
+}
+
+func TestServer_DownloadJob(t *testing.T) {
+	images, _ := newImageServer(t)
+	dir := t.TempDir()
+	srv := newTestServer(t, Config{Dir: dir, AllowPrivateHosts: true, Limits: Limits{MaxURLs: 2}})
+
+	w := do(srv, http.MethodPost, "/download", DownloadRequest{
+		Engine:   imagecapture.EngineBing,
+		URLs:     []string{images.URL + "/ok.png", images.URL + "/missing.png"},
+		Filename: "{index:2}",
+	}, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /download status = %d: %s", w.Code, w.Body)
+	}
+	var job Job
+	json.Unmarshal(w.Body.Bytes(), &job)
+	if job.ID == "" || job.Total != 2 || w.Header().Get("Location") != "/download/"+job.ID {
+		t.Fatalf("created job = %+v", job)
+	}
+	job = waitJob(t, srv, job.ID)
+	if job.Status != JobDone || job.Downloaded != 1 || job.Failed != 1 || job.FinishedAt == nil {
+		t.Fatalf("finished job = %+v", job)
+	}
+	if !reflect.DeepEqual(job.Files, []string{"00.png"}) || !strings.HasSuffix(job.Errors[0].URL, "/missing.png") {
+		t.Errorf("job files = %v, errors = %v", job.Files, job.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(dir, job.ID, "00.png")); err != nil {
+		t.Error(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+	}{
+		{"empty", DownloadRequest{Engine: imagecapture.EngineBing}, http.StatusBadRequest},
+		{"too many", DownloadRequest{Engine: imagecapture.EngineBing, URLs: []string{"a", "b", "c"}}, http.StatusBadRequest},
+		{"invalid body", "urls", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(srv, http.MethodPost, "/download", tt.body, nil); w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+	if w = do(srv, http.MethodGet, "/download/unknown", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job status = %d", w.Code)
+	}
+}
+
+func TestServer_DownloadJobPrivateHost(t *testing.T) {
+	images, _ := newImageServer(t)
+	srv := newTestServer(t, Config{})
+	w := do(srv, http.MethodPost, "/download", DownloadRequest{Engine: imagecapture.EngineBing, URLs: []string{images.URL + "/ok.png"}}, nil)
+	var job Job
+	json.Unmarshal(w.Body.Bytes(), &job)
+	job = waitJob(t, srv, job.ID)
+	if job.Downloaded != 0 || job.Failed != 1 || !strings.Contains(job.Errors[0].Error, "private host") {
+		t.Errorf("job = %+v", job)
+	}
+}
+
+// 回退地址同样来自请求体，不允许访问内网
+func TestServer_checkResult(t *testing.T) {
+	const public = "https://93.184.215.14/a.jpg"
+	result := imagecapture.Result{URL: public, Original: public,
+		Middle: "http://10.0.0.1/m.jpg", Thumbnail: "http://169.254.169.254/latest/meta-data"}
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		result       imagecapture.Result
+		want         imagecapture.Result
+		wantErr      bool
+	}{
+		{"private fallbacks", false, result, imagecapture.Result{URL: public, Original: public}, false},
+		{"private url", false, imagecapture.Result{URL: result.Thumbnail, Thumbnail: public}, imagecapture.Result{}, true},
+		{"allow private", true, result, result, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, Config{AllowPrivateHosts: tt.allowPrivate})
+			got, err := srv.checkResult(tt.result)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Proxy(t *testing.T) {
+	images, data := newImageServer(t)
+	srv := newTestServer(t, Config{AllowPrivateHosts: true})
+	w := do(srv, http.MethodGet, "/proxy?engine=bing&url="+images.URL+"/ok.png", nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Errorf("proxy status = %d, content type = %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("proxy header = %v", w.Header())
+	}
+	// 上游返回的网页不能以服务的域名返回
+	w = do(srv, http.MethodGet, "/proxy?engine=bing&url="+images.URL+"/page.png", nil, nil)
+	if w.Code != http.StatusBadGateway || strings.Contains(w.Body.String(), "<script>") ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("proxy html status = %d, content type = %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	// 客户端断开后停止请求上游
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/proxy?engine=bing&url="+images.URL+"/slow.png", nil).WithContext(ctx)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("proxy returned after %s", elapsed)
+	}
+	if w = do(srv, http.MethodGet, "/proxy?engine=bing&url="+images.URL+"/missing.png", nil, nil); w.Code != http.StatusBadGateway {
+		t.Errorf("proxy missing status = %d", w.Code)
+	}
+	if w = do(srv, http.MethodGet, "/proxy?engine=bing&url=file:///etc/passwd", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("proxy file url status = %d", w.Code)
+	}
+
+	srv = newTestServer(t, Config{})
+	if w = do(srv, http.MethodGet, "/proxy?engine=bing&url="+images.URL+"/ok.png", nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("proxy private host status = %d", w.Code)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.215.14/a.jpg", false},
+		{"http://[2606:2800:21f:cb07:6820:80da:af6b:8b2c]/a.jpg", false},
+		{"ftp://93.184.215.14/a.jpg", true},
+		{"http://127.0.0.1/a.jpg", true},
+		{"http://0.0.0.0/a.jpg", true},
+		{"http://10.1.2.3/a.jpg", true},
+		{"http://100.64.0.1/a.jpg", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://172.16.0.1/a.jpg", true},
+		{"http://192.168.1.1/a.jpg", true},
+		{"http://[::1]/a.jpg", true},
+		{"http://[::]/a.jpg", true},
+		{"http://[::ffff:127.0.0.1]/a.jpg", true},
+		{"http://[::ffff:93.184.215.14]/a.jpg", true},
+		{"http://[fd00::1]/a.jpg", true},
+		{"http://[fe80::1]/a.jpg", true},
+		{"http://localhost/a.jpg", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if err := checkURL(context.Background(), tt.url, false); (err != nil) != tt.wantErr {
+				t.Errorf("checkURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters string
+		want    imagecapture.SearchSpec
+		wantErr bool
+	}{
+		{"empty", "", imagecapture.SearchSpec{}, false},
+		{
+			name:    "all kinds",
+			filters: "size:large, color:red,extensions:jpg|png,min_width:800,min_aspect:1.5,hd",
+			want: imagecapture.SearchSpec{Size: "large", Color: "red", Extensions: []string{"jpg", "png"},
+				MinWidth: 800, MinAspect: 1.5, Hd: true},
+		},
+		{"bool value", "no_default_rules:false", imagecapture.SearchSpec{}, false},
+		{"unknown", "foo:bar", imagecapture.SearchSpec{}, true},
+		{"forbidden", "rules_file:rules.yaml", imagecapture.SearchSpec{}, true},
+		{"missing value", "size", imagecapture.SearchSpec{}, true},
+		{"invalid int", "min_width:wide", imagecapture.SearchSpec{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilters(tt.filters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilters() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 2)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("key", now); !ok {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	if ok, wait := l.allow("key", now); ok || wait != 500*time.Millisecond {
+		t.Errorf("allow() = %v, %v, want false, 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("key", now.Add(500*time.Millisecond)); !ok {
+		t.Error("allow() after refill rejected")
+	}
+}
@@ -0,0 +1,215 @@
+package imagecapture
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/25 上午10:00
+* @Package:
+ */
+
+// Sink 图片的存储位置。key 为使用 / 分隔的相对路径，例如 images/<md5>.jpeg
+type Sink interface {
+	// Create 创建一个待写入的对象，写完后调用 Commit 保存，出错时调用 Abort 丢弃。
+	// ctx 为本次下载的上下文，远程存储的 Commit 也应在 ctx 取消时停止
+	Create(ctx context.Context, key string) (SinkObject, error)
+	// Exists 判断 key 对应的对象是否已存在
+	Exists(ctx context.Context, key string) (bool, error)
+}
+
+// SinkObject 写入中的对象
+type SinkObject interface {
+	io.Writer
+	// Commit 以 key 保存对象，key 可以与 Create 时不同（例如下载完成后才知道 md5）
+	Commit(key string) error
+	// Abort 丢弃已写入的数据
+	Abort() error
+}
+
+// WithSink 设置图片的存储位置，Download 和 BatchDownload 中的文件名和目录会作为 key 写入 sink，默认写入本地文件
+func WithSink(sink Sink) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.sink = sink
+		})
+	}
+}
+
+// FileSink 本地文件存储。数据先写入同目录下的临时文件，Commit 时再重命名为目标文件，
+// 进程中断不会留下内容不完整的目标文件
+type FileSink struct {
+	root string
+	sync bool
+}
+
+// FileSinkOption 本地文件存储选项
+type FileSinkOption func(*FileSink)
+
+// WithFileSync Commit 时先 fsync 文件，重命名后再 fsync 所在目录，保证断电后数据不丢失
+func WithFileSync() FileSinkOption {
+	return func(s *FileSink) {
+		s.sync = true
+	}
+}
+
+// NewFileSink 以 root 为根目录，root 为空时 key 直接作为文件路径
+func NewFileSink(root string, opts ...FileSinkOption) *FileSink {
+	s := &FileSink{root: root}
+	for _, option := range opts {
+		option(s)
+	}
+	return s
+}
+
+func (s *FileSink) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *FileSink) Create(_ context.Context, key string) (SinkObject, error) {
+	uuid, err := GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	name := s.path(key)
+	// 以 . 开头的临时文件，避免被当作图片读取
+	file, err := newFileWriter(filepath.Join(filepath.Dir(name), fmt.Sprintf(".%s.%s.part", filepath.Base(name), uuid)))
+	if err != nil {
+		return nil, err
+	}
+	return &fileObject{sink: s, file: file}, nil
+}
+
+func (s *FileSink) Exists(_ context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+type fileObject struct {
+	sink *FileSink
+	file *os.File
+}
+
+func (o *fileObject) Write(p []byte) (int, error) {
+	return o.file.Write(p)
+}
+
+// 重命名会覆盖已存在的目标文件，是否允许覆盖由调用方决定
+func (o *fileObject) Commit(key string) error {
+	if o.sink.sync {
+		if err := o.file.Sync(); err != nil {
+			o.Abort()
+			return err
+		}
+	}
+	if err := o.file.Close(); err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+	newName := o.sink.path(key)
+	dir := filepath.Dir(newName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		os.Remove(o.file.Name())
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	if err := os.Rename(o.file.Name(), newName); err != nil {
+		os.Remove(o.file.Name())
+		return err
+	}
+	if o.sink.sync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func (o *fileObject) Abort() error {
+	o.file.Close()
+	return os.Remove(o.file.Name())
+}
+
+// 同步目录项，保证重命名落盘
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// MemorySink 内存存储，主要用于测试或下载后自行处理图片数据
+type MemorySink struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{objects: make(map[string][]byte)}
+}
+
+func (s *MemorySink) Create(_ context.Context, key string) (SinkObject, error) {
+	return &memoryObject{sink: s}, nil
+}
+
+func (s *MemorySink) Exists(_ context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+// Get 返回 key 对应的数据
+func (s *MemorySink) Get(key string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.objects[key]
+	return data, ok
+}
+
+// Keys 返回排序后的所有 key
+func (s *MemorySink) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type memoryObject struct {
+	sink *MemorySink
+	buf  bytes.Buffer
+}
+
+func (o *memoryObject) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+func (o *memoryObject) Commit(key string) error {
+	o.sink.mu.Lock()
+	defer o.sink.mu.Unlock()
+	o.sink.objects[key] = o.buf.Bytes()
+	return nil
+}
+
+func (o *memoryObject) Abort() error {
+	o.buf.Reset()
+	return nil
+}
@@ -0,0 +1,177 @@
+package imagecapture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/25 下午3:00
+* @Package:
+ */
+
+func TestMemorySink(t *testing.T) {
+	server, _ := newBingServer(t)
+	sink := NewMemorySink()
+	d := NewBingCapture(1, WithSink(sink)).(*BingCapture)
+	paths, err := d.BatchDownload([]string{server.URL + "/full.jpg", server.URL + "/missing.jpg"}, "images", true)
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("BatchDownload() = %v, %v", paths, err)
+	}
+	if keys := sink.Keys(); !reflect.DeepEqual(keys, paths) {
+		t.Errorf("Keys() = %v, want %v", keys, paths)
+	}
+	if data, ok := sink.Get(paths[0]); !ok || !bytes.Equal(data, testPNG) {
+		t.Errorf("Get() = %v, %v", data, ok)
+	}
+	if _, err = os.Stat("images"); !os.IsNotExist(err) {
+		t.Errorf("BatchDownload() created a local directory")
+	}
+
+	if suffix, err := d.Download(server.URL+"/thumb.jpg", "single", nil); err != nil || suffix != "png" {
+		t.Fatalf("Download() = %v, %v", suffix, err)
+	}
+	if _, ok := sink.Get("single.png"); !ok {
+		t.Errorf("Download() keys = %v", sink.Keys())
+	}
+	if _, err = d.Download(server.URL+"/thumb.jpg", "single", nil); !errors.Is(err, ErrFileAlreadyExists) {
+		t.Errorf("Download() existing error = %v", err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	root := t.TempDir()
+	sink := NewFileSink(root)
+	object, err := sink.Create(context.Background(), "a/tmp.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object.Write(testPNG)
+	if err = object.Commit("b/final.png"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := sink.Exists(context.Background(), "b/final.png"); !ok {
+		t.Errorf("Exists() = false after commit")
+	}
+	if ok, _ := sink.Exists(context.Background(), "a/tmp.png"); ok {
+		t.Errorf("Exists() = true for the renamed object")
+	}
+	object, _ = sink.Create(context.Background(), "c.png")
+	object.Write(testPNG)
+	object.Abort()
+	if _, err = os.Stat(filepath.Join(root, "c.png")); !os.IsNotExist(err) {
+		t.Errorf("Abort() left the file behind")
+	}
+}
+
+func TestOverwritePolicy(t *testing.T) {
+	server, _ := newBingServer(t)
+	// full.jpg 和 thumb2.jpg 内容相同，以 md5 命名时目标文件冲突
+	urls := []string{server.URL + "/full.jpg", server.URL + "/thumb2.jpg"}
+	tests := []struct {
+		policy     OverwritePolicy
+		wantFiles  int
+		wantOK     int
+		wantSkip   int
+		wantErrors int
+	}{
+		{"", 1, 1, 0, 1},
+		{Overwrite_ERROR, 1, 1, 0, 1},
+		{Overwrite_SKIP, 1, 2, 1, 0},
+		{Overwrite_REPLACE, 1, 2, 0, 0},
+		{Overwrite_RENAME, 2, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			d := NewBingCapture(2, WithSink(NewFileSink(dir, WithFileSync())), WithOverwritePolicy(tt.policy)).(*BingCapture)
+			var (
+				mu      sync.Mutex
+				skipped int
+				errs    int
+			)
+			paths, err := d.BatchDownload(urls, "images", true, WithReportHook(func(r DownloadReport) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Skipped {
+					skipped++
+				}
+				if r.Err != nil {
+					errs++
+					if !errors.Is(r.Err, ErrFileAlreadyExists) {
+						t.Errorf("report error = %v", r.Err)
+					}
+				}
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, _ := os.ReadDir(filepath.Join(dir, "images"))
+			if len(entries) != tt.wantFiles || len(paths) != tt.wantOK || skipped != tt.wantSkip || errs != tt.wantErrors {
+				t.Errorf("files = %v, paths = %v, skipped = %d, errors = %d", entries, paths, skipped, errs)
+			}
+		})
+	}
+}
+
+func TestDownload_OverwritePolicy(t *testing.T) {
+	server, _ := newBingServer(t)
+	tests := []struct {
+		policy  OverwritePolicy
+		wantErr error
+		want    []string
+		content string
+	}{
+		{Overwrite_ERROR, ErrFileAlreadyExists, []string{"a.png"}, "old"},
+		{Overwrite_SKIP, nil, []string{"a.png"}, "old"},
+		{Overwrite_REPLACE, nil, []string{"a.png"}, string(testPNG)},
+		{Overwrite_RENAME, nil, []string{"a-1.png", "a.png"}, "old"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			os.WriteFile(filepath.Join(dir, "a.png"), []byte("old"), 0644)
+			d := NewBingCapture(1, WithOverwritePolicy(tt.policy)).(*BingCapture)
+			suffix, err := d.Download(server.URL+"/full.jpg", filepath.Join(dir, "a"), nil)
+			if !errors.Is(err, tt.wantErr) || suffix != "png" {
+				t.Fatalf("Download() = %v, %v, want error %v", suffix, err, tt.wantErr)
+			}
+			var names []string
+			entries, _ := os.ReadDir(dir)
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("files = %v, want %v", names, tt.want)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "a.png")); string(data) != tt.content {
+				t.Errorf("a.png = %q", data)
+			}
+		})
+	}
+}
+
+func TestFileSink_NoPartialFiles(t *testing.T) {
+	server, _ := newBingServer(t)
+	dir := t.TempDir()
+	// 连接在传输途中断开
+	server.Config.Handler.(*http.ServeMux).HandleFunc("/broken.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100000")
+		w.Write(testPNG)
+	})
+	d := NewBingCapture(1, WithSink(NewFileSink(dir))).(*BingCapture)
+	paths, _ := d.BatchDownload([]string{server.URL + "/broken.jpg"}, "images", false)
+	entries, _ := os.ReadDir(filepath.Join(dir, "images"))
+	if len(paths) != 0 || len(entries) != 0 {
+		t.Errorf("paths = %v, files = %v", paths, entries)
+	}
+}
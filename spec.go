@@ -0,0 +1,171 @@
+package imagecapture
+
+import (
+	"fmt"
+	"regexp"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/15 上午10:40
+* @Package:
+ */
+
+// SearchSpec 以字符串描述的搜索条件，便于从命令行参数、配置文件等构造搜索选项
+type SearchSpec struct {
+	Size           string   `json:"size,omitempty" yaml:"size"` // small medium large enormous wallpaper
+	CustomWidth    int      `json:"custom_width,omitempty" yaml:"custom_width"`
+	CustomHeight   int      `json:"custom_height,omitempty" yaml:"custom_height"`
+	Color          string   `json:"color,omitempty" yaml:"color"`             // 见 ImageColor
+	Type           string   `json:"type,omitempty" yaml:"type"`               // 见 ImageType
+	Layout         string   `json:"layout,omitempty" yaml:"layout"`           // 见 ImageLayout
+	License        string   `json:"license,omitempty" yaml:"license"`         // 见 ImageLicense
+	Freshness      string   `json:"freshness,omitempty" yaml:"freshness"`     // 见 Freshness
+	SafeSearch     string   `json:"safe_search,omitempty" yaml:"safe_search"` // off moderate strict
+	Hd             bool     `json:"hd,omitempty" yaml:"hd"`
+	Extensions     []string `json:"extensions,omitempty" yaml:"extensions"` // 只保留的图片后缀
+	Domains        []string `json:"domains,omitempty" yaml:"domains"`       // 只保留的域名
+	MinWidth       int      `json:"min_width,omitempty" yaml:"min_width"`
+	MinHeight      int      `json:"min_height,omitempty" yaml:"min_height"`
+	MinAspect      float64  `json:"min_aspect,omitempty" yaml:"min_aspect"`
+	MaxAspect      float64  `json:"max_aspect,omitempty" yaml:"max_aspect"`
+	URLPattern     string   `json:"url_pattern,omitempty" yaml:"url_pattern"`     // 地址正则
+	TitlePattern   string   `json:"title_pattern,omitempty" yaml:"title_pattern"` // 标题正则
+	RulesFile      string   `json:"rules_file,omitempty" yaml:"rules_file"`       // 来源过滤规则文件
+	NoDefaultRules bool     `json:"no_default_rules,omitempty" yaml:"no_default_rules"`
+}
+
+var imageSizes = map[string]ImageSize{
+	ImageSize_SMALL.String():     ImageSize_SMALL,
+	ImageSize_MEDIUM.String():    ImageSize_MEDIUM,
+	ImageSize_LARGE.String():     ImageSize_LARGE,
+	ImageSize_ENORMOUS.String():  ImageSize_ENORMOUS,
+	ImageSize_WALLPAPER.String(): ImageSize_WALLPAPER,
+}
+
+// 校验字符串是否为合法的枚举值
+func parseEnum[T ~string](name, value string, values ...T) (T, error) {
+	for _, v := range values {
+		if string(v) == value {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s: %q", name, value)
+}
+
+// Options 转换为搜索选项
+func (s SearchSpec) Options() ([]Option, error) {
+	var opts []Option
+	if s.Size != "" {
+		size, ok := imageSizes[s.Size]
+		if !ok {
+			return nil, fmt.Errorf("invalid size: %q", s.Size)
+		}
+		opts = append(opts, WithImageSize(size))
+	}
+	if s.CustomWidth > 0 || s.CustomHeight > 0 {
+		opts = append(opts, WithCustomSize(s.CustomWidth, s.CustomHeight))
+	}
+	if s.Color != "" {
+		color, err := parseEnum("color", s.Color,
+			ImageColor_COLORFUL, ImageColor_MONOCHROME, ImageColor_RED, ImageColor_ORANGE, ImageColor_YELLOW,
+			ImageColor_GREEN, ImageColor_TEAL, ImageColor_BLUE, ImageColor_PURPLE, ImageColor_PINK,
+			ImageColor_BROWN, ImageColor_BLACK, ImageColor_GRAY, ImageColor_WHITE)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithColor(color))
+	}
+	if s.Type != "" {
+		ty, err := parseEnum("type", s.Type,
+			ImageType_PHOTO, ImageType_CLIPART, ImageType_ANIMATED, ImageType_LINE, ImageType_TRANSPARENT)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithImageType(ty))
+	}
+	if s.Layout != "" {
+		layout, err := parseEnum("layout", s.Layout, ImageLayout_SQUARE, ImageLayout_WIDE, ImageLayout_TALL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithLayout(layout))
+	}
+	if s.License != "" {
+		license, err := parseEnum("license", s.License,
+			ImageLicense_ALL, ImageLicense_PUBLIC_DOMAIN, ImageLicense_SHARE, ImageLicense_COMMERCIAL,
+			ImageLicense_MODIFY, ImageLicense_MODIFY_COMMERCIAL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithLicense(license))
+	}
+	if s.Freshness != "" {
+		freshness, err := parseEnum("freshness", s.Freshness,
+			Freshness_LATEST, Freshness_DAY, Freshness_WEEK, Freshness_MONTH, Freshness_YEAR)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithFreshness(freshness))
+	}
+	if s.SafeSearch != "" {
+		level, err := parseEnum("safe search", s.SafeSearch, SafeSearch_OFF, SafeSearch_MODERATE, SafeSearch_STRICT)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithSafeSearch(level))
+	}
+	if s.Hd {
+		opts = append(opts, WithHd())
+	}
+	filters, err := s.filters()
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) > 0 {
+		opts = append(opts, WithFilters(filters...))
+	}
+	if s.RulesFile != "" {
+		rs, err := LoadRuleSet(s.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithRuleSet(rs))
+	}
+	if s.NoDefaultRules {
+		opts = append(opts, WithoutDefaultRules())
+	}
+	return opts, nil
+}
+
+func (s SearchSpec) filters() ([]ResultFilter, error) {
+	var filters []ResultFilter
+	if len(s.Extensions) > 0 {
+		filters = append(filters, ExtensionFilter(s.Extensions...))
+	}
+	if len(s.Domains) > 0 {
+		filters = append(filters, DomainFilter(s.Domains...))
+	}
+	if s.MinWidth > 0 || s.MinHeight > 0 {
+		filters = append(filters, DimensionFilter(s.MinWidth, s.MinHeight))
+	}
+	if s.MinAspect > 0 || s.MaxAspect > 0 {
+		filters = append(filters, AspectRatioFilter(s.MinAspect, s.MaxAspect))
+	}
+	if s.URLPattern != "" {
+		re, err := regexp.Compile(s.URLPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url pattern: %w", err)
+		}
+		filters = append(filters, RegexFilter(re))
+	}
+	if s.TitlePattern != "" {
+		re, err := regexp.Compile(s.TitlePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid title pattern: %w", err)
+		}
+		filters = append(filters, TitleFilter(re))
+	}
+	return filters, nil
+}
@@ -0,0 +1,41 @@
+package imagecapture
+
+import (
+	"testing"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/15 下午4:30
+* @Package:
+ */
+
+func TestSearchSpec_Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    SearchSpec
+		wantLen int
+		wantErr bool
+	}{
+		{"empty", SearchSpec{}, 0, false},
+		{"search params", SearchSpec{Size: "large", Color: "red", Type: "photo", SafeSearch: "strict"}, 4, false},
+		{"filters", SearchSpec{Extensions: []string{"jpg"}, MinWidth: 800, TitlePattern: "老虎"}, 1, false},
+		{"invalid size", SearchSpec{Size: "huge"}, 0, true},
+		{"invalid color", SearchSpec{Color: "rainbow"}, 0, true},
+		{"invalid pattern", SearchSpec{URLPattern: "("}, 0, true},
+		{"missing rules file", SearchSpec{RulesFile: "./not-exists.yaml"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.spec.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("Options() got %d options, want %d", len(got), tt.wantLen)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package imagecapture
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/10 上午11:00
+* @Package: 搜索和下载的超时
+ */
+
+const (
+	defaultConnectTimeout  = 5 * time.Second
+	defaultSearchTimeout   = 5 * time.Second
+	defaultDownloadTimeout = 10 * time.Second
+	defaultItemTimeout     = 30 * time.Second
+)
+
+// Timeouts 搜索和下载各阶段的超时，为 0 的字段使用默认值。
+// 整体的截止时间通过 WithSearchTimeout、WithDownloadTimeout 或带截止时间的上下文设置，默认不限制
+type Timeouts struct {
+	Connect time.Duration // 建立连接（含 TLS 握手）的超时，默认 5 秒
+	Request time.Duration // 单次 HTTP 请求（含读取响应）的超时，默认搜索 5 秒、下载 10 秒
+	Item    time.Duration // 单个搜索分页、单张图片（含重试）的超时，默认 30 秒
+}
+
+// WithTimeouts 设置采集器和内置下载器的超时
+func WithTimeouts(t Timeouts) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.timeouts = t
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			if t.Connect > 0 {
+				d.connectTimeout = t.Connect
+			}
+			if t.Request > 0 {
+				d.timeout = t.Request
+			}
+			if t.Item > 0 {
+				d.itemTimeout = t.Item
+			}
+		})
+	}
+}
+
+// WithSearchTimeout 设置单次搜索的总时长，超时后返回已收集到的结果，默认不限制
+func WithSearchTimeout(timeout time.Duration) Option {
+	return func(q *query) {
+		q.timeout = timeout
+	}
+}
+
+// WithDownloadTimeout 设置单次批量下载的总时长，超时后取消未完成的图片，返回已下载成功的文件，默认不限制
+func WithDownloadTimeout(timeout time.Duration) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.timeout = timeout
+	}
+}
+
+// 搜索使用的超时，补全默认值
+func (t Timeouts) search() Timeouts {
+	if t.Connect <= 0 {
+		t.Connect = defaultConnectTimeout
+	}
+	if t.Request <= 0 {
+		t.Request = defaultSearchTimeout
+	}
+	if t.Item <= 0 {
+		t.Item = defaultItemTimeout
+	}
+	return t
+}
+
+// 为连接设置超时，Request 作为 client 的超时
+func newHTTPClient(transport *http.Transport, t Timeouts, guard DialGuard) *http.Client {
+	dialer := &net.Dialer{Timeout: t.Connect, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = t.Connect
+	client := &http.Client{Transport: transport, Timeout: t.Request}
+	guard.apply(dialer, client)
+	return client
+}
+
+// timeout > 0 时为 ctx 设置超时
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
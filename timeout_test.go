@@ -0,0 +1,116 @@
+package imagecapture
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 等待协程数回落到 before，超时后输出所有协程的调用栈
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		var buf bytes.Buffer
+		pprof.Lookup("goroutine").WriteTo(&buf, 1)
+		t.Errorf("goroutines = %d, want <= %d\n%s", n, before, buf.String())
+	}
+}
+
+// 路径以 /hang 开头的请求一直等到客户端断开
+func newHangServer(t *testing.T) (*httptest.Server, *int32) {
+	var hangs int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/hang") {
+			atomic.AddInt32(&hangs, 1)
+			<-r.Context().Done()
+			return
+		}
+		w.Write(testPNG)
+	}))
+	return server, &hangs
+}
+
+func TestDownload_Timeouts(t *testing.T) {
+	server, hangs := newHangServer(t)
+	defer server.Close()
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		// 单次请求超时后重试，单张图片超时后不再重试
+		wantRequests int32
+	}{
+		{"request timeout", Timeouts{Request: 50 * time.Millisecond, Item: 5 * time.Second}, 3},
+		{"item timeout", Timeouts{Request: 5 * time.Second, Item: 100 * time.Millisecond}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(hangs, 0)
+			bc := NewBingCapture(1, WithTimeouts(tt.timeouts))
+			start := time.Now()
+			_, err := bc.Download(server.URL+"/hang.png", "", &bytes.Buffer{})
+			if ErrorClass(err) != ErrorClassTimeout {
+				t.Errorf("Download() error = %v, want timeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Download() took %s", elapsed)
+			}
+			if n := atomic.LoadInt32(hangs); n != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSearch_DeadlineNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	server, _ := newHangServer(t)
+	bc := NewBingCapture(2).(*BingCapture)
+	bc.baseUrl = server.URL + "/hang"
+	start := time.Now()
+	results, err := bc.Search("老虎", 100, WithSearchTimeout(100*time.Millisecond))
+	if err != nil || len(results) != 0 {
+		t.Errorf("Search() = %v, %v", results, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Search() took %s", elapsed)
+	}
+	bc.Close()
+	server.Close()
+	checkGoroutines(t, before)
+}
+
+func TestBatchDownload_DeadlineNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	server, _ := newHangServer(t)
+	urls := []string{server.URL + "/1.png", server.URL + "/hang1.png", server.URL + "/2.png", server.URL + "/hang2.png"}
+	var reports int32
+	bc := NewBingCapture(1, WithDownloadRoutines(2), WithHostConcurrency(0))
+	start := time.Now()
+	paths, err := bc.BatchDownload(urls, t.TempDir(), false, WithDownloadTimeout(300*time.Millisecond),
+		WithReportHook(func(DownloadReport) {
+			atomic.AddInt32(&reports, 1)
+		}))
+	if err != nil || len(paths) != 2 {
+		t.Errorf("BatchDownload() = %v, %v", paths, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("BatchDownload() took %s", elapsed)
+	}
+	// 返回前所有已开始的图片都已结束并回调
+	if n := atomic.LoadInt32(&reports); n != 4 {
+		t.Errorf("reports = %d, want 4", n)
+	}
+	bc.Close()
+	server.Close()
+	checkGoroutines(t, before)
+}
@@ -0,0 +1,77 @@
+package imagecapture
+
+import "context"
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/11/29 上午10:00
+* @Package:
+ */
+
+// span 名称
+const (
+	SpanSearch          = "imagecapture.search"           // 一次 Search 或 RangeResults 调用
+	SpanSearchPage      = "imagecapture.search_page"      // 一次搜索分页请求
+	SpanCheckURL        = "imagecapture.check_url"        // 必应校验原图地址的请求
+	SpanBatchDownload   = "imagecapture.batch_download"   // 一次 BatchDownload 或 DownloadResults 调用
+	SpanDownload        = "imagecapture.download"         // 一张图片的下载
+	SpanDownloadAttempt = "imagecapture.download_attempt" // 一次下载请求，失败重试时会有多个
+)
+
+// Tracer 链路追踪接口，通过 WithTracer 设置。子包 otel 提供了 OpenTelemetry 的实现
+type Tracer interface {
+	// Start 以 ctx 中的 span 为父节点开始一个 span，args 为交替的键值对属性
+	Start(ctx context.Context, name string, args ...any) (context.Context, Span)
+}
+
+// Span 追踪中的一个节点
+type Span interface {
+	// SetAttributes 追加属性，args 为交替的键值对
+	SetAttributes(args ...any)
+	// End 结束 span，err 不为 nil 时标记为失败
+	End(err error)
+}
+
+// 默认不追踪
+type nopTracer struct{}
+
+type nopSpan struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string, _ ...any) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetAttributes(...any) {}
+func (nopSpan) End(error)            {}
+
+// WithTracer 设置采集器和内置下载器的链路追踪，父 span 通过 WithContext、WithDownloadContext 传入
+func WithTracer(tracer Tracer) CaptureOption {
+	return func(cfg *captureConfig) {
+		if tracer == nil {
+			return
+		}
+		cfg.tracer = tracer
+		cfg.downloader = append(cfg.downloader, func(d *downloader) {
+			d.tracer = tracer
+		})
+	}
+}
+
+// WithContext 设置搜索的上下文，用于传递父 span 和取消搜索
+func WithContext(ctx context.Context) Option {
+	return func(q *query) {
+		if ctx != nil {
+			q.ctx = ctx
+		}
+	}
+}
+
+// WithDownloadContext 设置批量下载的上下文，用于传递父 span 和取消下载
+func WithDownloadContext(ctx context.Context) DownloadOption {
+	return func(cfg *downloadConfig) {
+		if ctx != nil {
+			cfg.ctx = ctx
+		}
+	}
+}
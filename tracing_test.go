@@ -0,0 +1,164 @@
+package imagecapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 记录 span 的名称、父节点和属性
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	tracer *testTracer
+	name   string
+	parent *testSpan
+	attrs  map[string]interface{}
+	ended  bool
+	err    error
+}
+
+type testSpanKey struct{}
+
+func (t *testTracer) Start(ctx context.Context, name string, args ...any) (context.Context, Span) {
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	span := &testSpan{tracer: t, name: name, parent: parent, attrs: map[string]interface{}{}}
+	span.SetAttributes(args...)
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return context.WithValue(ctx, testSpanKey{}, span), span
+}
+
+func (s *testSpan) SetAttributes(args ...any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for i := 0; i+1 < len(args); i += 2 {
+		s.attrs[fmt.Sprint(args[i])] = args[i+1]
+	}
+}
+
+func (s *testSpan) End(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.ended, s.err = true, err
+}
+
+// 返回指定名称的 span
+func (t *testTracer) find(name string) []*testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var spans []*testSpan
+	for _, span := range t.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestWithTracer_Search(t *testing.T) {
+	server, _ := newBingServer(t)
+	tracer := &testTracer{}
+	bc := NewBingCapture(1, WithTracer(tracer)).(*BingCapture)
+	bc.baseUrl = server.URL + "/images/async"
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	results, err := bc.Search("老虎", 10, WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.End(nil)
+
+	searches := tracer.find(SpanSearch)
+	if len(searches) != 1 || searches[0].parent != root || !searches[0].ended || searches[0].attrs["results"] != len(results) {
+		t.Fatalf("search spans = %+v", searches)
+	}
+	pages := tracer.find(SpanSearchPage)
+	if len(pages) == 0 {
+		t.Fatal("no search page spans")
+	}
+	for _, page := range pages {
+		if page.parent != searches[0] || !page.ended || page.attrs["status"] != 200 || page.attrs["pool_wait"] == nil {
+			t.Errorf("search page span = %+v", page)
+		}
+	}
+	checks := tracer.find(SpanCheckURL)
+	if len(checks) == 0 {
+		t.Fatal("no check url spans")
+	}
+	for _, check := range checks {
+		if check.parent == nil || check.parent.name != SpanSearchPage || !check.ended {
+			t.Errorf("check url span = %+v", check)
+		}
+	}
+}
+
+func TestWithTracer_Download(t *testing.T) {
+	server, _ := newBingServer(t)
+	tracer := &testTracer{}
+	bc := NewBingCapture(1, WithTracer(tracer)).(*BingCapture)
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, err := bc.BatchDownload([]string{server.URL + "/full.jpg", server.URL + "/missing.jpg"}, t.TempDir(), false,
+		WithDownloadContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batches := tracer.find(SpanBatchDownload)
+	// 单张图片失败不影响批量下载的 span
+	if len(batches) != 1 || batches[0].parent != root || !batches[0].ended || batches[0].err != nil {
+		t.Fatalf("batch download spans = %+v", batches)
+	}
+	attempts := tracer.find(SpanDownloadAttempt)
+	if len(attempts) != 2 {
+		t.Fatalf("download attempt spans = %d, want 2", len(attempts))
+	}
+	for _, attempt := range attempts {
+		if attempt.parent == nil || attempt.parent.name != SpanDownload || attempt.parent.parent != batches[0] {
+			t.Errorf("download attempt span = %+v", attempt)
+		}
+		if failed := attempt.attrs["status"] != 200; failed != (attempt.err != nil) {
+			t.Errorf("download attempt status = %v, err = %v", attempt.attrs["status"], attempt.err)
+		}
+	}
+}
+
+// 超时或取消导致提前结束时，返回值不变，但 span 记为失败
+func TestWithTracer_Canceled(t *testing.T) {
+	server, _ := newBingServer(t)
+	tracer := &testTracer{}
+	bc := NewBingCapture(1, WithTracer(tracer)).(*BingCapture)
+	bc.baseUrl = server.URL + "/images/async"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bc.RangeResults("老虎", func([]Result) bool { return true }, WithContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.BatchDownload([]string{server.URL + "/full.jpg"}, t.TempDir(), false, WithDownloadContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{SpanSearch, SpanBatchDownload} {
+		spans := tracer.find(name)
+		if len(spans) != 1 || !spans[0].ended || !errors.Is(spans[0].err, context.Canceled) {
+			t.Errorf("%s spans = %+v", name, spans)
+		}
+	}
+
+	// 正常翻完或回调主动停止时 span 没有错误
+	tracer = &testTracer{}
+	bc = NewBingCapture(1, WithTracer(tracer)).(*BingCapture)
+	bc.baseUrl = server.URL + "/images/async"
+	if err := bc.RangeResults("老虎", func([]Result) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	if spans := tracer.find(SpanSearch); len(spans) != 1 || !spans[0].ended || spans[0].err != nil {
+		t.Errorf("search spans = %+v", spans)
+	}
+}
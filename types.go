@@ -1,5 +1,7 @@
 package imagecapture
 
+import "fmt"
+
 /*
 * @Author: zouyx
 * @Email:1003941268@qq.com
@@ -22,5 +24,120 @@ const (
 	_
 	_
 	_
-	ImageSize_ENORMOUS // 特大图片
+	ImageSize_ENORMOUS  // 特大图片
+	ImageSize_WALLPAPER // 壁纸尺寸，仅必应支持
+)
+
+func (s ImageSize) String() string {
+	switch s {
+	case ImageSize_SMALL:
+		return "small"
+	case ImageSize_MEDIUM:
+		return "medium"
+	case ImageSize_LARGE:
+		return "large"
+	case ImageSize_ENORMOUS:
+		return "enormous"
+	case ImageSize_WALLPAPER:
+		return "wallpaper"
+	}
+	return fmt.Sprintf("ImageSize(%d)", uint(s))
+}
+
+/*
+图片颜色
+*/
+type ImageColor string
+
+const (
+	ImageColor_COLORFUL   ImageColor = "color" // 彩色
+	ImageColor_MONOCHROME ImageColor = "bw"    // 黑白
+	ImageColor_RED        ImageColor = "red"
+	ImageColor_ORANGE     ImageColor = "orange"
+	ImageColor_YELLOW     ImageColor = "yellow"
+	ImageColor_GREEN      ImageColor = "green"
+	ImageColor_TEAL       ImageColor = "teal"
+	ImageColor_BLUE       ImageColor = "blue"
+	ImageColor_PURPLE     ImageColor = "purple"
+	ImageColor_PINK       ImageColor = "pink"
+	ImageColor_BROWN      ImageColor = "brown"
+	ImageColor_BLACK      ImageColor = "black"
+	ImageColor_GRAY       ImageColor = "gray"
+	ImageColor_WHITE      ImageColor = "white"
+)
+
+/*
+图片类型
+*/
+type ImageType string
+
+const (
+	ImageType_PHOTO       ImageType = "photo"       // 照片
+	ImageType_CLIPART     ImageType = "clipart"     // 剪贴画
+	ImageType_ANIMATED    ImageType = "animated"    // 动图
+	ImageType_LINE        ImageType = "line"        // 线条画
+	ImageType_TRANSPARENT ImageType = "transparent" // 透明背景
+)
+
+/*
+图片布局
+*/
+type ImageLayout string
+
+const (
+	ImageLayout_SQUARE ImageLayout = "square" // 方形
+	ImageLayout_WIDE   ImageLayout = "wide"   // 横版
+	ImageLayout_TALL   ImageLayout = "tall"   // 竖版
+)
+
+/*
+图片授权
+*/
+type ImageLicense string
+
+const (
+	ImageLicense_ALL           ImageLicense = "all"        // 所有知识共享许可
+	ImageLicense_PUBLIC_DOMAIN ImageLicense = "public"     // 公共领域
+	ImageLicense_SHARE         ImageLicense = "share"      // 可免费分享和使用
+	ImageLicense_COMMERCIAL    ImageLicense = "commercial" // 可免费分享和商用
+	// 可免费修改、分享和使用
+	ImageLicense_MODIFY ImageLicense = "modify"
+	// 可免费修改、分享和商用
+	ImageLicense_MODIFY_COMMERCIAL ImageLicense = "modify-commercial"
+)
+
+/*
+图片时效
+*/
+type Freshness string
+
+const (
+	Freshness_LATEST Freshness = "latest" // 按最新排序
+	Freshness_DAY    Freshness = "day"    // 过去一天
+	Freshness_WEEK   Freshness = "week"   // 过去一周
+	Freshness_MONTH  Freshness = "month"  // 过去一个月
+	Freshness_YEAR   Freshness = "year"   // 过去一年
+)
+
+/*
+安全搜索级别
+*/
+type SafeSearch string
+
+const (
+	SafeSearch_OFF      SafeSearch = "off"      // 关闭
+	SafeSearch_MODERATE SafeSearch = "moderate" // 中等
+	SafeSearch_STRICT   SafeSearch = "strict"   // 严格
+)
+
+/*
+目标文件已存在时的处理方式
+*/
+type OverwritePolicy string
+
+const (
+	Overwrite_ERROR   OverwritePolicy = "error"     // 返回 ErrFileAlreadyExists，默认
+	Overwrite_SKIP    OverwritePolicy = "skip"      // 保留已有文件，视为下载成功
+	Overwrite_REPLACE OverwritePolicy = "overwrite" // 覆盖已有文件
+	Overwrite_RENAME  OverwritePolicy = "rename"    // 在文件名后追加 -1、-2 ...
 )
@@ -0,0 +1,199 @@
+package imagecapture
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/15 上午10:30
+* @Package: 原图地址校验
+ */
+
+// ValidationMode 必应原图地址的校验方式
+type ValidationMode string
+
+const (
+	Validation_HEAD     ValidationMode = "head"     // HEAD 请求，2xx 视为可用，默认
+	Validation_RANGE    ValidationMode = "range"    // 请求前 512 字节，2xx 且内容是图片才视为可用，可以识别防盗链返回的网页
+	Validation_NONE     ValidationMode = "none"     // 不校验，URL 始终为原图地址
+	Validation_DEFERRED ValidationMode = "deferred" // 搜索时不校验，由 DownloadResults 在原图请求失败时回退到缩略图
+)
+
+// 按域名缓存校验结果的时长
+const validationCacheTTL = 10 * time.Minute
+
+// WithValidation 设置必应原图地址的校验方式，默认 Validation_HEAD，无法识别的值也按 Validation_HEAD 处理。
+// 校验结果按域名缓存：可用的原图地址和整个域名不可用（防盗链、无法连接）的结果在缓存有效期内不再重复请求。
+// 原图不可用时 Result.URL 为缩略图，原图地址保留在 Result.Original
+func WithValidation(mode ValidationMode) CaptureOption {
+	return func(cfg *captureConfig) {
+		cfg.validation = mode
+	}
+}
+
+// urlValidator 校验原图地址是否可用，结果按域名缓存
+type urlValidator struct {
+	mode    ValidationMode
+	client  *http.Client
+	headers map[string]string
+	logger  Logger
+	tracer  Tracer
+	ttl     time.Duration
+	mu      sync.Mutex
+	hosts   map[string]*hostValidation
+}
+
+// 一个域名的校验结果
+type hostValidation struct {
+	blocked time.Time            // 整个域名不可用（防盗链、无法连接）的缓存到期时间
+	useful  map[string]time.Time // 可用的原图地址及缓存到期时间
+}
+
+func newURLValidator(mode ValidationMode, client *http.Client, headers map[string]string, logger Logger, tracer Tracer) *urlValidator {
+	switch mode {
+	case Validation_RANGE, Validation_NONE, Validation_DEFERRED:
+	default:
+		mode = Validation_HEAD
+	}
+	return &urlValidator{
+		mode:    mode,
+		client:  client,
+		headers: headers,
+		logger:  logger,
+		tracer:  tracer,
+		ttl:     validationCacheTTL,
+		hosts:   make(map[string]*hostValidation),
+	}
+}
+
+// enabled 搜索时是否需要请求原图地址
+func (v *urlValidator) enabled() bool {
+	return v.mode == Validation_HEAD || v.mode == Validation_RANGE
+}
+
+// validate 原图地址是否可用。域名整体不可用时不再请求，直接返回 false
+func (v *urlValidator) validate(ctx context.Context, url string) bool {
+	if url == "" {
+		return false
+	}
+	if !v.enabled() {
+		return true
+	}
+	host := hostOf(url)
+	if useful, ok := v.cached(host, url); ok {
+		return useful
+	}
+	useful, hostWide := v.check(ctx, url)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.hosts[host]
+	if !ok {
+		v.prune()
+		h = &hostValidation{useful: make(map[string]time.Time)}
+		v.hosts[host] = h
+	}
+	if useful {
+		h.useful[url] = time.Now().Add(v.ttl)
+	} else if hostWide {
+		h.blocked = time.Now().Add(v.ttl)
+	}
+	return useful
+}
+
+// 缓存的校验结果，ok 为 false 时需要请求
+func (v *urlValidator) cached(host, url string) (useful, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, found := v.hosts[host]
+	if !found {
+		return false, false
+	}
+	now := time.Now()
+	if now.Before(h.blocked) {
+		return false, true
+	}
+	if expires, found := h.useful[url]; found {
+		if now.Before(expires) {
+			return true, true
+		}
+		delete(h.useful, url)
+	}
+	return false, false
+}
+
+// 域名过多时清理过期的结果，调用方持有锁
+func (v *urlValidator) prune() {
+	if len(v.hosts) < 1024 {
+		return
+	}
+	now := time.Now()
+	for host, h := range v.hosts {
+		for url, expires := range h.useful {
+			if now.After(expires) {
+				delete(h.useful, url)
+			}
+		}
+		if len(h.useful) == 0 && now.After(h.blocked) {
+			delete(v.hosts, host)
+		}
+	}
+}
+
+// check 请求原图地址，hostWide 表示失败原因对整个域名有效，只有防盗链（401、403、非图片内容）。
+// 404 等其他状态码只与这张图片有关，429、5xx、超时和网络错误是临时失败
+func (v *urlValidator) check(ctx context.Context, url string) (useful, hostWide bool) {
+	ctx, span := v.tracer.Start(ctx, SpanCheckURL, "engine", EngineBing, "url", url, "mode", string(v.mode))
+	var err error
+	defer func() {
+		span.SetAttributes("useful", useful)
+		span.End(err)
+	}()
+	method := http.MethodHead
+	if v.mode == Validation_RANGE {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return false, false
+	}
+	for k, val := range v.headers {
+		req.Header.Set(k, val)
+	}
+	if v.mode == Validation_RANGE {
+		req.Header.Set("Range", "bytes=0-511")
+	}
+	start := time.Now()
+	resp, err := v.client.Do(req)
+	if err != nil {
+		v.logger.Debug("check url failed", "engine", EngineBing, "url", url, "duration", time.Since(start), "error", err)
+		// 单次请求超时、连接失败可能只是偶然的，不影响同一域名的其他图片
+		return false, false
+	}
+	defer resp.Body.Close()
+	span.SetAttributes("status", resp.StatusCode)
+	v.logger.Debug("check url", "engine", EngineBing, "url", url, "status", resp.StatusCode, "duration", time.Since(start))
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, true
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return false, false
+	}
+	if v.mode != Validation_RANGE {
+		return true, false
+	}
+	// 防盗链通常返回 200 的网页或占位内容，读取开头的数据识别是否为图片
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(resp.Body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, false
+	}
+	err = nil
+	useful = checkImageType(buf[:n]) != ""
+	return useful, !useful
+}
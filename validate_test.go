@@ -0,0 +1,185 @@
+package imagecapture
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 原图服务：/ok 返回图片，/html 返回网页，/bad 返回 400，/slow 在请求取消前不返回，其他返回 404；
+// 通过 localhost 访问时全部返回 403（防盗链）
+func newValidationServer(t *testing.T) (*httptest.Server, func() []string) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.Header.Get("Range")+" "+hostOf("http://"+r.Host)+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case strings.HasPrefix(r.Host, "localhost"):
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case strings.HasPrefix(r.URL.Path, "/ok"):
+			w.Write(testPNG)
+		case strings.HasPrefix(r.URL.Path, "/html"):
+			w.Write([]byte("<html><body>hotlink</body></html>"))
+		case strings.HasPrefix(r.URL.Path, "/bad"):
+			http.Error(w, "bad request", http.StatusBadRequest)
+		case strings.HasPrefix(r.URL.Path, "/slow"):
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestURLValidator(t *testing.T) {
+	server, _ := newValidationServer(t)
+	tests := []struct {
+		mode ValidationMode
+		path string
+		want bool
+	}{
+		{Validation_HEAD, "/ok.jpg", true},
+		{Validation_HEAD, "/html.jpg", true},
+		{Validation_HEAD, "/missing.jpg", false},
+		{Validation_RANGE, "/ok.jpg", true},
+		{Validation_RANGE, "/html.jpg", false},
+		{Validation_RANGE, "/missing.jpg", false},
+		{Validation_NONE, "/missing.jpg", true},
+		{Validation_DEFERRED, "/missing.jpg", true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode)+tt.path, func(t *testing.T) {
+			v := newURLValidator(tt.mode, http.DefaultClient, nil, nopLogger{}, nopTracer{})
+			if got := v.validate(context.Background(), server.URL+tt.path); got != tt.want {
+				t.Errorf("validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLValidator_Cache(t *testing.T) {
+	server, requests := newValidationServer(t)
+	other := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	v := newURLValidator(Validation_RANGE, http.DefaultClient, nil, nopLogger{}, nopTracer{})
+	for _, u := range []string{
+		server.URL + "/ok.jpg", server.URL + "/ok.jpg", // 可用的地址只请求一次
+		server.URL + "/missing.jpg", server.URL + "/missing.jpg", // 404 只与这张图片有关，每次都请求
+		other + "/ok.jpg", other + "/ok2.jpg", // 403 对整个域名有效，同一域名的其他图片不再请求
+	} {
+		v.validate(context.Background(), u)
+	}
+	want := []string{
+		"GET bytes=0-511 127.0.0.1/ok.jpg",
+		"GET bytes=0-511 127.0.0.1/missing.jpg",
+		"GET bytes=0-511 127.0.0.1/missing.jpg",
+		"GET bytes=0-511 localhost/ok.jpg",
+	}
+	if got := requests(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+// 超时、网络错误和 401、403 以外的状态码只影响当前图片
+func TestURLValidator_Transient(t *testing.T) {
+	server, _ := newValidationServer(t)
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	for _, path := range []string{"/slow.jpg", "/bad.jpg"} {
+		t.Run(path, func(t *testing.T) {
+			v := newURLValidator(Validation_RANGE, client, nil, nopLogger{}, nopTracer{})
+			if v.validate(context.Background(), server.URL+path) {
+				t.Errorf("validate(%s) = true", path)
+			}
+			if !v.validate(context.Background(), server.URL+"/ok.jpg") {
+				t.Errorf("validate() after %s = false", path)
+			}
+		})
+	}
+}
+
+func TestBingCapture_Validation(t *testing.T) {
+	server, _ := newBingServer(t)
+	tests := []struct {
+		mode ValidationMode
+		want []string // 按 Original 排序后的 URL
+	}{
+		{Validation_HEAD, []string{server.URL + "/full.jpg", server.URL + "/thumb2.jpg"}},
+		{Validation_NONE, []string{server.URL + "/full.jpg", server.URL + "/missing.jpg"}},
+		{Validation_DEFERRED, []string{server.URL + "/full.jpg", server.URL + "/missing.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			bc := NewBingCapture(1, WithValidation(tt.mode)).(*BingCapture)
+			defer bc.Close()
+			bc.baseUrl = server.URL + "/images/async"
+			results, err := bc.Search("老虎", 2)
+			if err != nil || len(results) != 2 {
+				t.Fatalf("Search() = %v, %v", results, err)
+			}
+			if results[0].Original > results[1].Original {
+				results[0], results[1] = results[1], results[0]
+			}
+			for i, r := range results {
+				if r.URL != tt.want[i] || r.Original == "" || r.Thumbnail == "" {
+					t.Errorf("Search() result = %+v, want url %s", r, tt.want[i])
+				}
+			}
+			if tt.mode != Validation_DEFERRED {
+				return
+			}
+			// 下载时原图 404，改为下载缩略图
+			var reports []DownloadReport
+			var mu sync.Mutex
+			paths, err := bc.DownloadResults(results, t.TempDir(), false, WithReportHook(func(r DownloadReport) {
+				mu.Lock()
+				reports = append(reports, r)
+				mu.Unlock()
+			}))
+			if err != nil || len(paths) != 2 {
+				t.Fatalf("DownloadResults() = %v, %v", paths, err)
+			}
+			for _, r := range reports {
+				if r.Err != nil {
+					t.Errorf("report = %+v", r)
+				}
+				if _, err := os.Stat(r.Path); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+// 原图校验失败且没有缩略图时保留原图地址
+func TestBingCapture_ValidationWithoutThumbnail(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/images/async", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><body><a class="iusc" m='{"murl":"%s/missing.jpg","t":"老虎"}'></a></body></html>`, server.URL)
+	})
+	bc := NewBingCapture(1, WithValidation(Validation_HEAD)).(*BingCapture)
+	defer bc.Close()
+	bc.baseUrl = server.URL + "/images/async"
+	results, err := bc.Search("老虎", 1)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search() = %v, %v", results, err)
+	}
+	if r := results[0]; r.URL != server.URL+"/missing.jpg" || r.Thumbnail != "" {
+		t.Errorf("Search() result = %+v", r)
+	}
+}
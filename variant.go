@@ -0,0 +1,133 @@
+package imagecapture
+
+import (
+	"io"
+)
+
+/*
+* @Author: zouyx
+* @Email: 1003941268@qq.com
+* @Date:   2024/12/16 下午3:00
+* @Package: 原图失败时回退到中等尺寸图、缩略图
+ */
+
+// ImageVariant 保存的图片版本
+type ImageVariant string
+
+const (
+	Variant_ORIGINAL  ImageVariant = "original"  // 原图
+	Variant_MIDDLE    ImageVariant = "middle"    // 中等尺寸图，百度提供
+	Variant_THUMBNAIL ImageVariant = "thumbnail" // 缩略图
+)
+
+// 默认依次尝试原图、中等尺寸图、缩略图
+var defaultVariants = []ImageVariant{Variant_ORIGINAL, Variant_MIDDLE, Variant_THUMBNAIL}
+
+// WithVariants 设置 DownloadResults 依次尝试的图片版本，默认原图、中等尺寸图、缩略图。
+// 前一个版本请求失败（4xx、5xx、超时、网络错误、内容不是图片）时尝试下一个，WithVariants(Variant_ORIGINAL) 关闭回退
+func WithVariants(variants ...ImageVariant) DownloadOption {
+	return func(cfg *downloadConfig) {
+		cfg.variants = variants
+	}
+}
+
+// 版本对应的图片地址
+func (r Result) variantURL(v ImageVariant) string {
+	switch v {
+	case Variant_MIDDLE:
+		return r.Middle
+	case Variant_THUMBNAIL:
+		return r.Thumbnail
+	}
+	if r.Original != "" {
+		return r.Original
+	}
+	return r.URL
+}
+
+// 图片地址对应的版本，无法识别时视为原图
+func (r Result) variantOf(url string) ImageVariant {
+	switch url {
+	case r.Original:
+		return Variant_ORIGINAL
+	case r.Middle:
+		return Variant_MIDDLE
+	case r.Thumbnail:
+		return Variant_THUMBNAIL
+	}
+	return Variant_ORIGINAL
+}
+
+// 待下载的一个图片版本
+type variantURL struct {
+	variant ImageVariant
+	url     string
+}
+
+// fallbackURLs 下载 url 依次尝试的地址：先是 url 本身，再按 variants 的顺序尝试比它小的版本。
+// 比 url 大的版本已经在搜索时校验失败（例如必应 URL 为缩略图），不再尝试
+func fallbackURLs(url string, source Result, variants []ImageVariant) []variantURL {
+	if source.URL == "" {
+		source.URL = url
+	}
+	if variants == nil {
+		variants = defaultVariants
+	}
+	rank := func(v ImageVariant) int {
+		for i, dv := range defaultVariants {
+			if dv == v {
+				return i
+			}
+		}
+		return len(defaultVariants)
+	}
+	first := source.variantOf(url)
+	urls := []variantURL{{variant: first, url: url}}
+	for _, v := range variants {
+		u := source.variantURL(v)
+		if u == "" || rank(v) < rank(first) {
+			continue
+		}
+		seen := false
+		for _, c := range urls {
+			seen = seen || c.url == u
+		}
+		if !seen {
+			urls = append(urls, variantURL{variant: v, url: u})
+		}
+	}
+	return urls
+}
+
+// 记录写入的字节数，已经写入数据后不能再回退到其他版本
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// DownloadResult 与 Download 相同，原图请求失败时依次下载搜索结果的中等尺寸图、缩略图，返回保存的版本
+func (d *downloader) DownloadResult(result Result, filename string, writer io.Writer) (fileSuffix string, variant ImageVariant, err error) {
+	for _, c := range fallbackURLs(result.URL, result, nil) {
+		var (
+			w  io.Writer
+			cw countingWriter
+		)
+		if writer != nil {
+			cw.w = writer
+			w = &cw
+		}
+		variant = c.variant
+		fileSuffix, err = d.Download(c.url, filename, w)
+		if err == nil || !fetchFailed(err) || cw.n > 0 {
+			return
+		}
+		d.logger.Debug("download fallback", "engine", d.engine, "url", c.url, "variant", c.variant, "error", err)
+	}
+	return
+}
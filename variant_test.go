@@ -0,0 +1,128 @@
+package imagecapture
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFallbackURLs(t *testing.T) {
+	baidu := Result{URL: "o", Original: "o", Middle: "m", Thumbnail: "t"}
+	tests := []struct {
+		name     string
+		url      string
+		source   Result
+		variants []ImageVariant
+		want     []variantURL
+	}{
+		{"all variants", "o", baidu, nil, []variantURL{{Variant_ORIGINAL, "o"}, {Variant_MIDDLE, "m"}, {Variant_THUMBNAIL, "t"}}},
+		{"original only", "o", baidu, []ImageVariant{Variant_ORIGINAL}, []variantURL{{Variant_ORIGINAL, "o"}}},
+		{"skip middle", "o", baidu, []ImageVariant{Variant_ORIGINAL, Variant_THUMBNAIL}, []variantURL{{Variant_ORIGINAL, "o"}, {Variant_THUMBNAIL, "t"}}},
+		// 必应原图校验失败时 URL 为缩略图，不再尝试原图
+		{"url is thumbnail", "t", Result{URL: "t", Original: "o", Thumbnail: "t"}, nil, []variantURL{{Variant_THUMBNAIL, "t"}}},
+		{"no original field", "u", Result{URL: "u", Thumbnail: "t"}, nil, []variantURL{{Variant_ORIGINAL, "u"}, {Variant_THUMBNAIL, "t"}}},
+		{"same middle and thumbnail", "o", Result{URL: "o", Middle: "t", Thumbnail: "t"}, nil, []variantURL{{Variant_ORIGINAL, "o"}, {Variant_MIDDLE, "t"}}},
+		// BatchDownload 只有图片地址
+		{"url only", "u", Result{}, nil, []variantURL{{Variant_ORIGINAL, "u"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fallbackURLs(tt.url, tt.source, tt.variants); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fallbackURLs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 路径以 /ok 开头的返回图片，/html 返回防盗链网页，/forbidden 返回 403，其他返回 404
+func newVariantServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/ok"):
+			w.Write(testPNG)
+		case strings.HasPrefix(r.URL.Path, "/html"):
+			w.Write([]byte("<html><body>hotlink</body></html>"))
+		case strings.HasPrefix(r.URL.Path, "/forbidden"):
+			http.Error(w, "forbidden", http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadResults_Fallback(t *testing.T) {
+	server := newVariantServer(t)
+	result := func(original, middle, thumbnail string) Result {
+		return Result{URL: server.URL + original, Original: server.URL + original,
+			Middle: server.URL + middle, Thumbnail: server.URL + thumbnail, Engine: EngineBaidu}
+	}
+	tests := []struct {
+		name     string
+		result   Result
+		variants []ImageVariant
+		want     ImageVariant
+		wantErr  bool
+	}{
+		{"original", result("/ok1.jpg", "/ok1-m.jpg", "/ok1-t.jpg"), nil, Variant_ORIGINAL, false},
+		{"middle", result("/missing2.jpg", "/ok2-m.jpg", "/ok2-t.jpg"), nil, Variant_MIDDLE, false},
+		{"thumbnail", result("/missing3.jpg", "/forbidden3-m.jpg", "/ok3-t.jpg"), nil, Variant_THUMBNAIL, false},
+		{"all failed", result("/missing4.jpg", "/forbidden4-m.jpg", "/missing4-t.jpg"), nil, Variant_THUMBNAIL, true},
+		// 原图返回 200 的网页，不能当作图片保存
+		{"html original", result("/html6.jpg", "/missing6-m.jpg", "/ok6-t.jpg"), nil, Variant_THUMBNAIL, false},
+		{"fallback disabled", result("/missing5.jpg", "/ok5-m.jpg", "/ok5-t.jpg"), []ImageVariant{Variant_ORIGINAL}, Variant_ORIGINAL, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDownloader(http.DefaultClient, nil).(*downloader)
+			defer d.Close()
+			var (
+				mu      sync.Mutex
+				reports []DownloadReport
+			)
+			opts := []DownloadOption{WithReportHook(func(r DownloadReport) {
+				mu.Lock()
+				reports = append(reports, r)
+				mu.Unlock()
+			})}
+			if tt.variants != nil {
+				opts = append(opts, WithVariants(tt.variants...))
+			}
+			paths, err := d.DownloadResults([]Result{tt.result}, t.TempDir(), true, opts...)
+			wantPaths := 1
+			if tt.wantErr {
+				wantPaths = 0
+			}
+			if err != nil || len(paths) != wantPaths {
+				t.Fatalf("DownloadResults() = %v, %v", paths, err)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("reports = %+v", reports)
+			}
+			r := reports[0]
+			// 报告中记录搜索结果的地址和实际保存的版本
+			if r.URL != tt.result.URL || r.Variant != tt.want || (r.Err != nil) != tt.wantErr {
+				t.Errorf("report = %+v, want variant %s", r, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadResult(t *testing.T) {
+	server := newVariantServer(t)
+	d := newDownloader(http.DefaultClient, nil).(*downloader)
+	defer d.Close()
+	var buf bytes.Buffer
+	suffix, variant, err := d.DownloadResult(Result{URL: server.URL + "/missing.jpg", Thumbnail: server.URL + "/ok-t.jpg"}, "", &buf)
+	if err != nil || suffix != "png" || variant != Variant_THUMBNAIL {
+		t.Fatalf("DownloadResult() = %s, %s, %v", suffix, variant, err)
+	}
+	if !bytes.Equal(buf.Bytes(), testPNG) {
+		t.Errorf("DownloadResult() wrote %d bytes, want %d", buf.Len(), len(testPNG))
+	}
+}